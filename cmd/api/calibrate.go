@@ -0,0 +1,58 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/dvher/nibbin.cl_back/internal/config"
+	"github.com/dvher/nibbin.cl_back/pkg/argon2"
+)
+
+func runCalibrate(cfg *config.Config, args []string) {
+
+	fs := flag.NewFlagSet("calibrate", flag.ExitOnError)
+
+	target := fs.Duration("target", 500*time.Millisecond, "how long hashing a password may take")
+	memory := fs.Uint("memory", 64, "memory budget per hash in MiB")
+	threads := fs.Uint("threads", 2, "threads per hash")
+
+	fs.Parse(args)
+
+	if *threads == 0 || *memory == 0 {
+		log.Fatal("usage: server calibrate [-target 500ms] [-memory MiB] [-threads n]")
+	}
+
+	// Stay within what the server accepts at startup.
+	policy := cfg.Argon2.Policy()
+
+	if *threads > uint(policy.MaxThreads) {
+		log.Printf("Limiting threads to %d\n", policy.MaxThreads)
+		*threads = uint(policy.MaxThreads)
+	}
+
+	if *memory > uint(policy.MaxMemory/1024) {
+		log.Printf("Limiting memory to %d MiB\n", policy.MaxMemory/1024)
+		*memory = uint(policy.MaxMemory / 1024)
+	}
+
+	log.Printf("Calibrating for %v with up to %d MiB and %d threads\n", *target, *memory, *threads)
+
+	cost, err := argon2.Calibrate(*target, policy, uint32(*memory)*1024, uint8(*threads))
+
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	out, err := json.MarshalIndent(map[string]config.Argon2{
+		"argon2": {Memory: cost.Memory, Time: cost.Time, Threads: cost.Threads},
+	}, "", "  ")
+
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	fmt.Printf("ARGON2_MEMORY=%d\nARGON2_TIME=%d\nARGON2_THREADS=%d\n\n%s\n", cost.Memory, cost.Time, cost.Threads, out)
+}
@@ -1,39 +1,188 @@
 package main
 
 import (
-	"flag"
+	"context"
+	"database/sql"
 	"log"
+	"net/http"
 	"os"
 	"os/signal"
 	"syscall"
+	"time"
 
+	"github.com/dvher/nibbin.cl_back/internal/config"
 	"github.com/dvher/nibbin.cl_back/internal/database"
+	"github.com/dvher/nibbin.cl_back/internal/mailer"
+	"github.com/dvher/nibbin.cl_back/internal/outbox"
+	"github.com/dvher/nibbin.cl_back/internal/repository"
 	"github.com/dvher/nibbin.cl_back/internal/server"
+	"github.com/dvher/nibbin.cl_back/internal/sessionstore"
+	"github.com/dvher/nibbin.cl_back/pkg/argon2"
+	"github.com/dvher/nibbin.cl_back/templates"
 	_ "github.com/joho/godotenv/autoload"
 )
 
 func main() {
 
-	port := flag.String("port", ":8080", "port to listen on")
-	flag.Parse()
+	cfg, err := config.Load(os.Args[1:])
 
-	sig := make(chan os.Signal, 1)
+	if err != nil {
+		log.Fatal(err)
+	}
 
-	signal.Notify(sig, syscall.SIGINT, syscall.SIGTERM)
+	if len(cfg.Args) > 0 && cfg.Args[0] == "migrate" {
+		runMigrate(cfg, cfg.Args[1:])
+		return
+	}
 
-	router := server.New()
+	if len(cfg.Args) > 0 && cfg.Args[0] == "calibrate" {
+		runCalibrate(cfg, cfg.Args[1:])
+		return
+	}
 
-	go func() {
-		<-sig
-		err := database.DB.Close()
+	if err := cfg.Validate(); err != nil {
+		log.Fatal(err)
+	}
+
+	peppers, err := argon2.KeyringFromEnv()
+
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	argon2.SetDefaultKeyring(peppers)
+
+	tmpl, err := templates.Parse()
+
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	db, err := database.Connect(cfg.Database)
+
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	log.Println("Connected to database")
+
+	if err := database.CheckSchema(context.Background(), db); err != nil {
+		db.Close()
+		log.Fatal(err, ", run `server migrate up` first")
+	}
 
-		if err != nil {
-			log.Println(err)
-		}
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
 
-		os.Exit(1)
+	m, err := mailer.New(cfg)
+
+	if err != nil {
+		db.Close()
+		log.Fatal(err)
+	}
+
+	mail := outbox.New(outbox.NewMySQLStore(db), m, time.Duration(cfg.MailMaxAge)*time.Second)
+
+	deps := newDeps(cfg, db, mail)
+	deps.Templates = tmpl
+
+	handler, err := server.New(cfg, deps)
+
+	if err != nil {
+		db.Close()
+		log.Fatal(err)
+	}
+
+	srv := &http.Server{
+		Addr:         cfg.Addr,
+		Handler:      handler,
+		ReadTimeout:  seconds(cfg.Timeouts.Read),
+		WriteTimeout: seconds(cfg.Timeouts.Write),
+		IdleTimeout:  seconds(cfg.Timeouts.Idle),
+	}
+
+	errc := make(chan error, 1)
+
+	go func() {
+		errc <- srv.ListenAndServe()
 	}()
 
-	log.Fatal(router.Run(*port))
+	code := 0
+
+	select {
+	case err := <-errc:
+		log.Println("Error serving", err)
+		code = 1
+	case <-ctx.Done():
+		log.Println("Shutting down")
+	}
+
+	stop()
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), seconds(cfg.Timeouts.Shutdown))
+	defer cancel()
+
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		log.Println("Error draining requests", err)
+		code = 1
+	}
+
+	if err := deps.OTPs.Close(); err != nil {
+		log.Println("Error stopping the OTP store", err)
+	}
+
+	if err := deps.Sessions.Close(); err != nil {
+		log.Println("Error stopping the session store", err)
+	}
+
+	if err := mail.Close(); err != nil {
+		log.Println("Error stopping the outbox", err)
+	}
+
+	if err := m.Close(); err != nil {
+		log.Println("Error closing the mailer", err)
+	}
+
+	if err := db.Close(); err != nil {
+		log.Println("Error closing the database", err)
+		code = 1
+	}
+
+	log.Println("Server stopped")
+
+	os.Exit(code)
+}
+
+func newDeps(cfg *config.Config, db *sql.DB, mail server.Outbox) server.Deps {
+	var otps server.OTPStore
+
+	if cfg.OTPStore == "memory" {
+		otps = server.NewMemoryOTPStore()
+	} else {
+		otps = server.NewMySQLOTPStore(db)
+	}
+
+	return server.Deps{
+		Users:       repository.NewMySQLUserRepository(db),
+		Products:    repository.NewMySQLProductRepository(db),
+		Favorites:   repository.NewMySQLFavoriteRepository(db),
+		Admins:      repository.NewMySQLAdminRepository(db),
+		Credentials: repository.NewMySQLCredentialRepository(db),
+		Tokens:      repository.NewMySQLTokenRepository(db),
+		OTPs:        otps,
+		Sessions:    sessionstore.NewMySQLStore(db, sessionKeys(cfg.Session)...),
+		Outbox:      mail,
+	}
+}
+
+func sessionKeys(cfg config.Session) [][]byte {
+	if cfg.EncryptionKey == "" {
+		return [][]byte{[]byte(cfg.Key)}
+	}
+
+	return [][]byte{[]byte(cfg.Key), []byte(cfg.EncryptionKey)}
+}
 
+func seconds(n int) time.Duration {
+	return time.Duration(n) * time.Second
 }
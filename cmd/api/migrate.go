@@ -0,0 +1,93 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strconv"
+
+	"github.com/dvher/nibbin.cl_back/internal/config"
+	"github.com/dvher/nibbin.cl_back/internal/database"
+)
+
+const migrateUsage = "usage: server migrate [up | down [steps] | status | force]"
+
+func runMigrate(cfg *config.Config, args []string) {
+
+	if len(args) == 0 {
+		log.Fatal(migrateUsage)
+	}
+
+	if err := cfg.Database.Validate(); err != nil {
+		log.Fatal(err)
+	}
+
+	db, err := database.ConnectForMigrations(cfg.Database)
+
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	defer db.Close()
+
+	ctx := context.Background()
+
+	switch args[0] {
+	case "up":
+		n, err := database.MigrateUp(ctx, db)
+
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		log.Printf("Applied %d migrations\n", n)
+
+	case "down":
+		steps := 1
+
+		if len(args) > 1 {
+			steps, err = strconv.Atoi(args[1])
+
+			if err != nil || steps < 1 {
+				log.Fatal(migrateUsage)
+			}
+		}
+
+		n, err := database.MigrateDown(ctx, db, steps)
+
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		log.Printf("Reverted %d migrations\n", n)
+
+	case "status":
+		current, err := database.SchemaVersion(ctx, db)
+
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		latest, err := database.LatestVersion()
+
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		fmt.Printf("Schema version %d, latest %d\n", current, latest)
+
+		if err := database.CheckClean(ctx, db); err != nil {
+			fmt.Println(err)
+		}
+
+	case "force":
+		if err := database.ClearDirty(ctx, db); err != nil {
+			log.Fatal(err)
+		}
+
+		log.Println("Cleared interrupted migrations")
+
+	default:
+		log.Fatal(migrateUsage)
+	}
+}
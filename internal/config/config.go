@@ -0,0 +1,500 @@
+package config
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/dvher/nibbin.cl_back/pkg/argon2"
+)
+
+const (
+	EnvDevelopment = "development"
+	EnvProduction  = "production"
+)
+
+type Database struct {
+	User     string `json:"user"`
+	Password string `json:"password"`
+	Host     string `json:"host"`
+	Port     int    `json:"port"`
+	Name     string `json:"name"`
+}
+
+type SMTP struct {
+	User     string `json:"user"`
+	Password string `json:"password"`
+	Host     string `json:"host"`
+	Port     int    `json:"port"`
+}
+
+type Session struct {
+	Key           string `json:"key"`
+	EncryptionKey string `json:"encryptionKey"`
+	MaxAge        int    `json:"maxAge"`
+	Domain        string `json:"domain"`
+	Secure        bool   `json:"secure"`
+}
+
+// Timeouts are in seconds. Shutdown bounds how long in-flight requests
+// get to finish once the server is asked to stop.
+type Timeouts struct {
+	Read     int `json:"read"`
+	Write    int `json:"write"`
+	Idle     int `json:"idle"`
+	Shutdown int `json:"shutdown"`
+}
+
+// Argon2 holds the cost of new password hashes, Memory is in KiB. Use
+// `server calibrate` to pick them for a machine. MaxMemory is the most a
+// stored hash may ask for and Concurrency how many hashes run at once.
+type Argon2 struct {
+	Memory      uint32 `json:"memory"`
+	Time        uint32 `json:"time"`
+	Threads     uint8  `json:"threads"`
+	MaxMemory   uint32 `json:"maxMemory"`
+	Concurrency int    `json:"concurrency"`
+}
+
+// HashConfig returns the argon2 parameters new hashes are made with.
+func (a Argon2) HashConfig() *argon2.Config {
+	config := argon2.DefaultConfig()
+	config.Memory = a.Memory
+	config.Time = a.Time
+	config.Threads = a.Threads
+
+	return config
+}
+
+// Policy returns the bounds stored hashes, and the configured cost, must
+// stay within.
+func (a Argon2) Policy() argon2.Policy {
+	policy := argon2.DefaultPolicy()
+	policy.MaxMemory = a.MaxMemory
+
+	return policy
+}
+
+// WebAuthn identifies the relying party passkeys are bound to. Origins
+// defaults to the CORS origins.
+type WebAuthn struct {
+	RPID    string   `json:"rpId"`
+	Origins []string `json:"origins"`
+}
+
+type Config struct {
+	Env         string   `json:"env"`
+	Addr        string   `json:"addr"`
+	Timeouts    Timeouts `json:"timeouts"`
+	Database    Database `json:"database"`
+	Mailer      string   `json:"mailer"`
+	MailDir     string   `json:"mailDir"`
+	MailMaxAge  int      `json:"mailMaxAge"`
+	SMTP        SMTP     `json:"smtp"`
+	AdminEmail  string   `json:"adminEmail"`
+	Session     Session  `json:"session"`
+	CSRFSecret  string   `json:"csrfSecret"`
+	CORSOrigins []string `json:"corsOrigins"`
+	WebAuthn    WebAuthn `json:"webauthn"`
+	Argon2      Argon2   `json:"argon2"`
+	OTPStore    string   `json:"otpStore"`
+
+	// Args holds the command line arguments left after the flags.
+	Args []string `json:"-"`
+}
+
+// ValidationError lists every problem found in a Config.
+type ValidationError []string
+
+func (e ValidationError) Error() string {
+	return "invalid configuration: " + strings.Join(e, "; ")
+}
+
+func Default() *Config {
+	return &Config{
+		Env:  EnvDevelopment,
+		Addr: ":8080",
+		Timeouts: Timeouts{
+			Read:     15,
+			Write:    30,
+			Idle:     60,
+			Shutdown: 20,
+		},
+		Database: Database{
+			Port: 3306,
+		},
+		Mailer:     "smtp",
+		MailDir:    "mail",
+		MailMaxAge: 7 * 86400,
+		SMTP: SMTP{
+			Port: 587,
+		},
+		Session: Session{
+			MaxAge: 86400,
+			Domain: "localhost",
+		},
+		CORSOrigins: []string{"http://localhost:3000", "http://nibbin.cl:3000"},
+		OTPStore:    "mysql",
+		WebAuthn: WebAuthn{
+			RPID: "localhost",
+		},
+		Argon2: Argon2{
+			Memory:      6 * 1024,
+			Time:        3,
+			Threads:     2,
+			MaxMemory:   256 * 1024,
+			Concurrency: 4,
+		},
+	}
+}
+
+// Load builds the configuration from, in increasing priority, the defaults,
+// the JSON file given by -config or CONFIG_FILE, the environment and the
+// command line flags. Callers validate the parts they need.
+func Load(args []string) (*Config, error) {
+	cfg := Default()
+
+	fs := flag.NewFlagSet("server", flag.ContinueOnError)
+
+	file := fs.String("config", os.Getenv("CONFIG_FILE"), "path to a JSON configuration file")
+	addr := fs.String("port", "", "address to listen on")
+	env := fs.String("env", "", "environment, development or production")
+
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+
+	if *file != "" {
+		if err := cfg.loadFile(*file); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := cfg.loadEnv(); err != nil {
+		return nil, err
+	}
+
+	if *addr != "" {
+		cfg.Addr = *addr
+	}
+
+	if *env != "" {
+		cfg.Env = *env
+	}
+
+	cfg.Args = fs.Args()
+
+	return cfg, nil
+}
+
+func (cfg *Config) loadFile(path string) error {
+	f, err := os.Open(path)
+
+	if err != nil {
+		return fmt.Errorf("opening config file: %w", err)
+	}
+
+	defer f.Close()
+
+	dec := json.NewDecoder(f)
+	dec.DisallowUnknownFields()
+
+	if err := dec.Decode(cfg); err != nil {
+		return fmt.Errorf("parsing config file %s: %w", path, err)
+	}
+
+	return nil
+}
+
+func (cfg *Config) loadEnv() error {
+	var errs ValidationError
+
+	str := func(name string, dst *string) {
+		if v, ok := os.LookupEnv(name); ok {
+			*dst = v
+		}
+	}
+
+	integer := func(name string, dst *int) {
+		if v, ok := os.LookupEnv(name); ok {
+			n, err := strconv.Atoi(v)
+
+			if err != nil {
+				errs = append(errs, fmt.Sprintf("%s must be an integer", name))
+				return
+			}
+
+			*dst = n
+		}
+	}
+
+	boolean := func(name string, dst *bool) {
+		if v, ok := os.LookupEnv(name); ok {
+			b, err := strconv.ParseBool(v)
+
+			if err != nil {
+				errs = append(errs, fmt.Sprintf("%s must be a boolean", name))
+				return
+			}
+
+			*dst = b
+		}
+	}
+
+	uinteger := func(name string, dst *uint32, bits int) {
+		if v, ok := os.LookupEnv(name); ok {
+			n, err := strconv.ParseUint(v, 10, bits)
+
+			if err != nil {
+				errs = append(errs, fmt.Sprintf("%s must be a positive integer below %d", name, uint64(1)<<bits))
+				return
+			}
+
+			*dst = uint32(n)
+		}
+	}
+
+	list := func(name string, dst *[]string) {
+		if v, ok := os.LookupEnv(name); ok {
+			*dst = nil
+
+			for _, item := range strings.Split(v, ",") {
+				if item = strings.TrimSpace(item); item != "" {
+					*dst = append(*dst, item)
+				}
+			}
+		}
+	}
+
+	str("APP_ENV", &cfg.Env)
+	str("ADDR", &cfg.Addr)
+	integer("READ_TIMEOUT", &cfg.Timeouts.Read)
+	integer("WRITE_TIMEOUT", &cfg.Timeouts.Write)
+	integer("IDLE_TIMEOUT", &cfg.Timeouts.Idle)
+	integer("SHUTDOWN_TIMEOUT", &cfg.Timeouts.Shutdown)
+
+	str("DB_USER", &cfg.Database.User)
+	str("DB_PASS", &cfg.Database.Password)
+	str("DB_ADDR", &cfg.Database.Host)
+	integer("DB_PORT", &cfg.Database.Port)
+	str("DB_NAME", &cfg.Database.Name)
+
+	str("MAILER", &cfg.Mailer)
+	str("MAIL_DIR", &cfg.MailDir)
+	integer("MAIL_MAX_AGE", &cfg.MailMaxAge)
+
+	str("SMTP_USER", &cfg.SMTP.User)
+	str("SMTP_PASS", &cfg.SMTP.Password)
+	str("SMTP_HOST", &cfg.SMTP.Host)
+	integer("SMTP_PORT", &cfg.SMTP.Port)
+
+	str("ADMIN_EMAIL", &cfg.AdminEmail)
+
+	str("SESSION_KEY", &cfg.Session.Key)
+	str("SESSION_ENC", &cfg.Session.EncryptionKey)
+	integer("SESSION_MAX_AGE", &cfg.Session.MaxAge)
+	str("COOKIE_DOMAIN", &cfg.Session.Domain)
+	boolean("COOKIE_SECURE", &cfg.Session.Secure)
+
+	str("CSRF_SECRET", &cfg.CSRFSecret)
+	list("CORS_ORIGINS", &cfg.CORSOrigins)
+	str("OTP_STORE", &cfg.OTPStore)
+
+	str("WEBAUTHN_RP_ID", &cfg.WebAuthn.RPID)
+	list("WEBAUTHN_ORIGINS", &cfg.WebAuthn.Origins)
+
+	uinteger("ARGON2_MEMORY", &cfg.Argon2.Memory, 32)
+	uinteger("ARGON2_TIME", &cfg.Argon2.Time, 32)
+
+	threads := uint32(cfg.Argon2.Threads)
+	uinteger("ARGON2_THREADS", &threads, 8)
+	cfg.Argon2.Threads = uint8(threads)
+
+	uinteger("ARGON2_MAX_MEMORY", &cfg.Argon2.MaxMemory, 32)
+	integer("ARGON2_CONCURRENCY", &cfg.Argon2.Concurrency)
+
+	if len(errs) > 0 {
+		return errs
+	}
+
+	return nil
+}
+
+func (cfg *Config) Validate() error {
+	var errs ValidationError
+
+	required := func(name, value string) {
+		if value == "" {
+			errs = append(errs, name+" is required")
+		}
+	}
+
+	if cfg.Env != EnvDevelopment && cfg.Env != EnvProduction {
+		errs = append(errs, fmt.Sprintf("env must be %q or %q", EnvDevelopment, EnvProduction))
+	}
+
+	required("addr", cfg.Addr)
+
+	positive := func(name string, value int) {
+		if value <= 0 {
+			errs = append(errs, name+" must be positive")
+		}
+	}
+
+	positive("read timeout (READ_TIMEOUT)", cfg.Timeouts.Read)
+	positive("write timeout (WRITE_TIMEOUT)", cfg.Timeouts.Write)
+	positive("idle timeout (IDLE_TIMEOUT)", cfg.Timeouts.Idle)
+	positive("shutdown timeout (SHUTDOWN_TIMEOUT)", cfg.Timeouts.Shutdown)
+
+	errs = append(errs, cfg.Database.validate()...)
+
+	switch cfg.Mailer {
+	case "smtp":
+		required("smtp user (SMTP_USER)", cfg.SMTP.User)
+		required("smtp host (SMTP_HOST)", cfg.SMTP.Host)
+
+		if cfg.SMTP.Port <= 0 || cfg.SMTP.Port > 65535 {
+			errs = append(errs, "smtp port (SMTP_PORT) must be between 1 and 65535")
+		}
+	case "file":
+		required("mail directory (MAIL_DIR)", cfg.MailDir)
+	case "memory":
+	default:
+		errs = append(errs, "mailer (MAILER) must be smtp, file or memory")
+	}
+
+	positive("mail max age (MAIL_MAX_AGE)", cfg.MailMaxAge)
+
+	required("admin email (ADMIN_EMAIL)", cfg.AdminEmail)
+
+	required("session key (SESSION_KEY)", cfg.Session.Key)
+
+	switch len(cfg.Session.EncryptionKey) {
+	case 0, 16, 24, 32:
+	default:
+		errs = append(errs, "session encryption key (SESSION_ENC) must be 16, 24 or 32 bytes long")
+	}
+
+	positive("session max age (SESSION_MAX_AGE)", cfg.Session.MaxAge)
+
+	required("csrf secret (CSRF_SECRET)", cfg.CSRFSecret)
+
+	if len(cfg.CORSOrigins) == 0 {
+		errs = append(errs, "at least one CORS origin (CORS_ORIGINS) is required")
+	}
+
+	for _, origin := range cfg.CORSOrigins {
+		u, err := url.Parse(origin)
+
+		if err != nil || u.Scheme == "" || u.Host == "" {
+			errs = append(errs, fmt.Sprintf("invalid CORS origin %q", origin))
+		}
+	}
+
+	required("webauthn relying party (WEBAUTHN_RP_ID)", cfg.WebAuthn.RPID)
+
+	for _, origin := range cfg.WebAuthn.Origins {
+		u, err := url.Parse(origin)
+
+		if err != nil || u.Scheme == "" || u.Host == "" {
+			errs = append(errs, fmt.Sprintf("invalid WebAuthn origin %q", origin))
+		}
+	}
+
+	if err := cfg.Argon2.Policy().Check(cfg.Argon2.HashConfig()); err != nil {
+		errs = append(errs, fmt.Sprintf("argon2 cost (ARGON2_MEMORY, ARGON2_TIME, ARGON2_THREADS) rejected: %v", err))
+	}
+
+	if cfg.Argon2.Memory < 8*uint32(cfg.Argon2.Threads) {
+		errs = append(errs, "argon2 memory (ARGON2_MEMORY) must be at least 8 KiB per thread")
+	}
+
+	if cfg.Argon2.Memory > cfg.Argon2.MaxMemory {
+		errs = append(errs, "argon2 memory (ARGON2_MEMORY) can't exceed ARGON2_MAX_MEMORY")
+	}
+
+	positive("argon2 concurrency (ARGON2_CONCURRENCY)", cfg.Argon2.Concurrency)
+
+	if cfg.OTPStore != "mysql" && cfg.OTPStore != "memory" {
+		errs = append(errs, "otp store (OTP_STORE) must be mysql or memory")
+	}
+
+	if cfg.Env == EnvProduction {
+		if !cfg.Session.Secure {
+			errs = append(errs, "secure cookies (COOKIE_SECURE) are required in production")
+		}
+
+		if cfg.OTPStore == "memory" {
+			errs = append(errs, "the memory otp store can't be used in production")
+		}
+
+		if cfg.Mailer != "smtp" {
+			errs = append(errs, "only the smtp mailer can be used in production")
+		}
+
+		for _, origin := range cfg.CORSOrigins {
+			if u, err := url.Parse(origin); err == nil && u.Scheme != "https" {
+				errs = append(errs, fmt.Sprintf("CORS origin %q must use https in production", origin))
+			}
+		}
+	}
+
+	if len(errs) > 0 {
+		return errs
+	}
+
+	return nil
+}
+
+// Validate checks only the database settings, for commands that need
+// nothing else.
+func (db Database) Validate() error {
+	if errs := db.validate(); len(errs) > 0 {
+		return errs
+	}
+
+	return nil
+}
+
+func (db Database) validate() ValidationError {
+	var errs ValidationError
+
+	if db.User == "" {
+		errs = append(errs, "database user (DB_USER) is required")
+	}
+
+	if db.Host == "" {
+		errs = append(errs, "database host (DB_ADDR) is required")
+	}
+
+	if db.Name == "" {
+		errs = append(errs, "database name (DB_NAME) is required")
+	}
+
+	if db.Port <= 0 || db.Port > 65535 {
+		errs = append(errs, "database port (DB_PORT) must be between 1 and 65535")
+	}
+
+	return errs
+}
+
+// WebAuthnOrigins returns the origins passkey ceremonies may come from.
+func (cfg *Config) WebAuthnOrigins() []string {
+	if len(cfg.WebAuthn.Origins) > 0 {
+		return cfg.WebAuthn.Origins
+	}
+
+	return cfg.CORSOrigins
+}
+
+// DSN returns the go-sql-driver/mysql data source name.
+func (db Database) DSN() string {
+	return fmt.Sprintf(
+		"%s:%s@tcp(%s:%d)/%s?parseTime=true&clientFoundRows=true",
+		db.User, db.Password, db.Host, db.Port, db.Name,
+	)
+}
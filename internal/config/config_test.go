@@ -0,0 +1,113 @@
+package config
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func validConfig() *Config {
+	cfg := Default()
+
+	cfg.Database.User = "nibbin"
+	cfg.Database.Host = "localhost"
+	cfg.Database.Name = "nibbin"
+	cfg.SMTP.User = "no-reply@nibbin.cl"
+	cfg.SMTP.Host = "smtp.nibbin.cl"
+	cfg.AdminEmail = "admin@nibbin.cl"
+	cfg.Session.Key = "key"
+	cfg.CSRFSecret = "secret"
+
+	return cfg
+}
+
+func TestValidate(t *testing.T) {
+	if err := validConfig().Validate(); err != nil {
+		t.Error(err)
+		return
+	}
+
+	cfg := validConfig()
+	cfg.Env = EnvProduction
+	cfg.Session.EncryptionKey = "short"
+
+	err := cfg.Validate()
+
+	var verr ValidationError
+
+	if !errors.As(err, &verr) {
+		t.Errorf("Got error %v when should be a ValidationError\n", err)
+		return
+	}
+
+	// Bad encryption key, insecure cookies and two http origins.
+	if len(verr) != 4 {
+		t.Errorf("Got %d problems when should be 4: %v\n", len(verr), verr)
+	}
+}
+
+func TestValidateArgon2Policy(t *testing.T) {
+	for _, tt := range []struct {
+		name string
+		cost Argon2
+	}{
+		{"calibrated time", Argon2{Memory: 64 * 1024, Time: 64, Threads: 2}},
+		{"threads", Argon2{Memory: 64 * 1024, Time: 3, Threads: 32}},
+		{"no time", Argon2{Memory: 64 * 1024, Time: 0, Threads: 2}},
+	} {
+		cfg := validConfig()
+		cfg.Argon2.Memory = tt.cost.Memory
+		cfg.Argon2.Time = tt.cost.Time
+		cfg.Argon2.Threads = tt.cost.Threads
+
+		if err := cfg.Validate(); err == nil {
+			t.Errorf("%s: got no error for %+v when should be rejected by the policy\n", tt.name, cfg.Argon2)
+		}
+	}
+}
+
+func TestLoad(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "config.json")
+
+	err := os.WriteFile(file, []byte(`{"addr": ":9000", "database": {"name": "fromfile", "port": 3307}, "argon2": {"memory": 65536}}`), 0600)
+
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	t.Setenv("DB_NAME", "fromenv")
+	t.Setenv("CORS_ORIGINS", "https://nibbin.cl, https://www.nibbin.cl")
+	t.Setenv("ARGON2_TIME", "1")
+
+	cfg, err := Load([]string{"-config", file, "-port", ":9090", "migrate", "up"})
+
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if cfg.Addr != ":9090" {
+		t.Errorf("Got addr %s when should be :9090\n", cfg.Addr)
+	}
+
+	if cfg.Database.Name != "fromenv" || cfg.Database.Port != 3307 {
+		t.Errorf("Got database %+v when should have name fromenv and port 3307\n", cfg.Database)
+	}
+
+	if want := []string{"https://nibbin.cl", "https://www.nibbin.cl"}; !cmp.Equal(cfg.CORSOrigins, want) {
+		t.Errorf("Got origins %v when should be %v\n", cfg.CORSOrigins, want)
+	}
+
+	if want := (Argon2{Memory: 65536, Time: 1, Threads: 2, MaxMemory: 256 * 1024, Concurrency: 4}); cfg.Argon2 != want {
+		t.Errorf("Got argon2 %+v when should be %+v\n", cfg.Argon2, want)
+	}
+
+	if want := []string{"migrate", "up"}; !cmp.Equal(cfg.Args, want) {
+		t.Errorf("Got args %v when should be %v\n", cfg.Args, want)
+	}
+}
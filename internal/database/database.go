@@ -3,44 +3,32 @@ package database
 import (
 	"database/sql"
 	"fmt"
-	"log"
-	"os"
 
+	"github.com/dvher/nibbin.cl_back/internal/config"
 	_ "github.com/go-sql-driver/mysql"
-	_ "github.com/joho/godotenv/autoload"
 )
 
-var DB *sql.DB
-
-func init() {
-
-	if DB == nil {
-		connect()
-		log.Println("Connected to database")
-	}
-
+func Connect(cfg config.Database) (*sql.DB, error) {
+	return open(cfg.DSN())
 }
 
-func connect() {
-	DB_NAME := os.Getenv("DB_NAME")
-	DB_USER := os.Getenv("DB_USER")
-	DB_PASS := os.Getenv("DB_PASS")
-	DB_HOST := os.Getenv("DB_ADDR")
-	DB_PORT := os.Getenv("DB_PORT")
-
-	var err error
+// ConnectForMigrations opens a connection that accepts several statements
+// per query, as migration files need.
+func ConnectForMigrations(cfg config.Database) (*sql.DB, error) {
+	return open(cfg.DSN() + "&multiStatements=true")
+}
 
-	DB, err = sql.Open("mysql", fmt.Sprintf("%s:%s@tcp(%s:%s)/%s", DB_USER, DB_PASS, DB_HOST, DB_PORT, DB_NAME))
+func open(dsn string) (*sql.DB, error) {
+	db, err := sql.Open("mysql", dsn)
 
 	if err != nil {
-		log.Fatal("Couldn't connect to database", err)
+		return nil, fmt.Errorf("couldn't connect to database: %w", err)
 	}
 
-	err = DB.Ping()
-
-	if err != nil {
-		DB.Close()
-		log.Fatal("Couldn't ping database", err)
+	if err := db.Ping(); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("couldn't ping database: %w", err)
 	}
 
+	return db, nil
 }
@@ -0,0 +1,354 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"errors"
+	"fmt"
+	"io/fs"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//go:embed migrations/*.sql
+var migrationFiles embed.FS
+
+var (
+	ErrSchemaBehind = errors.New("database schema is behind")
+	ErrSchemaDirty  = errors.New("database schema is dirty")
+)
+
+/*
+* MySQL can't roll back DDL, so a migration that fails halfway leaves part
+* of it applied. Every migration is recorded in MigracionSucia while it
+* runs; if it is still there, the schema has to be fixed by hand before
+* migrating again, see ClearDirty.
+ */
+
+type Migration struct {
+	Version int
+	Name    string
+	Up      string
+	Down    string
+}
+
+// Migrations returns the embedded migrations sorted by version. Files are
+// named <version>_<name>.up.sql and <version>_<name>.down.sql.
+func Migrations() ([]Migration, error) {
+	return readMigrations(migrationFiles)
+}
+
+func readMigrations(fsys fs.FS) ([]Migration, error) {
+	entries, err := fs.Glob(fsys, "migrations/*.sql")
+
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := make(map[int]*Migration)
+
+	for _, path := range entries {
+		file := strings.TrimPrefix(path, "migrations/")
+
+		var direction string
+
+		switch {
+		case strings.HasSuffix(file, ".up.sql"):
+			direction = "up"
+		case strings.HasSuffix(file, ".down.sql"):
+			direction = "down"
+		default:
+			return nil, fmt.Errorf("invalid migration file name %q", file)
+		}
+
+		base := strings.TrimSuffix(file, "."+direction+".sql")
+		prefix, name, ok := strings.Cut(base, "_")
+
+		if !ok {
+			return nil, fmt.Errorf("invalid migration file name %q", file)
+		}
+
+		version, err := strconv.Atoi(prefix)
+
+		if err != nil {
+			return nil, fmt.Errorf("invalid migration version in %q: %w", file, err)
+		}
+
+		body, err := fs.ReadFile(fsys, path)
+
+		if err != nil {
+			return nil, err
+		}
+
+		m, ok := byVersion[version]
+
+		if !ok {
+			m = &Migration{Version: version, Name: name}
+			byVersion[version] = m
+		}
+
+		if direction == "up" {
+			m.Up = string(body)
+		} else {
+			m.Down = string(body)
+		}
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+
+	for _, m := range byVersion {
+		if m.Up == "" || m.Down == "" {
+			return nil, fmt.Errorf("migration %04d_%s is missing its up or down file", m.Version, m.Name)
+		}
+
+		migrations = append(migrations, *m)
+	}
+
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].Version < migrations[j].Version
+	})
+
+	return migrations, nil
+}
+
+// LatestVersion is the schema version the code expects.
+func LatestVersion() (int, error) {
+	migrations, err := Migrations()
+
+	if err != nil {
+		return 0, err
+	}
+
+	if len(migrations) == 0 {
+		return 0, nil
+	}
+
+	return migrations[len(migrations)-1].Version, nil
+}
+
+// SchemaVersion returns the last applied migration, or 0 on a fresh database.
+func SchemaVersion(ctx context.Context, db *sql.DB) (int, error) {
+	if err := ensureMigrationTable(ctx, db); err != nil {
+		return 0, err
+	}
+
+	var version sql.NullInt64
+
+	if err := db.QueryRowContext(ctx, "SELECT MAX(version) FROM Migracion;").Scan(&version); err != nil {
+		return 0, err
+	}
+
+	return int(version.Int64), nil
+}
+
+// CheckSchema fails with ErrSchemaBehind when there are pending migrations
+// and with ErrSchemaDirty when one was interrupted.
+func CheckSchema(ctx context.Context, db *sql.DB) error {
+	current, err := SchemaVersion(ctx, db)
+
+	if err != nil {
+		return err
+	}
+
+	if err := CheckClean(ctx, db); err != nil {
+		return err
+	}
+
+	latest, err := LatestVersion()
+
+	if err != nil {
+		return err
+	}
+
+	if current < latest {
+		return fmt.Errorf("%w: at version %d, expected %d", ErrSchemaBehind, current, latest)
+	}
+
+	return nil
+}
+
+// MigrateUp applies every pending migration and returns how many ran.
+// The connection must allow multiple statements per query.
+func MigrateUp(ctx context.Context, db *sql.DB) (int, error) {
+	migrations, err := Migrations()
+
+	if err != nil {
+		return 0, err
+	}
+
+	return migrateUp(ctx, db, migrations)
+}
+
+func migrateUp(ctx context.Context, db *sql.DB, migrations []Migration) (int, error) {
+	current, err := SchemaVersion(ctx, db)
+
+	if err != nil {
+		return 0, err
+	}
+
+	if err := CheckClean(ctx, db); err != nil {
+		return 0, err
+	}
+
+	applied := 0
+
+	for _, m := range migrations {
+		if m.Version <= current {
+			continue
+		}
+
+		if err := markDirty(ctx, db, m); err != nil {
+			return applied, err
+		}
+
+		if _, err := db.ExecContext(ctx, m.Up); err != nil {
+			return applied, fmt.Errorf("applying migration %04d_%s: %w", m.Version, m.Name, err)
+		}
+
+		_, err := db.ExecContext(
+			ctx,
+			"INSERT INTO Migracion (version, nombre, aplicada) VALUES (?, ?, ?);",
+			m.Version, m.Name, time.Now().UTC(),
+		)
+
+		if err != nil {
+			return applied, err
+		}
+
+		if err := clearDirty(ctx, db, m.Version); err != nil {
+			return applied, err
+		}
+
+		applied++
+	}
+
+	return applied, nil
+}
+
+// MigrateDown reverts up to steps applied migrations, newest first.
+func MigrateDown(ctx context.Context, db *sql.DB, steps int) (int, error) {
+	migrations, err := Migrations()
+
+	if err != nil {
+		return 0, err
+	}
+
+	return migrateDown(ctx, db, migrations, steps)
+}
+
+func migrateDown(ctx context.Context, db *sql.DB, migrations []Migration, steps int) (int, error) {
+	current, err := SchemaVersion(ctx, db)
+
+	if err != nil {
+		return 0, err
+	}
+
+	if err := CheckClean(ctx, db); err != nil {
+		return 0, err
+	}
+
+	reverted := 0
+
+	for i := len(migrations) - 1; i >= 0 && reverted < steps; i-- {
+		m := migrations[i]
+
+		if m.Version > current {
+			continue
+		}
+
+		if err := markDirty(ctx, db, m); err != nil {
+			return reverted, err
+		}
+
+		if _, err := db.ExecContext(ctx, m.Down); err != nil {
+			return reverted, fmt.Errorf("reverting migration %04d_%s: %w", m.Version, m.Name, err)
+		}
+
+		if _, err := db.ExecContext(ctx, "DELETE FROM Migracion WHERE version = ?;", m.Version); err != nil {
+			return reverted, err
+		}
+
+		if err := clearDirty(ctx, db, m.Version); err != nil {
+			return reverted, err
+		}
+
+		reverted++
+	}
+
+	return reverted, nil
+}
+
+// CheckClean fails with ErrSchemaDirty when a migration was interrupted.
+func CheckClean(ctx context.Context, db *sql.DB) error {
+	var version int
+	var name string
+
+	err := db.QueryRowContext(ctx, "SELECT version, nombre FROM MigracionSucia ORDER BY version LIMIT 1;").Scan(&version, &name)
+
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil
+	}
+
+	if err != nil {
+		return err
+	}
+
+	return fmt.Errorf("%w: migration %04d_%s was interrupted", ErrSchemaDirty, version, name)
+}
+
+// ClearDirty forgets interrupted migrations. Run it once the schema has
+// been fixed by hand to match the version recorded in Migracion.
+func ClearDirty(ctx context.Context, db *sql.DB) error {
+	if err := ensureMigrationTable(ctx, db); err != nil {
+		return err
+	}
+
+	_, err := db.ExecContext(ctx, "DELETE FROM MigracionSucia;")
+
+	return err
+}
+
+func markDirty(ctx context.Context, db *sql.DB, m Migration) error {
+	_, err := db.ExecContext(
+		ctx,
+		"INSERT INTO MigracionSucia (version, nombre, iniciada) VALUES (?, ?, ?);",
+		m.Version, m.Name, time.Now().UTC(),
+	)
+
+	return err
+}
+
+func clearDirty(ctx context.Context, db *sql.DB, version int) error {
+	_, err := db.ExecContext(ctx, "DELETE FROM MigracionSucia WHERE version = ?;", version)
+
+	return err
+}
+
+func ensureMigrationTable(ctx context.Context, db *sql.DB) error {
+	_, err := db.ExecContext(
+		ctx,
+		"CREATE TABLE IF NOT EXISTS Migracion ("+
+			"version INT NOT NULL PRIMARY KEY, "+
+			"nombre VARCHAR(255) NOT NULL, "+
+			"aplicada DATETIME NOT NULL"+
+			");",
+	)
+
+	if err != nil {
+		return err
+	}
+
+	_, err = db.ExecContext(
+		ctx,
+		"CREATE TABLE IF NOT EXISTS MigracionSucia ("+
+			"version INT NOT NULL PRIMARY KEY, "+
+			"nombre VARCHAR(255) NOT NULL, "+
+			"iniciada DATETIME NOT NULL"+
+			");",
+	)
+
+	return err
+}
@@ -0,0 +1,303 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+	"testing/fstest"
+)
+
+func TestMigrations(t *testing.T) {
+	migrations, err := Migrations()
+
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if len(migrations) == 0 {
+		t.Errorf("No migrations were embedded\n")
+		return
+	}
+
+	for i, m := range migrations {
+		if m.Version != i+1 {
+			t.Errorf("Migration %s has version %d when should be %d\n", m.Name, m.Version, i+1)
+		}
+	}
+
+	latest, err := LatestVersion()
+
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if latest != len(migrations) {
+		t.Errorf("Latest version is %d when should be %d\n", latest, len(migrations))
+	}
+}
+
+func TestMigrationPairs(t *testing.T) {
+	file := func(body string) *fstest.MapFile {
+		return &fstest.MapFile{Data: []byte(body)}
+	}
+
+	migrations, err := readMigrations(fstest.MapFS{
+		"migrations/0002_otp.down.sql":  file("DROP TABLE OTP;"),
+		"migrations/0001_init.up.sql":   file("CREATE TABLE Usuario (id INT);"),
+		"migrations/0002_otp.up.sql":    file("CREATE TABLE OTP (email VARCHAR(255));"),
+		"migrations/0001_init.down.sql": file("DROP TABLE Usuario;"),
+	})
+
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	want := []Migration{
+		{Version: 1, Name: "init", Up: "CREATE TABLE Usuario (id INT);", Down: "DROP TABLE Usuario;"},
+		{Version: 2, Name: "otp", Up: "CREATE TABLE OTP (email VARCHAR(255));", Down: "DROP TABLE OTP;"},
+	}
+
+	if len(migrations) != len(want) || migrations[0] != want[0] || migrations[1] != want[1] {
+		t.Errorf("Got migrations %v when should be %v\n", migrations, want)
+	}
+
+	invalid := map[string]fstest.MapFS{
+		"missing down": {"migrations/0001_init.up.sql": file("CREATE TABLE Usuario (id INT);")},
+		"missing up":   {"migrations/0001_init.down.sql": file("DROP TABLE Usuario;")},
+		"no direction": {"migrations/0001_init.sql": file("CREATE TABLE Usuario (id INT);")},
+		"no version":   {"migrations/init.up.sql": file(""), "migrations/init.down.sql": file("")},
+	}
+
+	for name, fsys := range invalid {
+		if _, err := readMigrations(fsys); err == nil {
+			t.Errorf("%s: got no error when should fail\n", name)
+		}
+	}
+}
+
+// expectation is one statement the migrations are expected to run, in
+// order. A non nil err fails it.
+type expectation struct {
+	query   string
+	args    []driver.Value
+	columns []string
+	rows    [][]driver.Value
+	err     error
+}
+
+type script struct {
+	mu    sync.Mutex
+	steps []expectation
+}
+
+var (
+	scriptsMu sync.Mutex
+	scripts   = map[string]*script{}
+)
+
+func init() {
+	sql.Register("database_test", scriptDriver{})
+}
+
+type scriptDriver struct{}
+
+func (scriptDriver) Open(name string) (driver.Conn, error) {
+	scriptsMu.Lock()
+	defer scriptsMu.Unlock()
+
+	return scriptConn{scripts[name]}, nil
+}
+
+type scriptConn struct {
+	s *script
+}
+
+func (c scriptConn) Prepare(string) (driver.Stmt, error) { return nil, errors.New("not supported") }
+func (c scriptConn) Close() error                        { return nil }
+func (c scriptConn) Begin() (driver.Tx, error)           { return nil, errors.New("not supported") }
+
+func (c scriptConn) QueryContext(_ context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	step, err := c.s.next(query, args)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &scriptRows{columns: step.columns, rows: step.rows}, nil
+}
+
+func (c scriptConn) ExecContext(_ context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	if _, err := c.s.next(query, args); err != nil {
+		return nil, err
+	}
+
+	return driver.RowsAffected(1), nil
+}
+
+func (s *script) next(query string, args []driver.NamedValue) (expectation, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if len(s.steps) == 0 {
+		return expectation{}, fmt.Errorf("unexpected query %q", query)
+	}
+
+	step := s.steps[0]
+	s.steps = s.steps[1:]
+
+	if !strings.HasPrefix(query, step.query) {
+		return expectation{}, fmt.Errorf("got query %q when should start with %q", query, step.query)
+	}
+
+	// Only the leading arguments are checked, the rest are timestamps.
+	for i, want := range step.args {
+		if i >= len(args) || args[i].Value != want {
+			return expectation{}, fmt.Errorf("got args %v for %q when should start with %v", args, query, step.args)
+		}
+	}
+
+	return step, step.err
+}
+
+type scriptRows struct {
+	columns []string
+	rows    [][]driver.Value
+}
+
+func (r *scriptRows) Columns() []string { return r.columns }
+func (r *scriptRows) Close() error      { return nil }
+
+func (r *scriptRows) Next(dest []driver.Value) error {
+	if len(r.rows) == 0 {
+		return io.EOF
+	}
+
+	copy(dest, r.rows[0])
+	r.rows = r.rows[1:]
+
+	return nil
+}
+
+func newScriptedDB(t *testing.T, steps ...expectation) *sql.DB {
+	s := &script{steps: steps}
+
+	scriptsMu.Lock()
+	scripts[t.Name()] = s
+	scriptsMu.Unlock()
+
+	db, err := sql.Open("database_test", t.Name())
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() {
+		db.Close()
+
+		if len(s.steps) != 0 {
+			t.Errorf("Got %d statements that were never run, next %q\n", len(s.steps), s.steps[0].query)
+		}
+	})
+
+	return db
+}
+
+// schemaAt is what every run starts with: the tables are created if
+// needed, then the version and the interrupted migrations are read.
+func schemaAt(version int64, dirty ...driver.Value) []expectation {
+	steps := []expectation{
+		{query: "CREATE TABLE IF NOT EXISTS Migracion ("},
+		{query: "CREATE TABLE IF NOT EXISTS MigracionSucia ("},
+		{query: "SELECT MAX(version) FROM Migracion;", columns: []string{"version"}, rows: [][]driver.Value{{version}}},
+		{query: "SELECT version, nombre FROM MigracionSucia", columns: []string{"version", "nombre"}},
+	}
+
+	if len(dirty) > 0 {
+		steps[3].rows = [][]driver.Value{dirty}
+	}
+
+	return steps
+}
+
+var testMigrations = []Migration{
+	{Version: 1, Name: "init", Up: "CREATE TABLE Usuario (id INT);", Down: "DROP TABLE Usuario;"},
+	{Version: 2, Name: "otp", Up: "CREATE TABLE OTP (email VARCHAR(255)); CREATE INDEX", Down: "DROP TABLE OTP;"},
+}
+
+func TestMigrateUp(t *testing.T) {
+	db := newScriptedDB(t, append(schemaAt(1),
+		expectation{query: "INSERT INTO MigracionSucia", args: []driver.Value{int64(2), "otp"}},
+		expectation{query: "CREATE TABLE OTP"},
+		expectation{query: "INSERT INTO Migracion (", args: []driver.Value{int64(2), "otp"}},
+		expectation{query: "DELETE FROM MigracionSucia WHERE version = ?;", args: []driver.Value{int64(2)}},
+	)...)
+
+	n, err := migrateUp(context.Background(), db, testMigrations)
+
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if n != 1 {
+		t.Errorf("Got %d migrations applied when should be 1\n", n)
+	}
+}
+
+func TestMigrateUpInterrupted(t *testing.T) {
+	// The migration stays marked when it fails halfway.
+	db := newScriptedDB(t, append(schemaAt(1),
+		expectation{query: "INSERT INTO MigracionSucia", args: []driver.Value{int64(2), "otp"}},
+		expectation{query: "CREATE TABLE OTP", err: errors.New("duplicate key name")},
+	)...)
+
+	if _, err := migrateUp(context.Background(), db, testMigrations); err == nil {
+		t.Errorf("Got no error when the migration failed\n")
+	}
+}
+
+func TestMigrateDirty(t *testing.T) {
+	dirty := []driver.Value{int64(2), "otp"}
+
+	var steps []expectation
+
+	// Migrating up, down and checking the schema all stop at the mark.
+	for i := 0; i < 3; i++ {
+		steps = append(steps, schemaAt(1, dirty...)...)
+	}
+
+	steps = append(steps,
+		expectation{query: "CREATE TABLE IF NOT EXISTS Migracion ("},
+		expectation{query: "CREATE TABLE IF NOT EXISTS MigracionSucia ("},
+		expectation{query: "DELETE FROM MigracionSucia;"},
+	)
+
+	db := newScriptedDB(t, steps...)
+
+	ctx := context.Background()
+
+	if _, err := migrateUp(ctx, db, testMigrations); !errors.Is(err, ErrSchemaDirty) {
+		t.Errorf("Got error %v migrating up when should be %v\n", err, ErrSchemaDirty)
+	}
+
+	if _, err := migrateDown(ctx, db, testMigrations, 1); !errors.Is(err, ErrSchemaDirty) {
+		t.Errorf("Got error %v migrating down when should be %v\n", err, ErrSchemaDirty)
+	}
+
+	if err := CheckSchema(ctx, db); !errors.Is(err, ErrSchemaDirty) {
+		t.Errorf("Got error %v checking the schema when should be %v\n", err, ErrSchemaDirty)
+	}
+
+	if err := ClearDirty(ctx, db); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,6 @@
+DROP PROCEDURE IF EXISTS SearchProductos;
+DROP PROCEDURE IF EXISTS DescProductos;
+DROP TABLE IF EXISTS Administrador;
+DROP TABLE IF EXISTS Favorito;
+DROP TABLE IF EXISTS Producto;
+DROP TABLE IF EXISTS Usuario;
@@ -0,0 +1,61 @@
+CREATE TABLE IF NOT EXISTS Usuario (
+    id         INT          NOT NULL AUTO_INCREMENT PRIMARY KEY,
+    nombre     VARCHAR(100) NOT NULL,
+    apellido   VARCHAR(100) NOT NULL,
+    email      VARCHAR(255) NOT NULL UNIQUE,
+    usuario    VARCHAR(100) NOT NULL UNIQUE,
+    puntos     INT          NOT NULL DEFAULT 0,
+    direccion  VARCHAR(255) NOT NULL,
+    telefono   VARCHAR(20)  NOT NULL,
+    nacimiento DATE         NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS Producto (
+    id          INT          NOT NULL AUTO_INCREMENT PRIMARY KEY,
+    nombre      VARCHAR(255) NOT NULL,
+    marca       VARCHAR(100) NOT NULL DEFAULT '',
+    descripcion TEXT         NOT NULL,
+    precio      INT          NOT NULL DEFAULT 0,
+    descuento   FLOAT        NOT NULL DEFAULT 0,
+    stock       INT          NOT NULL DEFAULT 0,
+    imagen      VARCHAR(512) NOT NULL DEFAULT ''
+);
+
+CREATE TABLE IF NOT EXISTS Favorito (
+    id         INT NOT NULL AUTO_INCREMENT PRIMARY KEY,
+    idUsuario  INT NOT NULL,
+    idProducto INT NOT NULL,
+    UNIQUE (idUsuario, idProducto),
+    FOREIGN KEY (idUsuario) REFERENCES Usuario (id),
+    FOREIGN KEY (idProducto) REFERENCES Producto (id)
+);
+
+CREATE TABLE IF NOT EXISTS Administrador (
+    id         INT          NOT NULL AUTO_INCREMENT PRIMARY KEY,
+    idUsuario  INT          NOT NULL UNIQUE,
+    contrasena VARCHAR(255) NOT NULL,
+    FOREIGN KEY (idUsuario) REFERENCES Usuario (id)
+);
+
+DROP PROCEDURE IF EXISTS DescProductos;
+
+CREATE PROCEDURE DescProductos(IN pIdUsuario INT)
+BEGIN
+    SELECT p.id, p.nombre, p.marca, p.descripcion, p.precio, p.descuento, p.stock, p.imagen,
+        EXISTS(SELECT 1 FROM Favorito f WHERE f.idProducto = p.id AND f.idUsuario = pIdUsuario) AS isFavorite
+    FROM Producto p
+    ORDER BY p.id;
+END;
+
+DROP PROCEDURE IF EXISTS SearchProductos;
+
+CREATE PROCEDURE SearchProductos(IN pIdUsuario INT, IN pBusqueda VARCHAR(255))
+BEGIN
+    SELECT p.id, p.nombre, p.marca, p.descripcion, p.precio, p.descuento, p.stock, p.imagen,
+        EXISTS(SELECT 1 FROM Favorito f WHERE f.idProducto = p.id AND f.idUsuario = pIdUsuario) AS isFavorite
+    FROM Producto p
+    WHERE p.nombre LIKE CONCAT('%', pBusqueda, '%')
+        OR p.marca LIKE CONCAT('%', pBusqueda, '%')
+        OR p.descripcion LIKE CONCAT('%', pBusqueda, '%')
+    ORDER BY p.id;
+END;
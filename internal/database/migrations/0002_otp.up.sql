@@ -0,0 +1,9 @@
+CREATE TABLE OTP (
+    email    VARCHAR(255) NOT NULL PRIMARY KEY,
+    codigo   VARCHAR(16)  NOT NULL,
+    intentos INT          NOT NULL DEFAULT 0,
+    accion   VARCHAR(32)  NOT NULL,
+    datos    TEXT         NOT NULL,
+    expira   DATETIME     NOT NULL,
+    INDEX (expira)
+);
@@ -0,0 +1,13 @@
+CREATE TABLE Sesion (
+    id          INT          NOT NULL AUTO_INCREMENT PRIMARY KEY,
+    token       CHAR(64)     NOT NULL UNIQUE,
+    usuario     VARCHAR(100) NULL,
+    datos       BLOB         NOT NULL,
+    dispositivo VARCHAR(255) NOT NULL DEFAULT '',
+    ip          VARCHAR(45)  NOT NULL DEFAULT '',
+    creado      DATETIME     NOT NULL,
+    visto       DATETIME     NOT NULL,
+    expira      DATETIME     NOT NULL,
+    INDEX (usuario),
+    INDEX (expira)
+);
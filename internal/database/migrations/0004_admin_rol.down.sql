@@ -0,0 +1 @@
+ALTER TABLE Administrador DROP COLUMN rol;
@@ -0,0 +1,4 @@
+ALTER TABLE Administrador ADD COLUMN rol VARCHAR(32) NOT NULL DEFAULT 'support';
+
+-- Admins registered before roles existed had full access.
+UPDATE Administrador SET rol = 'superadmin';
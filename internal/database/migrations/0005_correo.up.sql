@@ -0,0 +1,13 @@
+CREATE TABLE Correo (
+    id            INT          NOT NULL AUTO_INCREMENT PRIMARY KEY,
+    destinatarios TEXT         NOT NULL,
+    asunto        VARCHAR(255) NOT NULL,
+    cuerpo        MEDIUMTEXT   NOT NULL,
+    estado        VARCHAR(16)  NOT NULL DEFAULT 'pendiente',
+    intentos      INT          NOT NULL DEFAULT 0,
+    proximo       DATETIME     NOT NULL,
+    error         TEXT         NULL,
+    creado        DATETIME     NOT NULL,
+    enviado       DATETIME     NULL,
+    INDEX (estado, proximo)
+);
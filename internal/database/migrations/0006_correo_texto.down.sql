@@ -0,0 +1 @@
+ALTER TABLE Correo DROP COLUMN texto;
@@ -0,0 +1 @@
+ALTER TABLE Correo ADD COLUMN texto MEDIUMTEXT NULL AFTER cuerpo;
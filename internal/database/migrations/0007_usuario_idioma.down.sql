@@ -0,0 +1 @@
+ALTER TABLE Usuario DROP COLUMN idioma;
@@ -0,0 +1 @@
+ALTER TABLE Usuario ADD COLUMN idioma VARCHAR(16) NULL;
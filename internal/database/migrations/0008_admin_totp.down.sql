@@ -0,0 +1,6 @@
+DROP TABLE IF EXISTS CodigoRecuperacion;
+
+ALTER TABLE Administrador
+    DROP COLUMN totp,
+    DROP COLUMN totpPendiente,
+    DROP COLUMN totpPaso;
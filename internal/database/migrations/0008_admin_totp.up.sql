@@ -0,0 +1,12 @@
+ALTER TABLE Administrador
+    ADD COLUMN totp          VARCHAR(64) NULL,
+    ADD COLUMN totpPendiente VARCHAR(64) NULL,
+    ADD COLUMN totpPaso      BIGINT      NOT NULL DEFAULT 0;
+
+CREATE TABLE CodigoRecuperacion (
+    id              INT          NOT NULL AUTO_INCREMENT PRIMARY KEY,
+    idAdministrador INT          NOT NULL,
+    hash            VARCHAR(255) NOT NULL,
+    usado           DATETIME     NULL,
+    FOREIGN KEY (idAdministrador) REFERENCES Administrador (id) ON DELETE CASCADE
+);
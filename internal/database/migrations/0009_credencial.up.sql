@@ -0,0 +1,14 @@
+CREATE TABLE Credencial (
+    id             INT            NOT NULL AUTO_INCREMENT PRIMARY KEY,
+    idUsuario      INT            NOT NULL,
+    credencial     VARBINARY(255) NOT NULL UNIQUE,
+    clavePublica   BLOB           NOT NULL,
+    tipoAtestacion VARCHAR(32)    NOT NULL,
+    transportes    VARCHAR(255)   NOT NULL DEFAULT '',
+    aaguid         VARBINARY(16)  NOT NULL,
+    contador       BIGINT         NOT NULL DEFAULT 0,
+    nombre         VARCHAR(100)   NOT NULL DEFAULT '',
+    creado         DATETIME       NOT NULL,
+    usado          DATETIME       NULL,
+    FOREIGN KEY (idUsuario) REFERENCES Usuario (id) ON DELETE CASCADE
+);
@@ -0,0 +1,12 @@
+CREATE TABLE TokenAPI (
+    id              INT          NOT NULL AUTO_INCREMENT PRIMARY KEY,
+    idAdministrador INT          NOT NULL,
+    nombre          VARCHAR(100) NOT NULL,
+    hash            CHAR(64)     NOT NULL UNIQUE,
+    permisos        VARCHAR(255) NOT NULL,
+    expira          DATETIME     NOT NULL,
+    creado          DATETIME     NOT NULL,
+    usado           DATETIME     NULL,
+    revocado        DATETIME     NULL,
+    FOREIGN KEY (idAdministrador) REFERENCES Administrador (id) ON DELETE CASCADE
+);
@@ -0,0 +1 @@
+DROP PROCEDURE IF EXISTS DescProducto;
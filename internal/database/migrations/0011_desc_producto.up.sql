@@ -0,0 +1,9 @@
+DROP PROCEDURE IF EXISTS DescProducto;
+
+CREATE PROCEDURE DescProducto(IN pIdUsuario INT, IN pIdProducto INT)
+BEGIN
+    SELECT p.id, p.nombre, p.marca, p.descripcion, p.precio, p.descuento, p.stock, p.imagen,
+        EXISTS(SELECT 1 FROM Favorito f WHERE f.idProducto = p.id AND f.idUsuario = pIdUsuario) AS isFavorite
+    FROM Producto p
+    WHERE p.id = pIdProducto;
+END;
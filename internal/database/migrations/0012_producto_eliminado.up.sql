@@ -0,0 +1,35 @@
+ALTER TABLE Producto ADD COLUMN eliminado DATETIME NULL;
+
+DROP PROCEDURE IF EXISTS DescProductos;
+
+CREATE PROCEDURE DescProductos(IN pIdUsuario INT)
+BEGIN
+    SELECT p.id, p.nombre, p.marca, p.descripcion, p.precio, p.descuento, p.stock, p.imagen,
+        EXISTS(SELECT 1 FROM Favorito f WHERE f.idProducto = p.id AND f.idUsuario = pIdUsuario) AS isFavorite
+    FROM Producto p
+    WHERE p.eliminado IS NULL
+    ORDER BY p.id;
+END;
+
+DROP PROCEDURE IF EXISTS SearchProductos;
+
+CREATE PROCEDURE SearchProductos(IN pIdUsuario INT, IN pBusqueda VARCHAR(255))
+BEGIN
+    SELECT p.id, p.nombre, p.marca, p.descripcion, p.precio, p.descuento, p.stock, p.imagen,
+        EXISTS(SELECT 1 FROM Favorito f WHERE f.idProducto = p.id AND f.idUsuario = pIdUsuario) AS isFavorite
+    FROM Producto p
+    WHERE p.eliminado IS NULL AND (p.nombre LIKE CONCAT('%', pBusqueda, '%')
+        OR p.marca LIKE CONCAT('%', pBusqueda, '%')
+        OR p.descripcion LIKE CONCAT('%', pBusqueda, '%'))
+    ORDER BY p.id;
+END;
+
+DROP PROCEDURE IF EXISTS DescProducto;
+
+CREATE PROCEDURE DescProducto(IN pIdUsuario INT, IN pIdProducto INT)
+BEGIN
+    SELECT p.id, p.nombre, p.marca, p.descripcion, p.precio, p.descuento, p.stock, p.imagen,
+        EXISTS(SELECT 1 FROM Favorito f WHERE f.idProducto = p.id AND f.idUsuario = pIdUsuario) AS isFavorite
+    FROM Producto p
+    WHERE p.id = pIdProducto AND p.eliminado IS NULL;
+END;
@@ -0,0 +1,220 @@
+// Package mailer sends the emails the server produces through one of
+// several backends, chosen by configuration.
+package mailer
+
+import (
+	"crypto/tls"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/dvher/nibbin.cl_back/internal/config"
+	gomail "gopkg.in/mail.v2"
+)
+
+const (
+	BackendSMTP   = "smtp"
+	BackendFile   = "file"
+	BackendMemory = "memory"
+)
+
+// Timeout bounds dialing the SMTP server and sending one message on the
+// connection.
+const Timeout = 10 * time.Second
+
+// Message is an HTML email, with an optional plain text alternative.
+type Message struct {
+	To      []string
+	Subject string
+	HTML    string
+	Text    string
+}
+
+type Mailer interface {
+	Send(msg Message) error
+	Close() error
+}
+
+// New returns the backend selected by cfg.Mailer.
+func New(cfg *config.Config) (Mailer, error) {
+	switch cfg.Mailer {
+	case BackendSMTP:
+		return NewSMTP(cfg.SMTP), nil
+	case BackendFile:
+		return NewDir(cfg.MailDir, from(cfg.SMTP))
+	case BackendMemory:
+		return NewRecorder(), nil
+	default:
+		return nil, fmt.Errorf("unknown mailer %q", cfg.Mailer)
+	}
+}
+
+func from(cfg config.SMTP) string {
+	if cfg.User == "" {
+		return "no-reply@localhost"
+	}
+
+	return cfg.User
+}
+
+func build(from string, msg Message) *gomail.Message {
+	m := gomail.NewMessage()
+
+	m.SetHeader("From", from)
+	m.SetHeader("To", msg.To...)
+	m.SetHeader("Subject", msg.Subject)
+
+	if msg.Text == "" {
+		m.SetBody("text/html", msg.HTML)
+		return m
+	}
+
+	// Clients show the last alternative they support, so HTML goes last.
+	m.SetBody("text/plain", msg.Text)
+	m.AddAlternative("text/html", msg.HTML)
+
+	return m
+}
+
+// SMTP keeps a single connection open and redials when the server has
+// dropped it.
+type SMTP struct {
+	dialer *gomail.Dialer
+	from   string
+	mu     sync.Mutex
+	conn   gomail.SendCloser
+}
+
+func NewSMTP(cfg config.SMTP) *SMTP {
+	d := gomail.NewDialer(cfg.Host, cfg.Port, cfg.User, cfg.Password)
+
+	d.TLSConfig = &tls.Config{ServerName: cfg.Host, MinVersion: tls.VersionTLS12}
+	d.StartTLSPolicy = gomail.MandatoryStartTLS
+	d.Timeout = Timeout
+
+	return &SMTP{dialer: d, from: from(cfg)}
+}
+
+func (s *SMTP) Send(msg Message) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	m := build(s.from, msg)
+
+	if s.conn != nil {
+		if err := gomail.Send(s.conn, m); err == nil {
+			return nil
+		}
+
+		// The connection is most likely stale, try once more on a new one.
+		s.conn.Close()
+		s.conn = nil
+	}
+
+	conn, err := s.dialer.Dial()
+
+	if err != nil {
+		return err
+	}
+
+	if err := gomail.Send(conn, m); err != nil {
+		conn.Close()
+		return err
+	}
+
+	s.conn = conn
+
+	return nil
+}
+
+func (s *SMTP) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.conn == nil {
+		return nil
+	}
+
+	err := s.conn.Close()
+	s.conn = nil
+
+	return err
+}
+
+// Dir writes every message as an .eml file, for development without an
+// SMTP server.
+type Dir struct {
+	path string
+	from string
+	mu   sync.Mutex
+	seq  int
+}
+
+func NewDir(path, from string) (*Dir, error) {
+	if err := os.MkdirAll(path, 0o755); err != nil {
+		return nil, err
+	}
+
+	return &Dir{path: path, from: from}, nil
+}
+
+func (d *Dir) Send(msg Message) error {
+	d.mu.Lock()
+	d.seq++
+	name := time.Now().Format("20060102-150405.000000") + "-" + strconv.Itoa(d.seq) + ".eml"
+	d.mu.Unlock()
+
+	f, err := os.Create(filepath.Join(d.path, name))
+
+	if err != nil {
+		return err
+	}
+
+	if _, err := build(d.from, msg).WriteTo(f); err != nil {
+		f.Close()
+		return err
+	}
+
+	log.Println("Email written to", f.Name())
+
+	return f.Close()
+}
+
+func (d *Dir) Close() error {
+	return nil
+}
+
+// Recorder keeps the messages in memory, for tests.
+type Recorder struct {
+	mu       sync.Mutex
+	messages []Message
+}
+
+func NewRecorder() *Recorder {
+	return &Recorder{}
+}
+
+func (r *Recorder) Send(msg Message) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.messages = append(r.messages, msg)
+
+	return nil
+}
+
+// Messages returns a copy of everything sent so far.
+func (r *Recorder) Messages() []Message {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return append([]Message(nil), r.messages...)
+}
+
+func (r *Recorder) Close() error {
+	return nil
+}
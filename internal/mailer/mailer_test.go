@@ -0,0 +1,75 @@
+package mailer
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestDir(t *testing.T) {
+	dir := t.TempDir()
+
+	d, err := NewDir(filepath.Join(dir, "mail"), "no-reply@nibbin.cl")
+
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	for i := 0; i < 2; i++ {
+		err = d.Send(Message{
+			To:      []string{"user@nibbin.cl"},
+			Subject: "Código de verificación",
+			HTML:    "<p>123456</p>",
+			Text:    "123456",
+		})
+
+		if err != nil {
+			t.Error(err)
+			return
+		}
+	}
+
+	files, err := filepath.Glob(filepath.Join(dir, "mail", "*.eml"))
+
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if len(files) != 2 {
+		t.Errorf("Got %d files when should be 2\n", len(files))
+		return
+	}
+
+	body, err := os.ReadFile(files[0])
+
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	for _, want := range []string{"To: user@nibbin.cl", "From: no-reply@nibbin.cl", "multipart/alternative", "text/plain", "<p>123456</p>"} {
+		if !strings.Contains(string(body), want) {
+			t.Errorf("Got email %s when should contain %s\n", body, want)
+		}
+	}
+}
+
+func TestRecorder(t *testing.T) {
+	r := NewRecorder()
+
+	msg := Message{To: []string{"user@nibbin.cl"}, Subject: "Hola", HTML: "<p>Hola</p>"}
+
+	if err := r.Send(msg); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if diff := cmp.Diff([]Message{msg}, r.Messages()); diff != "" {
+		t.Errorf("Got unexpected messages (-want +got):\n%s", diff)
+	}
+}
@@ -1,53 +1,194 @@
 package middleware
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"log"
 	"net/http"
+	"strings"
+	"time"
 
-	db "github.com/dvher/nibbin.cl_back/internal/database"
+	"github.com/dvher/nibbin.cl_back/internal/rbac"
+	"github.com/dvher/nibbin.cl_back/internal/repository"
 	"github.com/gin-contrib/sessions"
 	"github.com/gin-gonic/gin"
 )
 
-func Auth() gin.HandlerFunc {
-	return func(c *gin.Context) {
-		session := sessions.Default(c)
-		user := session.Get("user")
-		email := session.Get("email")
+const (
+	RoleAdmin = "admin"
 
-		stmt, err := db.DB.Prepare("SELECT id FROM Usuario WHERE usuario = ? AND email = ?;")
+	// AdminSessionTTL is how long a password plus OTP admin login is honored.
+	AdminSessionTTL = 30 * time.Minute
 
-		if err != nil {
-			c.AbortWithStatus(http.StatusInternalServerError)
+	// RoleKey holds the rbac.Role of the authenticated admin in the gin context.
+	RoleKey = "adminRole"
+
+	// UserKey holds the user name of the authenticated admin.
+	UserKey = "adminUser"
+
+	// TokenKey holds the repository.APIToken when the request was made
+	// with one instead of a session.
+	TokenKey = "apiToken"
+
+	// tokenTouchEvery limits how often the last use of a token is written.
+	tokenTouchEvery = time.Minute
+)
+
+// BearerToken returns the token of an Authorization: Bearer header.
+func BearerToken(c *gin.Context) (string, bool) {
+	scheme, token, ok := strings.Cut(c.GetHeader("Authorization"), " ")
+
+	if !ok || !strings.EqualFold(scheme, "Bearer") || token == "" {
+		return "", false
+	}
+
+	return strings.TrimSpace(token), true
+}
+
+// HashToken is how API tokens are stored. They are random enough that a
+// fast hash is fine.
+func HashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+
+	return hex.EncodeToString(sum[:])
+}
+
+// Auth accepts either an admin session or an API token. A request with
+// an Authorization header is judged by its token alone.
+func Auth(admins repository.AdminRepository, tokens repository.TokenRepository) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if raw, ok := BearerToken(c); ok {
+			authToken(c, admins, tokens, raw)
 			return
 		}
 
-		defer stmt.Close()
+		session := sessions.Default(c)
+		user, _ := session.Get("user").(string)
+		email, _ := session.Get("email").(string)
 
-		var id int
+		if session.Get("role") != RoleAdmin {
+			c.AbortWithStatus(http.StatusUnauthorized)
+			return
+		}
 
-		err = stmt.QueryRow(user, email).Scan(&id)
+		authAt, ok := session.Get("authAt").(int64)
 
-		if err != nil {
+		if !ok || time.Since(time.Unix(authAt, 0)) > AdminSessionTTL {
 			c.AbortWithStatus(http.StatusUnauthorized)
 			return
 		}
 
-		stmt, err = db.DB.Prepare("SELECT id FROM Admin WHERE idUsuario = ?;")
+		role, err := admins.Role(c.Request.Context(), user, email)
+
+		if errors.Is(err, repository.ErrNotFound) || (err == nil && !role.Valid()) {
+			c.AbortWithStatus(http.StatusUnauthorized)
+			return
+		}
 
 		if err != nil {
 			c.AbortWithStatus(http.StatusInternalServerError)
 			return
 		}
 
-		defer stmt.Close()
+		c.Set(RoleKey, role)
+		c.Set(UserKey, user)
 
-		err = stmt.QueryRow(id).Scan(&id)
+		c.Next()
+	}
+}
 
-		if err != nil {
-			c.AbortWithStatus(http.StatusUnauthorized)
+func authToken(c *gin.Context, admins repository.AdminRepository, tokens repository.TokenRepository, raw string) {
+	token, err := tokens.ByHash(c.Request.Context(), HashToken(raw))
+
+	if errors.Is(err, repository.ErrNotFound) {
+		c.AbortWithStatus(http.StatusUnauthorized)
+		return
+	}
+
+	if err != nil {
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+
+	now := time.Now()
+
+	if token.Revoked != nil || !now.Before(token.Expires) {
+		c.AbortWithStatus(http.StatusUnauthorized)
+		return
+	}
+
+	// The token can't do more than its owner currently can.
+	role, err := admins.Role(c.Request.Context(), token.User, token.Email)
+
+	if errors.Is(err, repository.ErrNotFound) || (err == nil && !role.Valid()) {
+		c.AbortWithStatus(http.StatusUnauthorized)
+		return
+	}
+
+	if err != nil {
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+
+	if token.LastUsed == nil || now.Sub(*token.LastUsed) > tokenTouchEvery {
+		if err := tokens.Touch(c.Request.Context(), token.ID, now.UTC()); err != nil {
+			log.Println("Error updating token", err)
+		}
+	}
+
+	c.Set(RoleKey, role)
+	c.Set(UserKey, token.User)
+	c.Set(TokenKey, token)
+
+	c.Next()
+}
+
+// RequirePermission must run after Auth.
+func RequirePermission(perm rbac.Permission) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		value, _ := c.Get(RoleKey)
+		role, ok := value.(rbac.Role)
+
+		if !ok || !role.Can(perm) {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{
+				"message": "Insufficient permissions",
+			})
+			return
+		}
+
+		if value, ok := c.Get(TokenKey); ok && !hasScope(value.(repository.APIToken), perm) {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{
+				"message": "Insufficient token scope",
+			})
 			return
 		}
 
 		c.Next()
 	}
 }
+
+// RequireSession rejects API tokens, for routes that manage credentials.
+// It must run after Auth.
+func RequireSession() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if _, ok := c.Get(TokenKey); ok {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{
+				"message": "Not allowed with an API token",
+			})
+			return
+		}
+
+		c.Next()
+	}
+}
+
+func hasScope(token repository.APIToken, perm rbac.Permission) bool {
+	for _, scope := range token.Scopes {
+		if scope == perm {
+			return true
+		}
+	}
+
+	return false
+}
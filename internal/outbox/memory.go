@@ -0,0 +1,173 @@
+package outbox
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/dvher/nibbin.cl_back/internal/mailer"
+)
+
+type memoryStore struct {
+	mu      sync.Mutex
+	entries map[int]Entry
+	lastID  int
+}
+
+// NewMemoryStore keeps the outbox in memory, for tests.
+func NewMemoryStore() Store {
+	return &memoryStore{entries: make(map[int]Entry)}
+}
+
+func (s *memoryStore) Add(_ context.Context, msg mailer.Message, now time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.lastID++
+
+	s.entries[s.lastID] = Entry{
+		ID:          s.lastID,
+		To:          append([]string(nil), msg.To...),
+		Subject:     msg.Subject,
+		Body:        msg.HTML,
+		Text:        msg.Text,
+		State:       StatePending,
+		NextAttempt: now,
+		Created:     now,
+	}
+
+	return nil
+}
+
+func (s *memoryStore) Claim(_ context.Context, now time.Time, lease time.Duration, limit int) ([]Entry, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var due []Entry
+
+	for _, e := range s.sorted() {
+		if e.State == StatePending && !e.NextAttempt.After(now) {
+			due = append(due, e)
+		}
+	}
+
+	sort.SliceStable(due, func(i, j int) bool {
+		return due[i].NextAttempt.Before(due[j].NextAttempt)
+	})
+
+	if len(due) > limit {
+		due = due[:limit]
+	}
+
+	for _, e := range due {
+		e.NextAttempt = now.Add(lease)
+		s.entries[e.ID] = e
+	}
+
+	return due, nil
+}
+
+func (s *memoryStore) MarkSent(_ context.Context, id int, now time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	e, ok := s.entries[id]
+
+	if !ok {
+		return ErrNotFound
+	}
+
+	e.State = StateSent
+	e.Sent = &now
+	e.LastError = ""
+	s.entries[id] = e
+
+	return nil
+}
+
+func (s *memoryStore) MarkFailed(_ context.Context, id, attempts int, next time.Time, reason string, dead bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	e, ok := s.entries[id]
+
+	if !ok {
+		return ErrNotFound
+	}
+
+	e.State = StatePending
+
+	if dead {
+		e.State = StateDead
+	}
+
+	e.Attempts = attempts
+	e.NextAttempt = next
+	e.LastError = reason
+	s.entries[id] = e
+
+	return nil
+}
+
+func (s *memoryStore) List(_ context.Context, state State, limit int) ([]Entry, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var entries []Entry
+
+	sorted := s.sorted()
+
+	for i := len(sorted) - 1; i >= 0 && len(entries) < limit; i-- {
+		if state == "" || sorted[i].State == state {
+			entries = append(entries, sorted[i])
+		}
+	}
+
+	return entries, nil
+}
+
+func (s *memoryStore) Retry(_ context.Context, id int, now time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	e, ok := s.entries[id]
+
+	if !ok || e.State != StateDead {
+		return ErrNotFound
+	}
+
+	e.State = StatePending
+	e.Attempts = 0
+	e.NextAttempt = now
+	s.entries[id] = e
+
+	return nil
+}
+
+func (s *memoryStore) Purge(_ context.Context, before time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for id, e := range s.entries {
+		if e.State != StatePending && e.Created.Before(before) {
+			delete(s.entries, id)
+		}
+	}
+
+	return nil
+}
+
+func (s *memoryStore) sorted() []Entry {
+	entries := make([]Entry, 0, len(s.entries))
+
+	for _, e := range s.entries {
+		entries = append(entries, e)
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].ID < entries[j].ID
+	})
+
+	return entries
+}
@@ -0,0 +1,203 @@
+package outbox
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"strings"
+	"time"
+
+	"github.com/dvher/nibbin.cl_back/internal/mailer"
+)
+
+// The Correo table is created by internal/database/migrations/0005_correo.up.sql.
+type mysqlStore struct {
+	db *sql.DB
+}
+
+func NewMySQLStore(db *sql.DB) Store {
+	return &mysqlStore{db: db}
+}
+
+const entryColumns = "id, destinatarios, asunto, cuerpo, texto, estado, intentos, proximo, error, creado, enviado"
+
+func (s *mysqlStore) Add(ctx context.Context, msg mailer.Message, now time.Time) error {
+	to, err := json.Marshal(msg.To)
+
+	if err != nil {
+		return err
+	}
+
+	_, err = s.db.ExecContext(
+		ctx,
+		"INSERT INTO Correo (destinatarios, asunto, cuerpo, texto, estado, proximo, creado) VALUES (?, ?, ?, ?, ?, ?, ?);",
+		to, msg.Subject, msg.HTML, msg.Text, StatePending, now, now,
+	)
+
+	return err
+}
+
+func (s *mysqlStore) Claim(ctx context.Context, now time.Time, lease time.Duration, limit int) ([]Entry, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer tx.Rollback()
+
+	rows, err := tx.QueryContext(
+		ctx,
+		"SELECT "+entryColumns+" FROM Correo WHERE estado = ? AND proximo <= ? "+
+			"ORDER BY proximo LIMIT ? FOR UPDATE SKIP LOCKED;",
+		StatePending, now, limit,
+	)
+
+	if err != nil {
+		return nil, err
+	}
+
+	entries, err := scanEntries(rows)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if len(entries) == 0 {
+		return nil, tx.Commit()
+	}
+
+	ids := make([]any, 0, len(entries)+1)
+	ids = append(ids, now.Add(lease))
+
+	for _, e := range entries {
+		ids = append(ids, e.ID)
+	}
+
+	_, err = tx.ExecContext(
+		ctx,
+		"UPDATE Correo SET proximo = ? WHERE id IN (?"+strings.Repeat(", ?", len(entries)-1)+");",
+		ids...,
+	)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return entries, tx.Commit()
+}
+
+func (s *mysqlStore) MarkSent(ctx context.Context, id int, now time.Time) error {
+	_, err := s.db.ExecContext(
+		ctx,
+		"UPDATE Correo SET estado = ?, enviado = ?, error = NULL WHERE id = ?;",
+		StateSent, now, id,
+	)
+
+	return err
+}
+
+func (s *mysqlStore) MarkFailed(ctx context.Context, id, attempts int, next time.Time, reason string, dead bool) error {
+	state := StatePending
+
+	if dead {
+		state = StateDead
+	}
+
+	_, err := s.db.ExecContext(
+		ctx,
+		"UPDATE Correo SET estado = ?, intentos = ?, proximo = ?, error = ? WHERE id = ?;",
+		state, attempts, next, reason, id,
+	)
+
+	return err
+}
+
+func (s *mysqlStore) List(ctx context.Context, state State, limit int) ([]Entry, error) {
+	var rows *sql.Rows
+	var err error
+
+	if state == "" {
+		rows, err = s.db.QueryContext(ctx, "SELECT "+entryColumns+" FROM Correo ORDER BY id DESC LIMIT ?;", limit)
+	} else {
+		rows, err = s.db.QueryContext(
+			ctx,
+			"SELECT "+entryColumns+" FROM Correo WHERE estado = ? ORDER BY id DESC LIMIT ?;",
+			state, limit,
+		)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return scanEntries(rows)
+}
+
+func (s *mysqlStore) Retry(ctx context.Context, id int, now time.Time) error {
+	res, err := s.db.ExecContext(
+		ctx,
+		"UPDATE Correo SET estado = ?, intentos = 0, proximo = ? WHERE id = ? AND estado = ?;",
+		StatePending, now, id, StateDead,
+	)
+
+	if err != nil {
+		return err
+	}
+
+	n, err := res.RowsAffected()
+
+	if err != nil {
+		return err
+	}
+
+	if n == 0 {
+		return ErrNotFound
+	}
+
+	return nil
+}
+
+func (s *mysqlStore) Purge(ctx context.Context, before time.Time) error {
+	_, err := s.db.ExecContext(
+		ctx,
+		"DELETE FROM Correo WHERE estado IN (?, ?) AND creado < ?;",
+		StateSent, StateDead, before,
+	)
+
+	return err
+}
+
+func scanEntries(rows *sql.Rows) ([]Entry, error) {
+	defer rows.Close()
+
+	var entries []Entry
+
+	for rows.Next() {
+		var e Entry
+		var to []byte
+		var text, reason sql.NullString
+		var sent sql.NullTime
+
+		err := rows.Scan(&e.ID, &to, &e.Subject, &e.Body, &text, &e.State, &e.Attempts, &e.NextAttempt, &reason, &e.Created, &sent)
+
+		if err != nil {
+			return nil, err
+		}
+
+		if err := json.Unmarshal(to, &e.To); err != nil {
+			return nil, err
+		}
+
+		e.Text = text.String
+		e.LastError = reason.String
+
+		if sent.Valid {
+			e.Sent = &sent.Time
+		}
+
+		entries = append(entries, e)
+	}
+
+	return entries, rows.Err()
+}
@@ -0,0 +1,268 @@
+// Package outbox stores outgoing emails and delivers them in the
+// background, so a mail server outage doesn't fail the request that
+// produced them.
+package outbox
+
+import (
+	"context"
+	"errors"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/dvher/nibbin.cl_back/internal/mailer"
+)
+
+type State string
+
+const (
+	StatePending State = "pendiente"
+	StateSent    State = "enviado"
+	StateDead    State = "fallido"
+)
+
+const (
+	pollEvery   = 10 * time.Second
+	purgeEvery  = 10 * time.Minute
+	batchSize   = 20
+	maxAttempts = 8
+	baseBackoff = 30 * time.Second
+	maxBackoff  = time.Hour
+	listLimit   = 100
+
+	// sendTimeout is the longest a Send may take: the SMTP mailer may fail
+	// on a stale connection, then dial and send again.
+	sendTimeout = 4 * mailer.Timeout
+	// claimLease hides a batch from other workers for as long as sending
+	// all of it may take.
+	claimLease = batchSize * sendTimeout
+)
+
+var ErrNotFound = errors.New("email not found")
+
+// Entry is an email in the outbox. The bodies are never serialized since
+// they may hold one time codes.
+type Entry struct {
+	ID          int        `json:"id"`
+	To          []string   `json:"to"`
+	Subject     string     `json:"subject"`
+	Body        string     `json:"-"`
+	Text        string     `json:"-"`
+	State       State      `json:"state"`
+	Attempts    int        `json:"attempts"`
+	NextAttempt time.Time  `json:"nextAttempt"`
+	LastError   string     `json:"lastError,omitempty"`
+	Created     time.Time  `json:"created"`
+	Sent        *time.Time `json:"sent,omitempty"`
+}
+
+func (e Entry) message() mailer.Message {
+	return mailer.Message{
+		To:      e.To,
+		Subject: e.Subject,
+		HTML:    e.Body,
+		Text:    e.Text,
+	}
+}
+
+type Store interface {
+	Add(ctx context.Context, msg mailer.Message, now time.Time) error
+	// Claim returns up to limit pending emails that are due and hides them
+	// from other workers for lease.
+	Claim(ctx context.Context, now time.Time, lease time.Duration, limit int) ([]Entry, error)
+	MarkSent(ctx context.Context, id int, now time.Time) error
+	MarkFailed(ctx context.Context, id, attempts int, next time.Time, reason string, dead bool) error
+	// List returns the latest emails in state, or in any state if it is empty.
+	List(ctx context.Context, state State, limit int) ([]Entry, error)
+	// Retry makes a dead email pending again.
+	Retry(ctx context.Context, id int, now time.Time) error
+	// Purge deletes the sent and dead emails created before before.
+	Purge(ctx context.Context, before time.Time) error
+}
+
+// Outbox queues emails in a Store and delivers them with a Mailer.
+type Outbox struct {
+	store     Store
+	mailer    mailer.Mailer
+	retention time.Duration
+	now       func() time.Time
+	wake      chan struct{}
+	done      chan struct{}
+	once      sync.Once
+	wg        sync.WaitGroup
+}
+
+// New starts the delivery worker and the purge of emails that were sent
+// or given up on more than retention ago. Close stops both.
+func New(store Store, m mailer.Mailer, retention time.Duration) *Outbox {
+	o := newOutbox(store, m)
+	o.retention = retention
+
+	o.wg.Add(2)
+	go o.run()
+	go o.purge()
+
+	return o
+}
+
+func newOutbox(store Store, m mailer.Mailer) *Outbox {
+	return &Outbox{
+		store:  store,
+		mailer: m,
+		now:    func() time.Time { return time.Now().UTC() },
+		wake:   make(chan struct{}, 1),
+		done:   make(chan struct{}),
+	}
+}
+
+func (o *Outbox) Enqueue(ctx context.Context, msg mailer.Message) error {
+	if err := o.store.Add(ctx, msg, time.Now().UTC()); err != nil {
+		return err
+	}
+
+	o.notify()
+
+	return nil
+}
+
+func (o *Outbox) List(ctx context.Context, state State) ([]Entry, error) {
+	return o.store.List(ctx, state, listLimit)
+}
+
+func (o *Outbox) Retry(ctx context.Context, id int) error {
+	if err := o.store.Retry(ctx, id, time.Now().UTC()); err != nil {
+		return err
+	}
+
+	o.notify()
+
+	return nil
+}
+
+func (o *Outbox) Close() error {
+	o.once.Do(func() {
+		close(o.done)
+	})
+
+	o.wg.Wait()
+
+	return nil
+}
+
+func (o *Outbox) notify() {
+	select {
+	case o.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (o *Outbox) run() {
+	defer o.wg.Done()
+
+	ticker := time.NewTicker(pollEvery)
+	defer ticker.Stop()
+
+	for {
+		o.deliver()
+
+		select {
+		case <-o.done:
+			return
+		case <-ticker.C:
+		case <-o.wake:
+		}
+	}
+}
+
+func (o *Outbox) purge() {
+	defer o.wg.Done()
+
+	ticker := time.NewTicker(purgeEvery)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-o.done:
+			return
+		case now := <-ticker.C:
+			if err := o.store.Purge(context.Background(), now.UTC().Add(-o.retention)); err != nil {
+				log.Println("Error purging old emails", err)
+			}
+		}
+	}
+}
+
+func (o *Outbox) deliver() {
+	ctx := context.Background()
+
+	for {
+		entries, err := o.store.Claim(ctx, o.now(), claimLease, batchSize)
+
+		if err != nil {
+			log.Println("Error claiming emails", err)
+			return
+		}
+
+		for _, e := range entries {
+			select {
+			case <-o.done:
+				// The rest are picked up again once their lease expires.
+				return
+			default:
+			}
+
+			o.send(ctx, e)
+		}
+
+		if len(entries) < batchSize {
+			return
+		}
+	}
+}
+
+func (o *Outbox) send(ctx context.Context, e Entry) {
+	sendErr := o.mailer.Send(e.message())
+	now := o.now()
+
+	if sendErr == nil {
+		if err := o.store.MarkSent(ctx, e.ID, now); err != nil {
+			log.Println("Error marking email as sent", err)
+		}
+
+		return
+	}
+
+	attempts := e.Attempts + 1
+	dead := attempts >= maxAttempts
+
+	if dead {
+		log.Println("Giving up on email", e.ID, sendErr)
+	} else {
+		log.Println("Error sending email", e.ID, sendErr)
+	}
+
+	err := o.store.MarkFailed(ctx, e.ID, attempts, now.Add(backoff(attempts)), sendErr.Error(), dead)
+
+	if err != nil {
+		log.Println("Error marking email as failed", err)
+	}
+}
+
+// backoff doubles the wait after every failed attempt, up to maxBackoff.
+func backoff(attempts int) time.Duration {
+	if attempts <= 0 {
+		return baseBackoff
+	}
+
+	if attempts > 16 {
+		return maxBackoff
+	}
+
+	d := baseBackoff << (attempts - 1)
+
+	if d > maxBackoff {
+		return maxBackoff
+	}
+
+	return d
+}
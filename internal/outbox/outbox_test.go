@@ -0,0 +1,219 @@
+package outbox
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/dvher/nibbin.cl_back/internal/mailer"
+	"github.com/google/go-cmp/cmp"
+)
+
+type flakyMailer struct {
+	*mailer.Recorder
+	failures int
+}
+
+func (m *flakyMailer) Send(msg mailer.Message) error {
+	if m.failures > 0 {
+		m.failures--
+		return errors.New("connection refused")
+	}
+
+	return m.Recorder.Send(msg)
+}
+
+func TestOutboxRetries(t *testing.T) {
+	ctx := context.Background()
+	m := &flakyMailer{Recorder: mailer.NewRecorder(), failures: maxAttempts}
+	o := newOutbox(NewMemoryStore(), m)
+
+	err := o.Enqueue(ctx, mailer.Message{To: []string{"user@nibbin.cl"}, Subject: "Código de verificación"})
+
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	now := time.Now().UTC()
+	o.now = func() time.Time { return now }
+
+	for attempt := 1; attempt <= maxAttempts; attempt++ {
+		o.deliver()
+
+		entries, err := o.List(ctx, "")
+
+		if err != nil {
+			t.Error(err)
+			return
+		}
+
+		if entries[0].Attempts != attempt {
+			t.Errorf("Got %d attempts when should be %d\n", entries[0].Attempts, attempt)
+			return
+		}
+
+		// Nothing is due until the backoff has passed.
+		o.deliver()
+
+		now = now.Add(backoff(attempt))
+	}
+
+	dead, err := o.List(ctx, StateDead)
+
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if len(dead) != 1 {
+		t.Errorf("Got %d dead emails when should be 1\n", len(dead))
+		return
+	}
+
+	if err := o.Retry(ctx, dead[0].ID); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if err := o.Retry(ctx, dead[0].ID); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Got error %v when retrying a pending email when should be %v\n", err, ErrNotFound)
+	}
+
+	o.deliver()
+
+	sent, err := o.List(ctx, StateSent)
+
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if len(sent) != 1 || len(m.Messages()) != 1 {
+		t.Errorf("Got %d sent and %d delivered emails when should be 1\n", len(sent), len(m.Messages()))
+	}
+}
+
+// slowMailer takes sendTimeout for every email and checks that none of
+// the batch can be claimed by another worker meanwhile.
+type slowMailer struct {
+	*mailer.Recorder
+	t      *testing.T
+	store  Store
+	now    *time.Time
+	stolen int
+}
+
+func (m *slowMailer) Send(msg mailer.Message) error {
+	*m.now = m.now.Add(sendTimeout / 2)
+
+	entries, err := m.store.Claim(context.Background(), *m.now, claimLease, batchSize)
+
+	if err != nil {
+		m.t.Error(err)
+	}
+
+	m.stolen += len(entries)
+	*m.now = m.now.Add(sendTimeout / 2)
+
+	return m.Recorder.Send(msg)
+}
+
+func TestOutboxLease(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryStore()
+	now := time.Now().UTC()
+	m := &slowMailer{Recorder: mailer.NewRecorder(), t: t, store: store, now: &now}
+	o := newOutbox(store, m)
+	o.now = func() time.Time { return now }
+
+	for i := 0; i < batchSize; i++ {
+		if err := o.Enqueue(ctx, mailer.Message{To: []string{"user@nibbin.cl"}, Subject: "Pedido"}); err != nil {
+			t.Error(err)
+			return
+		}
+	}
+
+	now = time.Now().UTC()
+	o.deliver()
+
+	if m.stolen != 0 {
+		t.Errorf("Got %d emails claimed again while the batch was sending when should be 0\n", m.stolen)
+	}
+
+	if len(m.Messages()) != batchSize {
+		t.Errorf("Got %d delivered emails when should be %d\n", len(m.Messages()), batchSize)
+		return
+	}
+
+	sent, err := o.List(ctx, StateSent)
+
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	// Every email is marked sent when it was, not when the batch began.
+	if len(sent) != batchSize || !sent[0].Sent.Equal(now) {
+		t.Errorf("Got %d sent emails, the last at %v when should be %d at %v\n", len(sent), sent[0].Sent, batchSize, now)
+	}
+}
+
+func TestMemoryStorePurge(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryStore()
+	old := time.Now().UTC().Add(-48 * time.Hour)
+	msg := mailer.Message{To: []string{"user@nibbin.cl"}, Subject: "Pedido"}
+
+	for i := 0; i < 4; i++ {
+		if err := store.Add(ctx, msg, old); err != nil {
+			t.Error(err)
+			return
+		}
+	}
+
+	if err := store.Add(ctx, msg, time.Now().UTC()); err != nil {
+		t.Error(err)
+		return
+	}
+
+	// 1 stays pending, 2 is sent, 3 is dead, 4 is still being retried and 5
+	// was sent but is too recent.
+	store.MarkSent(ctx, 2, old)
+	store.MarkFailed(ctx, 3, maxAttempts, old, "connection refused", true)
+	store.MarkFailed(ctx, 4, 1, old, "connection refused", false)
+	store.MarkSent(ctx, 5, time.Now().UTC())
+
+	if err := store.Purge(ctx, time.Now().UTC().Add(-24*time.Hour)); err != nil {
+		t.Error(err)
+		return
+	}
+
+	entries, err := store.List(ctx, "", listLimit)
+
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	var ids []int
+
+	for _, e := range entries {
+		ids = append(ids, e.ID)
+	}
+
+	if diff := cmp.Diff([]int{5, 4, 1}, ids); diff != "" {
+		t.Errorf("Got remaining emails diff %s\n", diff)
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	if backoff(1) != baseBackoff || backoff(2) != 2*baseBackoff {
+		t.Errorf("Got backoffs %v and %v when should be %v and %v\n", backoff(1), backoff(2), baseBackoff, 2*baseBackoff)
+	}
+
+	if backoff(64) != maxBackoff {
+		t.Errorf("Got backoff %v when should be capped at %v\n", backoff(64), maxBackoff)
+	}
+}
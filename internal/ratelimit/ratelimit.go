@@ -0,0 +1,105 @@
+// Package ratelimit implements in-memory sliding window limits. Each
+// server instance keeps its own counters.
+package ratelimit
+
+import (
+	"sync"
+	"time"
+)
+
+// Limiter allows up to Limit events per key in any Period long window.
+type Limiter struct {
+	limit  int
+	period time.Duration
+
+	mu        sync.Mutex
+	hits      map[string][]time.Time
+	lastSweep time.Time
+}
+
+func New(limit int, period time.Duration) *Limiter {
+	return &Limiter{
+		limit:  limit,
+		period: period,
+		hits:   make(map[string][]time.Time),
+	}
+}
+
+// Allow records an event for key if it is within the limit. Otherwise it
+// returns how long until the oldest event leaves the window.
+func (l *Limiter) Allow(key string) (bool, time.Duration) {
+	return l.allowAt(key, time.Now())
+}
+
+// Check reports whether Allow would accept an event for key, without
+// recording one.
+func (l *Limiter) Check(key string) (bool, time.Duration) {
+	return l.checkAt(key, time.Now())
+}
+
+func (l *Limiter) allowAt(key string, now time.Time) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.sweep(now)
+
+	hits := l.prune(l.hits[key], now)
+
+	if len(hits) >= l.limit {
+		l.hits[key] = hits
+		return false, hits[0].Add(l.period).Sub(now)
+	}
+
+	l.hits[key] = append(hits, now)
+
+	return true, 0
+}
+
+func (l *Limiter) checkAt(key string, now time.Time) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	hits := l.prune(l.hits[key], now)
+
+	if len(hits) >= l.limit {
+		return false, hits[0].Add(l.period).Sub(now)
+	}
+
+	return true, 0
+}
+
+// Reset forgets every event recorded for key.
+func (l *Limiter) Reset(key string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	delete(l.hits, key)
+}
+
+func (l *Limiter) prune(hits []time.Time, now time.Time) []time.Time {
+	start := now.Add(-l.period)
+	i := 0
+
+	for i < len(hits) && !hits[i].After(start) {
+		i++
+	}
+
+	return hits[i:]
+}
+
+// sweep drops idle keys once per period so the map doesn't grow forever.
+func (l *Limiter) sweep(now time.Time) {
+	if now.Sub(l.lastSweep) < l.period {
+		return
+	}
+
+	l.lastSweep = now
+
+	for key, hits := range l.hits {
+		if hits = l.prune(hits, now); len(hits) == 0 {
+			delete(l.hits, key)
+		} else {
+			l.hits[key] = hits
+		}
+	}
+}
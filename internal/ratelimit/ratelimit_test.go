@@ -0,0 +1,62 @@
+package ratelimit
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLimiter(t *testing.T) {
+	l := New(2, time.Minute)
+	start := time.Now()
+
+	for i := 0; i < 2; i++ {
+		if ok, _ := l.allowAt("user@nibbin.cl", start.Add(time.Duration(i)*time.Second)); !ok {
+			t.Errorf("Event %d was rejected when should be allowed\n", i)
+			return
+		}
+	}
+
+	ok, retry := l.allowAt("user@nibbin.cl", start.Add(10*time.Second))
+
+	if ok {
+		t.Errorf("Third event was allowed when should be rejected\n")
+		return
+	}
+
+	if retry != 50*time.Second {
+		t.Errorf("Got retry after %v when should be %v\n", retry, 50*time.Second)
+	}
+
+	if ok, _ := l.allowAt("other@nibbin.cl", start.Add(10*time.Second)); !ok {
+		t.Errorf("Event for another key was rejected when should be allowed\n")
+	}
+
+	// The window slides: the first event leaves it after a minute.
+	if ok, _ := l.allowAt("user@nibbin.cl", start.Add(time.Minute+time.Millisecond)); !ok {
+		t.Errorf("Event after the window was rejected when should be allowed\n")
+	}
+
+	if ok, _ := l.allowAt("user@nibbin.cl", start.Add(time.Minute+2*time.Millisecond)); ok {
+		t.Errorf("Event was allowed when should be rejected\n")
+	}
+
+	if ok, _ := l.checkAt("other@nibbin.cl", start.Add(time.Minute+2*time.Millisecond)); !ok {
+		t.Errorf("Check was rejected when should be allowed\n")
+	}
+
+	if ok, _ := l.checkAt("user@nibbin.cl", start.Add(time.Minute+2*time.Millisecond)); ok {
+		t.Errorf("Check was allowed when should be rejected\n")
+	}
+
+	l.Reset("user@nibbin.cl")
+
+	if ok, _ := l.allowAt("user@nibbin.cl", start.Add(time.Minute+3*time.Millisecond)); !ok {
+		t.Errorf("Event after a reset was rejected when should be allowed\n")
+	}
+
+	l.sweep(start.Add(10 * time.Minute))
+
+	if len(l.hits) != 0 {
+		t.Errorf("Got %d keys after a sweep when should be 0\n", len(l.hits))
+	}
+}
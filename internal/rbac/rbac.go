@@ -0,0 +1,78 @@
+package rbac
+
+type Role string
+
+type Permission string
+
+const (
+	RoleSuperAdmin     Role = "superadmin"
+	RoleCatalogManager Role = "catalog"
+	RoleOrderManager   Role = "orders"
+	RoleSupport        Role = "support"
+)
+
+const (
+	PermProductRead  Permission = "product:read"
+	PermProductWrite Permission = "product:write"
+	PermOrderRead    Permission = "order:read"
+	PermOrderWrite   Permission = "order:write"
+	PermUserRead     Permission = "user:read"
+	PermAdminRead    Permission = "admin:read"
+	PermAdminWrite   Permission = "admin:write"
+)
+
+// DefaultRole is given to newly registered administrators.
+const DefaultRole = RoleSupport
+
+var rolePermissions = map[Role][]Permission{
+	RoleSuperAdmin: {
+		PermProductRead, PermProductWrite,
+		PermOrderRead, PermOrderWrite,
+		PermUserRead,
+		PermAdminRead, PermAdminWrite,
+	},
+	RoleCatalogManager: {
+		PermProductRead, PermProductWrite,
+	},
+	RoleOrderManager: {
+		PermProductRead,
+		PermOrderRead, PermOrderWrite,
+		PermUserRead,
+	},
+	RoleSupport: {
+		PermProductRead,
+		PermOrderRead,
+		PermUserRead,
+	},
+}
+
+func (r Role) Valid() bool {
+	_, ok := rolePermissions[r]
+
+	return ok
+}
+
+func (r Role) Can(p Permission) bool {
+	for _, perm := range rolePermissions[r] {
+		if perm == p {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (r Role) Permissions() []Permission {
+	return append([]Permission(nil), rolePermissions[r]...)
+}
+
+// Roles returns every known role with its permissions.
+func Roles() map[Role][]Permission {
+	roles := make(map[Role][]Permission, len(rolePermissions))
+
+	for role := range rolePermissions {
+		roles[role] = role.Permissions()
+	}
+
+	return roles
+}
@@ -0,0 +1,74 @@
+package rbac
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestValid(t *testing.T) {
+	for _, role := range []Role{RoleSuperAdmin, RoleCatalogManager, RoleOrderManager, RoleSupport, DefaultRole} {
+		if !role.Valid() {
+			t.Errorf("Got %s invalid when should be valid\n", role)
+		}
+	}
+
+	for _, role := range []Role{"", "admin", "SUPERADMIN"} {
+		if role.Valid() {
+			t.Errorf("Got %q valid when should be invalid\n", role)
+		}
+	}
+}
+
+func TestCan(t *testing.T) {
+	tests := []struct {
+		role Role
+		perm Permission
+		want bool
+	}{
+		{RoleSuperAdmin, PermAdminWrite, true},
+		{RoleCatalogManager, PermProductWrite, true},
+		{RoleCatalogManager, PermOrderRead, false},
+		{RoleOrderManager, PermOrderWrite, true},
+		{RoleOrderManager, PermProductWrite, false},
+		{RoleSupport, PermUserRead, true},
+		{RoleSupport, PermAdminRead, false},
+		{"unknown", PermProductRead, false},
+	}
+
+	for _, tt := range tests {
+		if got := tt.role.Can(tt.perm); got != tt.want {
+			t.Errorf("Got %v for %s can %s when should be %v\n", got, tt.role, tt.perm, tt.want)
+		}
+	}
+}
+
+func TestPermissions(t *testing.T) {
+	want := []Permission{PermProductRead, PermProductWrite}
+
+	got := RoleCatalogManager.Permissions()
+
+	if !cmp.Equal(got, want) {
+		t.Errorf("Got permissions %v when should be %v\n", got, want)
+		return
+	}
+
+	// Callers get a copy, changing it doesn't grant anything.
+	got[0] = PermAdminWrite
+
+	if RoleCatalogManager.Can(PermAdminWrite) {
+		t.Errorf("Got a role changed through the slice returned by Permissions\n")
+	}
+
+	if perms := Role("unknown").Permissions(); len(perms) != 0 {
+		t.Errorf("Got permissions %v for an unknown role when should be none\n", perms)
+	}
+
+	for role, perms := range Roles() {
+		for _, perm := range perms {
+			if !role.Can(perm) {
+				t.Errorf("Got %s listed for %s but not allowed\n", perm, role)
+			}
+		}
+	}
+}
@@ -0,0 +1,322 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/dvher/nibbin.cl_back/internal/rbac"
+	"github.com/dvher/nibbin.cl_back/pkg/models"
+)
+
+// Administrador.rol is added by internal/database/migrations/0004_admin_rol.up.sql,
+// which gives the admins that predate it the superadmin role. The TOTP
+// columns come from 0008_admin_totp.up.sql.
+type mysqlAdminRepository struct {
+	db *sql.DB
+}
+
+func NewMySQLAdminRepository(db *sql.DB) AdminRepository {
+	return &mysqlAdminRepository{db: db}
+}
+
+func (r *mysqlAdminRepository) Credentials(ctx context.Context, user string) (AdminCredentials, error) {
+	var creds AdminCredentials
+
+	err := r.db.QueryRowContext(
+		ctx,
+		"SELECT Administrador.id, usuario, email, rol, contrasena, totp IS NOT NULL FROM Administrador "+
+			"JOIN Usuario ON Usuario.id = Administrador.idUsuario WHERE Usuario.usuario = ?;",
+		user,
+	).Scan(&creds.ID, &creds.User, &creds.Email, &creds.Role, &creds.PasswordHash, &creds.TOTPEnabled)
+
+	if errors.Is(err, sql.ErrNoRows) {
+		return AdminCredentials{}, ErrNotFound
+	}
+
+	return creds, err
+}
+
+func (r *mysqlAdminRepository) Role(ctx context.Context, user, email string) (rbac.Role, error) {
+	var role rbac.Role
+
+	err := r.db.QueryRowContext(
+		ctx,
+		"SELECT Administrador.rol FROM Administrador JOIN Usuario ON Usuario.id = Administrador.idUsuario "+
+			"WHERE Usuario.usuario = ? AND Usuario.email = ?;",
+		user, email,
+	).Scan(&role)
+
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", ErrNotFound
+	}
+
+	return role, err
+}
+
+func (r *mysqlAdminRepository) List(ctx context.Context) ([]models.Admin, error) {
+	rows, err := r.db.QueryContext(
+		ctx,
+		"SELECT Administrador.id, usuario, email, rol FROM Administrador JOIN Usuario ON Usuario.id = Administrador.idUsuario;",
+	)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	var admins []models.Admin
+
+	for rows.Next() {
+		var admin models.Admin
+
+		if err := rows.Scan(&admin.ID, &admin.User, &admin.Email, &admin.Role); err != nil {
+			return nil, err
+		}
+
+		admins = append(admins, admin)
+	}
+
+	return admins, rows.Err()
+}
+
+func (r *mysqlAdminRepository) Create(ctx context.Context, userID int, passwordHash string, role rbac.Role) error {
+	_, err := r.db.ExecContext(
+		ctx,
+		"INSERT INTO Administrador (idUsuario, contrasena, rol) VALUES (?, ?, ?);",
+		userID, passwordHash, role,
+	)
+
+	return err
+}
+
+func (r *mysqlAdminRepository) SetRole(ctx context.Context, user string, role rbac.Role) error {
+	res, err := r.db.ExecContext(
+		ctx,
+		"UPDATE Administrador JOIN Usuario ON Usuario.id = Administrador.idUsuario SET Administrador.rol = ? WHERE Usuario.usuario = ?;",
+		role, user,
+	)
+
+	if err != nil {
+		return err
+	}
+
+	return expectRows(res)
+}
+
+func (r *mysqlAdminRepository) UpdatePasswordHash(ctx context.Context, user, oldHash, newHash string) error {
+	res, err := r.db.ExecContext(
+		ctx,
+		"UPDATE Administrador JOIN Usuario ON Usuario.id = Administrador.idUsuario SET contrasena = ? "+
+			"WHERE Usuario.usuario = ? AND contrasena = ?;",
+		newHash, user, oldHash,
+	)
+
+	if err != nil {
+		return err
+	}
+
+	return expectRows(res)
+}
+
+func (r *mysqlAdminRepository) Delete(ctx context.Context, user string) error {
+	res, err := r.db.ExecContext(
+		ctx,
+		"DELETE Administrador FROM Administrador JOIN Usuario ON Usuario.id = Administrador.idUsuario WHERE Usuario.usuario = ?;",
+		user,
+	)
+
+	if err != nil {
+		return err
+	}
+
+	return expectRows(res)
+}
+
+func (r *mysqlAdminRepository) TOTP(ctx context.Context, user string) (TOTPState, error) {
+	var secret, pending sql.NullString
+	var state TOTPState
+
+	err := r.db.QueryRowContext(
+		ctx,
+		"SELECT totp, totpPendiente, totpPaso FROM Administrador "+
+			"JOIN Usuario ON Usuario.id = Administrador.idUsuario WHERE Usuario.usuario = ?;",
+		user,
+	).Scan(&secret, &pending, &state.LastStep)
+
+	if errors.Is(err, sql.ErrNoRows) {
+		return TOTPState{}, ErrNotFound
+	}
+
+	state.Secret = secret.String
+	state.Pending = pending.String
+
+	return state, err
+}
+
+func (r *mysqlAdminRepository) SetPendingTOTP(ctx context.Context, user, secret string) error {
+	res, err := r.db.ExecContext(
+		ctx,
+		"UPDATE Administrador JOIN Usuario ON Usuario.id = Administrador.idUsuario "+
+			"SET Administrador.totpPendiente = ? WHERE Usuario.usuario = ?;",
+		secret, user,
+	)
+
+	if err != nil {
+		return err
+	}
+
+	return expectRows(res)
+}
+
+func (r *mysqlAdminRepository) EnableTOTP(ctx context.Context, user string, step int64, recoveryHashes []string) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+
+	if err != nil {
+		return err
+	}
+
+	defer tx.Rollback()
+
+	var id int
+
+	err = tx.QueryRowContext(
+		ctx,
+		"SELECT Administrador.id FROM Administrador JOIN Usuario ON Usuario.id = Administrador.idUsuario "+
+			"WHERE Usuario.usuario = ? AND Administrador.totpPendiente IS NOT NULL FOR UPDATE;",
+		user,
+	).Scan(&id)
+
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrNotFound
+	}
+
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(
+		ctx,
+		"UPDATE Administrador SET totp = totpPendiente, totpPendiente = NULL, totpPaso = ? WHERE id = ?;",
+		step, id,
+	)
+
+	if err != nil {
+		return err
+	}
+
+	if _, err := tx.ExecContext(ctx, "DELETE FROM CodigoRecuperacion WHERE idAdministrador = ?;", id); err != nil {
+		return err
+	}
+
+	for _, hash := range recoveryHashes {
+		_, err := tx.ExecContext(ctx, "INSERT INTO CodigoRecuperacion (idAdministrador, hash) VALUES (?, ?);", id, hash)
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+func (r *mysqlAdminRepository) DisableTOTP(ctx context.Context, user string) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+
+	if err != nil {
+		return err
+	}
+
+	defer tx.Rollback()
+
+	res, err := tx.ExecContext(
+		ctx,
+		"UPDATE Administrador JOIN Usuario ON Usuario.id = Administrador.idUsuario "+
+			"SET Administrador.totp = NULL, Administrador.totpPendiente = NULL WHERE Usuario.usuario = ?;",
+		user,
+	)
+
+	if err != nil {
+		return err
+	}
+
+	if err := expectRows(res); err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(
+		ctx,
+		"DELETE CodigoRecuperacion FROM CodigoRecuperacion "+
+			"JOIN Administrador ON Administrador.id = CodigoRecuperacion.idAdministrador "+
+			"JOIN Usuario ON Usuario.id = Administrador.idUsuario WHERE Usuario.usuario = ?;",
+		user,
+	)
+
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (r *mysqlAdminRepository) UseTOTPStep(ctx context.Context, user string, step int64) error {
+	res, err := r.db.ExecContext(
+		ctx,
+		"UPDATE Administrador JOIN Usuario ON Usuario.id = Administrador.idUsuario "+
+			"SET Administrador.totpPaso = ? WHERE Usuario.usuario = ? AND Administrador.totpPaso < ?;",
+		step, user, step,
+	)
+
+	if err != nil {
+		return err
+	}
+
+	return expectRows(res)
+}
+
+func (r *mysqlAdminRepository) RecoveryCodes(ctx context.Context, user string) ([]RecoveryCode, error) {
+	rows, err := r.db.QueryContext(
+		ctx,
+		"SELECT CodigoRecuperacion.id, CodigoRecuperacion.hash FROM CodigoRecuperacion "+
+			"JOIN Administrador ON Administrador.id = CodigoRecuperacion.idAdministrador "+
+			"JOIN Usuario ON Usuario.id = Administrador.idUsuario "+
+			"WHERE Usuario.usuario = ? AND CodigoRecuperacion.usado IS NULL;",
+		user,
+	)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	var codes []RecoveryCode
+
+	for rows.Next() {
+		var code RecoveryCode
+
+		if err := rows.Scan(&code.ID, &code.Hash); err != nil {
+			return nil, err
+		}
+
+		codes = append(codes, code)
+	}
+
+	return codes, rows.Err()
+}
+
+func (r *mysqlAdminRepository) UseRecoveryCode(ctx context.Context, id int) error {
+	res, err := r.db.ExecContext(
+		ctx,
+		"UPDATE CodigoRecuperacion SET usado = ? WHERE id = ? AND usado IS NULL;",
+		time.Now().UTC(), id,
+	)
+
+	if err != nil {
+		return err
+	}
+
+	return expectRows(res)
+}
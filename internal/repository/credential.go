@@ -0,0 +1,92 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+	"time"
+)
+
+type mysqlCredentialRepository struct {
+	db *sql.DB
+}
+
+func NewMySQLCredentialRepository(db *sql.DB) CredentialRepository {
+	return &mysqlCredentialRepository{db: db}
+}
+
+func (r *mysqlCredentialRepository) ByUser(ctx context.Context, userID int) ([]Credential, error) {
+	rows, err := r.db.QueryContext(
+		ctx,
+		"SELECT id, idUsuario, credencial, clavePublica, tipoAtestacion, transportes, aaguid, contador, nombre, creado, usado "+
+			"FROM Credencial WHERE idUsuario = ? ORDER BY id;",
+		userID,
+	)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	var creds []Credential
+
+	for rows.Next() {
+		var cred Credential
+		var transports string
+		var used sql.NullTime
+
+		err := rows.Scan(
+			&cred.ID, &cred.UserID, &cred.CredentialID, &cred.PublicKey, &cred.AttestationType,
+			&transports, &cred.AAGUID, &cred.SignCount, &cred.Name, &cred.Created, &used,
+		)
+
+		if err != nil {
+			return nil, err
+		}
+
+		if transports != "" {
+			cred.Transports = strings.Split(transports, ",")
+		}
+
+		if used.Valid {
+			cred.LastUsed = &used.Time
+		}
+
+		creds = append(creds, cred)
+	}
+
+	return creds, rows.Err()
+}
+
+func (r *mysqlCredentialRepository) Create(ctx context.Context, cred Credential) error {
+	_, err := r.db.ExecContext(
+		ctx,
+		"INSERT INTO Credencial (idUsuario, credencial, clavePublica, tipoAtestacion, transportes, aaguid, contador, nombre, creado) "+
+			"VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?);",
+		cred.UserID, cred.CredentialID, cred.PublicKey, cred.AttestationType, strings.Join(cred.Transports, ","),
+		cred.AAGUID, cred.SignCount, cred.Name, cred.Created,
+	)
+
+	return err
+}
+
+func (r *mysqlCredentialRepository) Touch(ctx context.Context, id int, signCount uint32, used time.Time) error {
+	res, err := r.db.ExecContext(ctx, "UPDATE Credencial SET contador = ?, usado = ? WHERE id = ?;", signCount, used, id)
+
+	if err != nil {
+		return err
+	}
+
+	return expectRows(res)
+}
+
+func (r *mysqlCredentialRepository) Delete(ctx context.Context, userID, id int) error {
+	res, err := r.db.ExecContext(ctx, "DELETE FROM Credencial WHERE id = ? AND idUsuario = ?;", id, userID)
+
+	if err != nil {
+		return err
+	}
+
+	return expectRows(res)
+}
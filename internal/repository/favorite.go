@@ -0,0 +1,38 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+)
+
+type mysqlFavoriteRepository struct {
+	db *sql.DB
+}
+
+func NewMySQLFavoriteRepository(db *sql.DB) FavoriteRepository {
+	return &mysqlFavoriteRepository{db: db}
+}
+
+func (r *mysqlFavoriteRepository) Exists(ctx context.Context, userID, productID int) (bool, error) {
+	var exists bool
+
+	err := r.db.QueryRowContext(
+		ctx,
+		"SELECT EXISTS(SELECT * FROM Favorito WHERE idUsuario = ? AND idProducto = ?) AS existe;",
+		userID, productID,
+	).Scan(&exists)
+
+	return exists, err
+}
+
+func (r *mysqlFavoriteRepository) Add(ctx context.Context, userID, productID int) error {
+	_, err := r.db.ExecContext(ctx, "INSERT INTO Favorito (idProducto, idUsuario) VALUES (?, ?);", productID, userID)
+
+	return err
+}
+
+func (r *mysqlFavoriteRepository) Remove(ctx context.Context, userID, productID int) error {
+	_, err := r.db.ExecContext(ctx, "DELETE FROM Favorito WHERE idProducto = ? AND idUsuario = ?;", productID, userID)
+
+	return err
+}
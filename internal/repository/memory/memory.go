@@ -0,0 +1,825 @@
+// Package memory implements the repository interfaces on top of plain maps,
+// for use in tests.
+package memory
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dvher/nibbin.cl_back/internal/rbac"
+	"github.com/dvher/nibbin.cl_back/internal/repository"
+	"github.com/dvher/nibbin.cl_back/pkg/models"
+)
+
+type admin struct {
+	id           int
+	passwordHash string
+	role         rbac.Role
+	totp         string
+	totpPending  string
+	totpStep     int64
+	recovery     []recoveryCode
+}
+
+type recoveryCode struct {
+	id   int
+	hash string
+	used bool
+}
+
+type favorite struct {
+	userID    int
+	productID int
+}
+
+// Store holds the data shared by all the in-memory repositories.
+type Store struct {
+	mu        sync.Mutex
+	users     map[string]models.Usuario
+	products  []models.Producto
+	favorites map[favorite]bool
+	admins    map[int]admin
+	creds     []repository.Credential
+	tokens    []repository.APIToken
+	lastID    int
+}
+
+func New() *Store {
+	return &Store{
+		users:     make(map[string]models.Usuario),
+		favorites: make(map[favorite]bool),
+		admins:    make(map[int]admin),
+	}
+}
+
+type (
+	Users       struct{ *Store }
+	Products    struct{ *Store }
+	Favorites   struct{ *Store }
+	Admins      struct{ *Store }
+	Credentials struct{ *Store }
+	Tokens      struct{ *Store }
+)
+
+var (
+	_ repository.UserRepository       = Users{}
+	_ repository.ProductRepository    = Products{}
+	_ repository.FavoriteRepository   = Favorites{}
+	_ repository.AdminRepository      = Admins{}
+	_ repository.CredentialRepository = Credentials{}
+	_ repository.TokenRepository      = Tokens{}
+)
+
+func (s *Store) Users() Users             { return Users{s} }
+func (s *Store) Products() Products       { return Products{s} }
+func (s *Store) Favorites() Favorites     { return Favorites{s} }
+func (s *Store) Admins() Admins           { return Admins{s} }
+func (s *Store) Credentials() Credentials { return Credentials{s} }
+func (s *Store) Tokens() Tokens           { return Tokens{s} }
+
+func (s *Store) nextID() int {
+	s.lastID++
+
+	return s.lastID
+}
+
+func (s *Store) userByEmail(email string) (models.Usuario, bool) {
+	for _, u := range s.users {
+		if u.Email == email {
+			return u, true
+		}
+	}
+
+	return models.Usuario{}, false
+}
+
+func (u Users) IDByUsername(_ context.Context, user string) (int, error) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	found, ok := u.users[user]
+
+	if !ok {
+		return 0, repository.ErrNotFound
+	}
+
+	return found.ID, nil
+}
+
+func (u Users) IDByEmail(_ context.Context, email string) (int, error) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	found, ok := u.userByEmail(email)
+
+	if !ok {
+		return 0, repository.ErrNotFound
+	}
+
+	return found.ID, nil
+}
+
+func (u Users) UsernameByEmail(_ context.Context, email string) (string, error) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	found, ok := u.userByEmail(email)
+
+	if !ok {
+		return "", repository.ErrNotFound
+	}
+
+	return found.User, nil
+}
+
+func (u Users) ByID(_ context.Context, id int) (models.Usuario, error) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	for _, user := range u.users {
+		if user.ID == id {
+			return user, nil
+		}
+	}
+
+	return models.Usuario{}, repository.ErrNotFound
+}
+
+func (u Users) LocaleByEmail(_ context.Context, email string) (string, error) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	found, ok := u.userByEmail(email)
+
+	if !ok {
+		return "", repository.ErrNotFound
+	}
+
+	return found.Idioma, nil
+}
+
+func (u Users) Create(_ context.Context, user models.Usuario) error {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	user.ID = u.nextID()
+	u.users[user.User] = user
+
+	return nil
+}
+
+func (u Users) UpdateEmail(_ context.Context, user, email string) error {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	found, ok := u.users[user]
+
+	if !ok {
+		return repository.ErrNotFound
+	}
+
+	found.Email = email
+	u.users[user] = found
+
+	return nil
+}
+
+func (u Users) SetLocale(_ context.Context, user, locale string) error {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	found, ok := u.users[user]
+
+	if !ok {
+		return repository.ErrNotFound
+	}
+
+	found.Idioma = locale
+	u.users[user] = found
+
+	return nil
+}
+
+func (u Users) Delete(_ context.Context, user string) error {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	found, ok := u.users[user]
+
+	if !ok {
+		return repository.ErrNotFound
+	}
+
+	for fav := range u.favorites {
+		if fav.userID == found.ID {
+			delete(u.favorites, fav)
+		}
+	}
+
+	creds := u.creds[:0]
+
+	for _, cred := range u.creds {
+		if cred.UserID != found.ID {
+			creds = append(creds, cred)
+		}
+	}
+
+	u.creds = creds
+
+	delete(u.admins, found.ID)
+	delete(u.users, user)
+
+	return nil
+}
+
+func (p Products) List(_ context.Context, userID int) ([]models.DescProducto, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return p.filter(userID, func(models.Producto) bool { return true }), nil
+}
+
+func (p Products) Search(_ context.Context, userID int, query string) ([]models.DescProducto, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	query = strings.ToLower(query)
+
+	return p.filter(userID, func(prod models.Producto) bool {
+		return strings.Contains(strings.ToLower(prod.Nombre), query) ||
+			strings.Contains(strings.ToLower(prod.Marca), query) ||
+			strings.Contains(strings.ToLower(prod.Descripcion), query)
+	}), nil
+}
+
+func (p Products) ByID(_ context.Context, userID, id int) (models.DescProducto, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	products := p.filter(userID, func(prod models.Producto) bool { return prod.ID == id })
+
+	if len(products) == 0 {
+		return models.DescProducto{}, repository.ErrNotFound
+	}
+
+	return products[0], nil
+}
+
+func (p Products) Get(_ context.Context, id int) (models.Producto, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	i := p.find(id)
+
+	if i < 0 {
+		return models.Producto{}, repository.ErrNotFound
+	}
+
+	return p.products[i], nil
+}
+
+func (p Products) Create(_ context.Context, product models.Producto) (int, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	product.ID = p.nextID()
+	product.IsFavorite = false
+	product.Eliminado = nil
+
+	p.products = append(p.products, product)
+
+	return product.ID, nil
+}
+
+func (p Products) Update(_ context.Context, product models.Producto) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	i := p.find(product.ID)
+
+	if i < 0 || p.products[i].Eliminado != nil {
+		return repository.ErrNotFound
+	}
+
+	product.IsFavorite = false
+	product.Eliminado = nil
+	p.products[i] = product
+
+	return nil
+}
+
+func (p Products) Delete(_ context.Context, id int, at time.Time) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	i := p.find(id)
+
+	if i < 0 || p.products[i].Eliminado != nil {
+		return repository.ErrNotFound
+	}
+
+	p.products[i].Eliminado = &at
+
+	return nil
+}
+
+func (p Products) Restore(_ context.Context, id int) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	i := p.find(id)
+
+	if i < 0 || p.products[i].Eliminado == nil {
+		return repository.ErrNotFound
+	}
+
+	p.products[i].Eliminado = nil
+
+	return nil
+}
+
+func (p Products) find(id int) int {
+	for i, prod := range p.products {
+		if prod.ID == id {
+			return i
+		}
+	}
+
+	return -1
+}
+
+// filter skips deleted products, as the catalog procedures do.
+func (p Products) filter(userID int, keep func(models.Producto) bool) []models.DescProducto {
+	var products []models.DescProducto
+
+	for _, prod := range p.products {
+		if prod.Eliminado != nil || !keep(prod) {
+			continue
+		}
+
+		products = append(products, models.DescProducto{
+			ID:          prod.ID,
+			Nombre:      prod.Nombre,
+			Marca:       prod.Marca,
+			Descripcion: prod.Descripcion,
+			Precio:      prod.Precio,
+			Descuento:   prod.Descuento,
+			Stock:       prod.Stock,
+			Imagen:      prod.Imagen,
+			IsFavorite:  p.favorites[favorite{userID, prod.ID}],
+		})
+	}
+
+	return products
+}
+
+func (f Favorites) Exists(_ context.Context, userID, productID int) (bool, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return f.favorites[favorite{userID, productID}], nil
+}
+
+func (f Favorites) Add(_ context.Context, userID, productID int) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.favorites[favorite{userID, productID}] = true
+
+	return nil
+}
+
+func (f Favorites) Remove(_ context.Context, userID, productID int) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	delete(f.favorites, favorite{userID, productID})
+
+	return nil
+}
+
+func (a Admins) Credentials(_ context.Context, user string) (repository.AdminCredentials, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	found, ok := a.users[user]
+
+	if !ok {
+		return repository.AdminCredentials{}, repository.ErrNotFound
+	}
+
+	adm, ok := a.admins[found.ID]
+
+	if !ok {
+		return repository.AdminCredentials{}, repository.ErrNotFound
+	}
+
+	return repository.AdminCredentials{
+		Admin: models.Admin{
+			ID:    adm.id,
+			User:  found.User,
+			Email: found.Email,
+			Role:  string(adm.role),
+		},
+		PasswordHash: adm.passwordHash,
+	}, nil
+}
+
+func (a Admins) Role(_ context.Context, user, email string) (rbac.Role, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	found, ok := a.users[user]
+
+	if !ok || found.Email != email {
+		return "", repository.ErrNotFound
+	}
+
+	adm, ok := a.admins[found.ID]
+
+	if !ok {
+		return "", repository.ErrNotFound
+	}
+
+	return adm.role, nil
+}
+
+func (a Admins) List(_ context.Context) ([]models.Admin, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	var admins []models.Admin
+
+	for _, u := range a.users {
+		if adm, ok := a.admins[u.ID]; ok {
+			admins = append(admins, models.Admin{
+				ID:    adm.id,
+				User:  u.User,
+				Email: u.Email,
+				Role:  string(adm.role),
+			})
+		}
+	}
+
+	return admins, nil
+}
+
+func (a Admins) Create(_ context.Context, userID int, passwordHash string, role rbac.Role) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.admins[userID] = admin{
+		id:           a.nextID(),
+		passwordHash: passwordHash,
+		role:         role,
+	}
+
+	return nil
+}
+
+func (a Admins) SetRole(_ context.Context, user string, role rbac.Role) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	found, ok := a.users[user]
+
+	if !ok {
+		return repository.ErrNotFound
+	}
+
+	adm, ok := a.admins[found.ID]
+
+	if !ok {
+		return repository.ErrNotFound
+	}
+
+	adm.role = role
+	a.admins[found.ID] = adm
+
+	return nil
+}
+
+func (a Admins) UpdatePasswordHash(_ context.Context, user, oldHash, newHash string) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	adm, id, ok := a.admin(user)
+
+	if !ok || adm.passwordHash != oldHash {
+		return repository.ErrNotFound
+	}
+
+	adm.passwordHash = newHash
+	a.admins[id] = adm
+
+	return nil
+}
+
+func (a Admins) Delete(_ context.Context, user string) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	found, ok := a.users[user]
+
+	if !ok {
+		return repository.ErrNotFound
+	}
+
+	if _, ok := a.admins[found.ID]; !ok {
+		return repository.ErrNotFound
+	}
+
+	delete(a.admins, found.ID)
+
+	return nil
+}
+
+// admin returns the admin of user, callers hold the lock.
+func (a Admins) admin(user string) (admin, int, bool) {
+	found, ok := a.users[user]
+
+	if !ok {
+		return admin{}, 0, false
+	}
+
+	adm, ok := a.admins[found.ID]
+
+	return adm, found.ID, ok
+}
+
+func (a Admins) TOTP(_ context.Context, user string) (repository.TOTPState, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	adm, _, ok := a.admin(user)
+
+	if !ok {
+		return repository.TOTPState{}, repository.ErrNotFound
+	}
+
+	return repository.TOTPState{
+		Secret:   adm.totp,
+		Pending:  adm.totpPending,
+		LastStep: adm.totpStep,
+	}, nil
+}
+
+func (a Admins) SetPendingTOTP(_ context.Context, user, secret string) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	adm, userID, ok := a.admin(user)
+
+	if !ok {
+		return repository.ErrNotFound
+	}
+
+	adm.totpPending = secret
+	a.admins[userID] = adm
+
+	return nil
+}
+
+func (a Admins) EnableTOTP(_ context.Context, user string, step int64, recoveryHashes []string) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	adm, userID, ok := a.admin(user)
+
+	if !ok || adm.totpPending == "" {
+		return repository.ErrNotFound
+	}
+
+	adm.totp = adm.totpPending
+	adm.totpPending = ""
+	adm.totpStep = step
+	adm.recovery = nil
+
+	for _, hash := range recoveryHashes {
+		adm.recovery = append(adm.recovery, recoveryCode{id: a.nextID(), hash: hash})
+	}
+
+	a.admins[userID] = adm
+
+	return nil
+}
+
+func (a Admins) DisableTOTP(_ context.Context, user string) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	adm, userID, ok := a.admin(user)
+
+	if !ok {
+		return repository.ErrNotFound
+	}
+
+	adm.totp = ""
+	adm.totpPending = ""
+	adm.recovery = nil
+	a.admins[userID] = adm
+
+	return nil
+}
+
+func (a Admins) UseTOTPStep(_ context.Context, user string, step int64) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	adm, userID, ok := a.admin(user)
+
+	if !ok || adm.totpStep >= step {
+		return repository.ErrNotFound
+	}
+
+	adm.totpStep = step
+	a.admins[userID] = adm
+
+	return nil
+}
+
+func (a Admins) RecoveryCodes(_ context.Context, user string) ([]repository.RecoveryCode, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	adm, _, ok := a.admin(user)
+
+	if !ok {
+		return nil, repository.ErrNotFound
+	}
+
+	var codes []repository.RecoveryCode
+
+	for _, code := range adm.recovery {
+		if !code.used {
+			codes = append(codes, repository.RecoveryCode{ID: code.id, Hash: code.hash})
+		}
+	}
+
+	return codes, nil
+}
+
+func (a Admins) UseRecoveryCode(_ context.Context, id int) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	for userID, adm := range a.admins {
+		for i, code := range adm.recovery {
+			if code.id == id && !code.used {
+				adm.recovery[i].used = true
+				a.admins[userID] = adm
+
+				return nil
+			}
+		}
+	}
+
+	return repository.ErrNotFound
+}
+
+func (c Credentials) ByUser(_ context.Context, userID int) ([]repository.Credential, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var creds []repository.Credential
+
+	for _, cred := range c.creds {
+		if cred.UserID == userID {
+			creds = append(creds, cred)
+		}
+	}
+
+	return creds, nil
+}
+
+func (c Credentials) Create(_ context.Context, cred repository.Credential) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	cred.ID = c.nextID()
+	c.creds = append(c.creds, cred)
+
+	return nil
+}
+
+func (c Credentials) Touch(_ context.Context, id int, signCount uint32, used time.Time) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for i := range c.creds {
+		if c.creds[i].ID == id {
+			c.creds[i].SignCount = signCount
+			c.creds[i].LastUsed = &used
+
+			return nil
+		}
+	}
+
+	return repository.ErrNotFound
+}
+
+func (c Credentials) Delete(_ context.Context, userID, id int) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for i, cred := range c.creds {
+		if cred.ID == id && cred.UserID == userID {
+			c.creds = append(c.creds[:i], c.creds[i+1:]...)
+
+			return nil
+		}
+	}
+
+	return repository.ErrNotFound
+}
+
+// owner fills in the user and email of the token admin, callers hold the
+// lock. Tokens of removed admins are gone, as with the foreign key.
+func (t Tokens) owner(token repository.APIToken) (repository.APIToken, bool) {
+	for _, u := range t.users {
+		if adm, ok := t.admins[u.ID]; ok && adm.id == token.AdminID {
+			token.User = u.User
+			token.Email = u.Email
+
+			return token, true
+		}
+	}
+
+	return token, false
+}
+
+func (t Tokens) ByHash(_ context.Context, hash string) (repository.APIToken, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for _, token := range t.tokens {
+		if token.Hash == hash {
+			if token, ok := t.owner(token); ok {
+				return token, nil
+			}
+		}
+	}
+
+	return repository.APIToken{}, repository.ErrNotFound
+}
+
+func (t Tokens) List(_ context.Context, adminID int) ([]repository.APIToken, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	var tokens []repository.APIToken
+
+	for _, token := range t.tokens {
+		if token.AdminID != adminID {
+			continue
+		}
+
+		if token, ok := t.owner(token); ok {
+			tokens = append(tokens, token)
+		}
+	}
+
+	return tokens, nil
+}
+
+func (t Tokens) Create(_ context.Context, token repository.APIToken) (int, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	token.ID = t.nextID()
+	t.tokens = append(t.tokens, token)
+
+	return token.ID, nil
+}
+
+func (t Tokens) Touch(_ context.Context, id int, used time.Time) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for i := range t.tokens {
+		if t.tokens[i].ID == id {
+			t.tokens[i].LastUsed = &used
+
+			return nil
+		}
+	}
+
+	return nil
+}
+
+func (t Tokens) Revoke(_ context.Context, adminID, id int, revoked time.Time) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for i := range t.tokens {
+		if t.tokens[i].ID == id && t.tokens[i].AdminID == adminID && t.tokens[i].Revoked == nil {
+			t.tokens[i].Revoked = &revoked
+
+			return nil
+		}
+	}
+
+	return repository.ErrNotFound
+}
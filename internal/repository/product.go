@@ -0,0 +1,175 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/dvher/nibbin.cl_back/pkg/models"
+)
+
+type mysqlProductRepository struct {
+	db *sql.DB
+}
+
+func NewMySQLProductRepository(db *sql.DB) ProductRepository {
+	return &mysqlProductRepository{db: db}
+}
+
+func (r *mysqlProductRepository) List(ctx context.Context, userID int) ([]models.DescProducto, error) {
+	rows, err := r.db.QueryContext(ctx, "CALL DescProductos(?);", userID)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return scanDescProductos(rows)
+}
+
+func (r *mysqlProductRepository) Search(ctx context.Context, userID int, query string) ([]models.DescProducto, error) {
+	rows, err := r.db.QueryContext(ctx, "CALL SearchProductos(?, ?);", userID, query)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return scanDescProductos(rows)
+}
+
+func (r *mysqlProductRepository) ByID(ctx context.Context, userID, id int) (models.DescProducto, error) {
+	rows, err := r.db.QueryContext(ctx, "CALL DescProducto(?, ?);", userID, id)
+
+	if err != nil {
+		return models.DescProducto{}, err
+	}
+
+	products, err := scanDescProductos(rows)
+
+	if err != nil {
+		return models.DescProducto{}, err
+	}
+
+	if len(products) == 0 {
+		return models.DescProducto{}, ErrNotFound
+	}
+
+	return products[0], nil
+}
+
+func (r *mysqlProductRepository) Get(ctx context.Context, id int) (models.Producto, error) {
+	var prod models.Producto
+	var deleted sql.NullTime
+
+	err := r.db.QueryRowContext(
+		ctx,
+		"SELECT id, nombre, marca, descripcion, precio, descuento, stock, imagen, eliminado FROM Producto WHERE id = ?;",
+		id,
+	).Scan(
+		&prod.ID,
+		&prod.Nombre,
+		&prod.Marca,
+		&prod.Descripcion,
+		&prod.Precio,
+		&prod.Descuento,
+		&prod.Stock,
+		&prod.Imagen,
+		&deleted,
+	)
+
+	if errors.Is(err, sql.ErrNoRows) {
+		return models.Producto{}, ErrNotFound
+	}
+
+	if err != nil {
+		return models.Producto{}, err
+	}
+
+	if deleted.Valid {
+		prod.Eliminado = &deleted.Time
+	}
+
+	return prod, nil
+}
+
+func (r *mysqlProductRepository) Create(ctx context.Context, product models.Producto) (int, error) {
+	res, err := r.db.ExecContext(
+		ctx,
+		"INSERT INTO Producto (nombre, marca, descripcion, precio, descuento, stock, imagen) VALUES (?, ?, ?, ?, ?, ?, ?);",
+		product.Nombre, product.Marca, product.Descripcion, product.Precio, product.Descuento, product.Stock, product.Imagen,
+	)
+
+	if err != nil {
+		return 0, err
+	}
+
+	id, err := res.LastInsertId()
+
+	return int(id), err
+}
+
+func (r *mysqlProductRepository) Update(ctx context.Context, product models.Producto) error {
+	res, err := r.db.ExecContext(
+		ctx,
+		"UPDATE Producto SET nombre = ?, marca = ?, descripcion = ?, precio = ?, descuento = ?, stock = ?, imagen = ? "+
+			"WHERE id = ? AND eliminado IS NULL;",
+		product.Nombre, product.Marca, product.Descripcion, product.Precio, product.Descuento, product.Stock, product.Imagen,
+		product.ID,
+	)
+
+	if err != nil {
+		return err
+	}
+
+	return expectRows(res)
+}
+
+func (r *mysqlProductRepository) Delete(ctx context.Context, id int, at time.Time) error {
+	res, err := r.db.ExecContext(ctx, "UPDATE Producto SET eliminado = ? WHERE id = ? AND eliminado IS NULL;", at, id)
+
+	if err != nil {
+		return err
+	}
+
+	return expectRows(res)
+}
+
+func (r *mysqlProductRepository) Restore(ctx context.Context, id int) error {
+	res, err := r.db.ExecContext(ctx, "UPDATE Producto SET eliminado = NULL WHERE id = ? AND eliminado IS NOT NULL;", id)
+
+	if err != nil {
+		return err
+	}
+
+	return expectRows(res)
+}
+
+func scanDescProductos(rows *sql.Rows) ([]models.DescProducto, error) {
+	defer rows.Close()
+
+	var products []models.DescProducto
+
+	for rows.Next() {
+		var prod models.DescProducto
+
+		err := rows.Scan(
+			&prod.ID,
+			&prod.Nombre,
+			&prod.Marca,
+			&prod.Descripcion,
+			&prod.Precio,
+			&prod.Descuento,
+			&prod.Stock,
+			&prod.Imagen,
+			&prod.IsFavorite,
+		)
+
+		if err != nil {
+			return nil, err
+		}
+
+		products = append(products, prod)
+	}
+
+	return products, rows.Err()
+}
@@ -0,0 +1,137 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/dvher/nibbin.cl_back/internal/rbac"
+	"github.com/dvher/nibbin.cl_back/pkg/models"
+)
+
+var ErrNotFound = errors.New("not found")
+
+type UserRepository interface {
+	IDByUsername(ctx context.Context, user string) (int, error)
+	IDByEmail(ctx context.Context, email string) (int, error)
+	UsernameByEmail(ctx context.Context, email string) (string, error)
+	ByID(ctx context.Context, id int) (models.Usuario, error)
+	// LocaleByEmail returns the preferred locale, empty when it was never set.
+	LocaleByEmail(ctx context.Context, email string) (string, error)
+	Create(ctx context.Context, user models.Usuario) error
+	SetLocale(ctx context.Context, user, locale string) error
+	UpdateEmail(ctx context.Context, user, email string) error
+	// Delete removes the user along with its favorites and admin rights.
+	Delete(ctx context.Context, user string) error
+}
+
+type ProductRepository interface {
+	// List and Search flag the products userID has marked as favorite.
+	List(ctx context.Context, userID int) ([]models.DescProducto, error)
+	Search(ctx context.Context, userID int, query string) ([]models.DescProducto, error)
+	ByID(ctx context.Context, userID, id int) (models.DescProducto, error)
+	// Get returns the product as stored, even if it has been deleted.
+	Get(ctx context.Context, id int) (models.Producto, error)
+	Create(ctx context.Context, product models.Producto) (int, error)
+	// Update overwrites a product that hasn't been deleted.
+	Update(ctx context.Context, product models.Producto) error
+	// Delete hides the product from the catalog until it is restored.
+	Delete(ctx context.Context, id int, at time.Time) error
+	Restore(ctx context.Context, id int) error
+}
+
+type FavoriteRepository interface {
+	Exists(ctx context.Context, userID, productID int) (bool, error)
+	Add(ctx context.Context, userID, productID int) error
+	Remove(ctx context.Context, userID, productID int) error
+}
+
+type AdminRepository interface {
+	Credentials(ctx context.Context, user string) (AdminCredentials, error)
+	// Role returns the role of the admin matching both user and email.
+	Role(ctx context.Context, user, email string) (rbac.Role, error)
+	List(ctx context.Context) ([]models.Admin, error)
+	Create(ctx context.Context, userID int, passwordHash string, role rbac.Role) error
+	SetRole(ctx context.Context, user string, role rbac.Role) error
+	// UpdatePasswordHash replaces the hash only if it still is oldHash.
+	UpdatePasswordHash(ctx context.Context, user, oldHash, newHash string) error
+	Delete(ctx context.Context, user string) error
+
+	// TOTP returns the enrolled and pending authenticator secrets.
+	TOTP(ctx context.Context, user string) (TOTPState, error)
+	SetPendingTOTP(ctx context.Context, user, secret string) error
+	// EnableTOTP activates the pending secret, marks step as used and
+	// replaces the recovery codes.
+	EnableTOTP(ctx context.Context, user string, step int64, recoveryHashes []string) error
+	DisableTOTP(ctx context.Context, user string) error
+	// UseTOTPStep records step as used, failing with ErrNotFound if it, or a
+	// later one, already was, so a code can't be replayed.
+	UseTOTPStep(ctx context.Context, user string, step int64) error
+	// RecoveryCodes returns the codes not used yet.
+	RecoveryCodes(ctx context.Context, user string) ([]RecoveryCode, error)
+	UseRecoveryCode(ctx context.Context, id int) error
+}
+
+// CredentialRepository stores the WebAuthn credentials (passkeys) of users.
+type CredentialRepository interface {
+	ByUser(ctx context.Context, userID int) ([]Credential, error)
+	Create(ctx context.Context, cred Credential) error
+	// Touch records a successful login with the credential.
+	Touch(ctx context.Context, id int, signCount uint32, used time.Time) error
+	Delete(ctx context.Context, userID, id int) error
+}
+
+type Credential struct {
+	ID              int        `json:"id"`
+	UserID          int        `json:"-"`
+	CredentialID    []byte     `json:"-"`
+	PublicKey       []byte     `json:"-"`
+	AttestationType string     `json:"-"`
+	Transports      []string   `json:"transports"`
+	AAGUID          []byte     `json:"-"`
+	SignCount       uint32     `json:"-"`
+	Name            string     `json:"name"`
+	Created         time.Time  `json:"created"`
+	LastUsed        *time.Time `json:"lastUsed,omitempty"`
+}
+
+// TokenRepository stores the API tokens admins mint for integrations.
+type TokenRepository interface {
+	// ByHash returns the token with its owner, revoked and expired ones too.
+	ByHash(ctx context.Context, hash string) (APIToken, error)
+	List(ctx context.Context, adminID int) ([]APIToken, error)
+	Create(ctx context.Context, token APIToken) (int, error)
+	Touch(ctx context.Context, id int, used time.Time) error
+	Revoke(ctx context.Context, adminID, id int, revoked time.Time) error
+}
+
+type APIToken struct {
+	ID       int               `json:"id"`
+	AdminID  int               `json:"-"`
+	User     string            `json:"-"`
+	Email    string            `json:"-"`
+	Name     string            `json:"name"`
+	Hash     string            `json:"-"`
+	Scopes   []rbac.Permission `json:"scopes"`
+	Expires  time.Time         `json:"expires"`
+	Created  time.Time         `json:"created"`
+	LastUsed *time.Time        `json:"lastUsed,omitempty"`
+	Revoked  *time.Time        `json:"revoked,omitempty"`
+}
+
+type AdminCredentials struct {
+	models.Admin
+	PasswordHash string
+	TOTPEnabled  bool
+}
+
+type TOTPState struct {
+	Secret   string
+	Pending  string
+	LastStep int64
+}
+
+type RecoveryCode struct {
+	ID   int
+	Hash string
+}
@@ -0,0 +1,134 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/dvher/nibbin.cl_back/internal/rbac"
+)
+
+type mysqlTokenRepository struct {
+	db *sql.DB
+}
+
+func NewMySQLTokenRepository(db *sql.DB) TokenRepository {
+	return &mysqlTokenRepository{db: db}
+}
+
+const tokenColumns = "TokenAPI.id, idAdministrador, usuario, email, nombre, hash, permisos, expira, creado, usado, revocado " +
+	"FROM TokenAPI JOIN Administrador ON Administrador.id = TokenAPI.idAdministrador " +
+	"JOIN Usuario ON Usuario.id = Administrador.idUsuario"
+
+type scanner interface {
+	Scan(dest ...any) error
+}
+
+func scanToken(row scanner) (APIToken, error) {
+	var token APIToken
+	var scopes string
+	var used, revoked sql.NullTime
+
+	err := row.Scan(
+		&token.ID, &token.AdminID, &token.User, &token.Email, &token.Name, &token.Hash,
+		&scopes, &token.Expires, &token.Created, &used, &revoked,
+	)
+
+	if err != nil {
+		return APIToken{}, err
+	}
+
+	for _, scope := range strings.Split(scopes, ",") {
+		if scope != "" {
+			token.Scopes = append(token.Scopes, rbac.Permission(scope))
+		}
+	}
+
+	if used.Valid {
+		token.LastUsed = &used.Time
+	}
+
+	if revoked.Valid {
+		token.Revoked = &revoked.Time
+	}
+
+	return token, nil
+}
+
+func (r *mysqlTokenRepository) ByHash(ctx context.Context, hash string) (APIToken, error) {
+	token, err := scanToken(r.db.QueryRowContext(ctx, "SELECT "+tokenColumns+" WHERE hash = ?;", hash))
+
+	if errors.Is(err, sql.ErrNoRows) {
+		return APIToken{}, ErrNotFound
+	}
+
+	return token, err
+}
+
+func (r *mysqlTokenRepository) List(ctx context.Context, adminID int) ([]APIToken, error) {
+	rows, err := r.db.QueryContext(ctx, "SELECT "+tokenColumns+" WHERE idAdministrador = ? ORDER BY TokenAPI.id;", adminID)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	var tokens []APIToken
+
+	for rows.Next() {
+		token, err := scanToken(rows)
+
+		if err != nil {
+			return nil, err
+		}
+
+		tokens = append(tokens, token)
+	}
+
+	return tokens, rows.Err()
+}
+
+func (r *mysqlTokenRepository) Create(ctx context.Context, token APIToken) (int, error) {
+	scopes := make([]string, 0, len(token.Scopes))
+
+	for _, scope := range token.Scopes {
+		scopes = append(scopes, string(scope))
+	}
+
+	res, err := r.db.ExecContext(
+		ctx,
+		"INSERT INTO TokenAPI (idAdministrador, nombre, hash, permisos, expira, creado) VALUES (?, ?, ?, ?, ?, ?);",
+		token.AdminID, token.Name, token.Hash, strings.Join(scopes, ","), token.Expires, token.Created,
+	)
+
+	if err != nil {
+		return 0, err
+	}
+
+	id, err := res.LastInsertId()
+
+	return int(id), err
+}
+
+func (r *mysqlTokenRepository) Touch(ctx context.Context, id int, used time.Time) error {
+	_, err := r.db.ExecContext(ctx, "UPDATE TokenAPI SET usado = ? WHERE id = ?;", used, id)
+
+	return err
+}
+
+func (r *mysqlTokenRepository) Revoke(ctx context.Context, adminID, id int, revoked time.Time) error {
+	res, err := r.db.ExecContext(
+		ctx,
+		"UPDATE TokenAPI SET revocado = ? WHERE id = ? AND idAdministrador = ? AND revocado IS NULL;",
+		revoked, id, adminID,
+	)
+
+	if err != nil {
+		return err
+	}
+
+	return expectRows(res)
+}
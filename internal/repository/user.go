@@ -0,0 +1,163 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+
+	"github.com/dvher/nibbin.cl_back/pkg/models"
+)
+
+type mysqlUserRepository struct {
+	db *sql.DB
+}
+
+func NewMySQLUserRepository(db *sql.DB) UserRepository {
+	return &mysqlUserRepository{db: db}
+}
+
+func (r *mysqlUserRepository) IDByUsername(ctx context.Context, user string) (int, error) {
+	var id int
+
+	err := r.db.QueryRowContext(ctx, "SELECT id FROM Usuario WHERE usuario = ?;", user).Scan(&id)
+
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, ErrNotFound
+	}
+
+	return id, err
+}
+
+func (r *mysqlUserRepository) IDByEmail(ctx context.Context, email string) (int, error) {
+	var id int
+
+	err := r.db.QueryRowContext(ctx, "SELECT id FROM Usuario WHERE email = ?;", email).Scan(&id)
+
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, ErrNotFound
+	}
+
+	return id, err
+}
+
+func (r *mysqlUserRepository) UsernameByEmail(ctx context.Context, email string) (string, error) {
+	var user string
+
+	err := r.db.QueryRowContext(ctx, "SELECT usuario FROM Usuario WHERE email = ?;", email).Scan(&user)
+
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", ErrNotFound
+	}
+
+	return user, err
+}
+
+func (r *mysqlUserRepository) ByID(ctx context.Context, id int) (models.Usuario, error) {
+	var user models.Usuario
+	var locale sql.NullString
+
+	err := r.db.QueryRowContext(
+		ctx,
+		"SELECT id, nombre, apellido, email, usuario, puntos, direccion, telefono, nacimiento, idioma FROM Usuario WHERE id = ?;",
+		id,
+	).Scan(
+		&user.ID, &user.Nombre, &user.Apellido, &user.Email, &user.User, &user.Puntos,
+		&user.Direccion, &user.Telefono, &user.Nacimiento, &locale,
+	)
+
+	if errors.Is(err, sql.ErrNoRows) {
+		return models.Usuario{}, ErrNotFound
+	}
+
+	user.Idioma = locale.String
+
+	return user, err
+}
+
+func (r *mysqlUserRepository) LocaleByEmail(ctx context.Context, email string) (string, error) {
+	var locale sql.NullString
+
+	err := r.db.QueryRowContext(ctx, "SELECT idioma FROM Usuario WHERE email = ?;", email).Scan(&locale)
+
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", ErrNotFound
+	}
+
+	return locale.String, err
+}
+
+func (r *mysqlUserRepository) Create(ctx context.Context, user models.Usuario) error {
+	_, err := r.db.ExecContext(
+		ctx,
+		"INSERT INTO Usuario(nombre, apellido, email, usuario, puntos, direccion, telefono, nacimiento, idioma) VALUES(?, ?, ?, ?, ?, ?, ?, ?, ?);",
+		user.Nombre, user.Apellido, user.Email, user.User, user.Puntos, user.Direccion, user.Telefono, user.Nacimiento,
+		sql.NullString{String: user.Idioma, Valid: user.Idioma != ""},
+	)
+
+	return err
+}
+
+func (r *mysqlUserRepository) SetLocale(ctx context.Context, user, locale string) error {
+	res, err := r.db.ExecContext(ctx, "UPDATE Usuario SET idioma = ? WHERE usuario = ?;", locale, user)
+
+	if err != nil {
+		return err
+	}
+
+	return expectRows(res)
+}
+
+func (r *mysqlUserRepository) UpdateEmail(ctx context.Context, user, email string) error {
+	res, err := r.db.ExecContext(ctx, "UPDATE Usuario SET email = ? WHERE usuario = ?;", email, user)
+
+	if err != nil {
+		return err
+	}
+
+	return expectRows(res)
+}
+
+func (r *mysqlUserRepository) Delete(ctx context.Context, user string) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+
+	if err != nil {
+		return err
+	}
+
+	defer tx.Rollback()
+
+	for _, query := range []string{
+		"DELETE Favorito FROM Favorito JOIN Usuario ON Usuario.id = Favorito.idUsuario WHERE Usuario.usuario = ?;",
+		"DELETE Administrador FROM Administrador JOIN Usuario ON Usuario.id = Administrador.idUsuario WHERE Usuario.usuario = ?;",
+	} {
+		if _, err := tx.ExecContext(ctx, query, user); err != nil {
+			return err
+		}
+	}
+
+	res, err := tx.ExecContext(ctx, "DELETE FROM Usuario WHERE usuario = ?;", user)
+
+	if err != nil {
+		return err
+	}
+
+	if err := expectRows(res); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func expectRows(res sql.Result) error {
+	n, err := res.RowsAffected()
+
+	if err != nil {
+		return err
+	}
+
+	if n == 0 {
+		return ErrNotFound
+	}
+
+	return nil
+}
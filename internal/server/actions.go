@@ -0,0 +1,251 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/dvher/nibbin.cl_back/internal/middleware"
+	"github.com/dvher/nibbin.cl_back/internal/rbac"
+	"github.com/gin-contrib/sessions"
+	"github.com/gin-gonic/gin"
+	gsessions "github.com/gorilla/sessions"
+)
+
+type ActionKind string
+
+const (
+	ActionLogin         ActionKind = "login"
+	ActionLoginAdmin    ActionKind = "loginAdmin"
+	ActionRegisterAdmin ActionKind = "registerAdmin"
+	ActionChangeEmail   ActionKind = "changeEmail"
+)
+
+var ErrUnknownAction = errors.New("unknown action")
+
+// PendingAction is the operation an OTP authorizes. It is completed by
+// the handler registered for its kind once the code has been verified.
+type PendingAction interface {
+	Kind() ActionKind
+}
+
+// LoginAction has an empty User when the email is not registered.
+type LoginAction struct {
+	User string `json:"user"`
+}
+
+type LoginAdminAction struct {
+	User string `json:"user"`
+}
+
+type RegisterAdminAction struct {
+	Email        string    `json:"email"`
+	PasswordHash string    `json:"passwordHash"`
+	UserID       int       `json:"userId"`
+	Role         rbac.Role `json:"role"`
+}
+
+type ChangeEmailAction struct {
+	User     string `json:"user"`
+	NewEmail string `json:"newEmail"`
+}
+
+func (LoginAction) Kind() ActionKind         { return ActionLogin }
+func (LoginAdminAction) Kind() ActionKind    { return ActionLoginAdmin }
+func (RegisterAdminAction) Kind() ActionKind { return ActionRegisterAdmin }
+func (ChangeEmailAction) Kind() ActionKind   { return ActionChangeEmail }
+
+type actionCompleter func(c *gin.Context, email string, action PendingAction)
+
+// actionTypes maps every kind to a decoder for its payload, so stores can
+// persist pending actions.
+var actionTypes = map[ActionKind]func(payload []byte) (PendingAction, error){
+	ActionLogin:         decodeAs[LoginAction],
+	ActionLoginAdmin:    decodeAs[LoginAdminAction],
+	ActionRegisterAdmin: decodeAs[RegisterAdminAction],
+	ActionChangeEmail:   decodeAs[ChangeEmailAction],
+}
+
+func decodeAs[T PendingAction](payload []byte) (PendingAction, error) {
+	var action T
+
+	if err := json.Unmarshal(payload, &action); err != nil {
+		return nil, err
+	}
+
+	return action, nil
+}
+
+func completeWith[T PendingAction](complete func(c *gin.Context, email string, action T)) actionCompleter {
+	return func(c *gin.Context, email string, action PendingAction) {
+		complete(c, email, action.(T))
+	}
+}
+
+func encodeAction(action PendingAction) (ActionKind, []byte, error) {
+	if _, ok := actionTypes[action.Kind()]; !ok {
+		return "", nil, fmt.Errorf("%w: %s", ErrUnknownAction, action.Kind())
+	}
+
+	payload, err := json.Marshal(action)
+
+	if err != nil {
+		return "", nil, err
+	}
+
+	return action.Kind(), payload, nil
+}
+
+func decodeAction(kind ActionKind, payload []byte) (PendingAction, error) {
+	decode, ok := actionTypes[kind]
+
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownAction, kind)
+	}
+
+	return decode(payload)
+}
+
+func (h *handlers) completeAction(c *gin.Context, email string, action PendingAction) {
+	complete, ok := h.actions[action.Kind()]
+
+	if !ok {
+		log.Println("Invalid action", action.Kind())
+
+		c.JSON(http.StatusBadRequest, gin.H{
+			"message": "Invalid action",
+		})
+		return
+	}
+
+	complete(c, email, action)
+}
+
+func (h *handlers) completeLogin(c *gin.Context, email string, action LoginAction) {
+
+	if action.User != "" {
+		if err := h.setUserSession(c, action.User, email); err != nil {
+			log.Println("Error saving session", err)
+
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"message": "Error saving session",
+			})
+			return
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":    "login verified",
+		"registered": action.User != "",
+	})
+}
+
+func (h *handlers) completeLoginAdmin(c *gin.Context, email string, action LoginAdminAction) {
+
+	if err := h.regenerateSession(c); err != nil {
+		log.Println("Error regenerating session", err)
+
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Error saving session",
+		})
+		return
+	}
+
+	sess := sessions.Default(c)
+
+	opts := h.sessionOptions
+	opts.MaxAge = int(middleware.AdminSessionTTL / time.Second)
+
+	sess.Options(opts)
+	sess.Set("user", action.User)
+	sess.Set("email", email)
+	sess.Set("role", middleware.RoleAdmin)
+	sess.Set("authAt", time.Now().Unix())
+
+	if err := sess.Save(); err != nil {
+		log.Println("Error saving session", err)
+
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Error saving session",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":   "loginAdmin verified",
+		"expiresIn": opts.MaxAge,
+	})
+}
+
+func (h *handlers) completeRegisterAdmin(c *gin.Context, email string, action RegisterAdminAction) {
+
+	err := h.Admins.Create(c.Request.Context(), action.UserID, action.PasswordHash, action.Role)
+
+	if err != nil {
+		log.Println("Error inserting admin", err)
+
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Error inserting admin",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Admin registered",
+	})
+}
+
+func (h *handlers) completeChangeEmail(c *gin.Context, email string, action ChangeEmailAction) {
+
+	err := h.Users.UpdateEmail(c.Request.Context(), action.User, action.NewEmail)
+
+	if err != nil {
+		log.Println("Error updating email", err)
+
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Error updating email",
+		})
+		return
+	}
+
+	sess := sessions.Default(c)
+
+	if sess.Get("user") == action.User {
+		sess.Set("email", action.NewEmail)
+		if err := sess.Save(); err != nil {
+			log.Println("Error saving session", err)
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Email changed",
+	})
+}
+
+func (h *handlers) setUserSession(c *gin.Context, user, email string) error {
+	if err := h.regenerateSession(c); err != nil {
+		return err
+	}
+
+	sess := sessions.Default(c)
+
+	sess.Set("user", user)
+	sess.Set("email", email)
+
+	return sess.Save()
+}
+
+// regenerateSession gives the current session a new ID before it is saved
+// with more privileges, keeping its values.
+func (h *handlers) regenerateSession(c *gin.Context) error {
+	sess, ok := sessions.Default(c).(interface{ Session() *gsessions.Session })
+
+	if !ok {
+		return nil
+	}
+
+	return h.Sessions.Regenerate(sess.Session())
+}
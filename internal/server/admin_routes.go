@@ -1,17 +1,24 @@
 package server
 
 import (
+	"context"
+	"errors"
 	"log"
 	"net/http"
 	"strconv"
+	"time"
 
-	db "github.com/dvher/nibbin.cl_back/internal/database"
-	"github.com/dvher/nibbin.cl_back/pkg/argon2"
+	"github.com/dvher/nibbin.cl_back/internal/middleware"
+	"github.com/dvher/nibbin.cl_back/internal/outbox"
+	"github.com/dvher/nibbin.cl_back/internal/rbac"
+	"github.com/dvher/nibbin.cl_back/internal/repository"
 	"github.com/dvher/nibbin.cl_back/pkg/models"
+	"github.com/dvher/nibbin.cl_back/templates"
 	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
 )
 
-func loginAdmin(c *gin.Context) {
+func (h *handlers) loginAdmin(c *gin.Context) {
 	var data models.LoginAdminRequest
 
 	if err := c.BindJSON(&data); err != nil {
@@ -32,28 +39,23 @@ func loginAdmin(c *gin.Context) {
 		return
 	}
 
-	stmt, err := db.DB.Prepare(
-		"SELECT Administrador.id, idUsuario, contrasena, usuario FROM Administrador, Usuario WHERE Usuario.usuario = ?;",
-	)
+	admin, err := h.Admins.Credentials(c.Request.Context(), data.User)
 
-	if err != nil {
-		log.Println("Error preparing statement", err)
+	if errors.Is(err, repository.ErrNotFound) {
+		log.Println("Admin not found")
 
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"message": "Error preparing statement",
+		// Take as long as checking a real password, so the response time
+		// doesn't tell which admins exist.
+		if _, _, err := h.passwords.ComparePasswordHash(c.Request.Context(), data.Password, h.decoyHash); err != nil {
+			log.Println("Error comparing decoy password", err)
+		}
+
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"message": "Invalid password",
 		})
 		return
 	}
 
-	defer stmt.Close()
-
-	var id int
-	var idUsuario int
-	var hashedPassword string
-	var usuario string
-
-	err = stmt.QueryRow(data.User).Scan(&id, &idUsuario, &hashedPassword, &usuario)
-
 	if err != nil {
 		log.Println("Error querying user", err)
 
@@ -63,64 +65,178 @@ func loginAdmin(c *gin.Context) {
 		return
 	}
 
-	err = stmt.Close()
+	isValid, err := h.checkAdminPassword(c, admin.User, admin.PasswordHash, data.Password)
+
+	if errors.Is(err, context.Canceled) {
+		log.Println("Login abandoned while waiting to check the password")
+		return
+	}
 
 	if err != nil {
-		log.Println("Error closing statement", err)
+		log.Println("Error comparing password", err)
 
 		c.JSON(http.StatusInternalServerError, gin.H{
-			"message": "Error closing statement",
+			"message": "Error comparing password",
 		})
 		return
 	}
 
-	stmt, err = db.DB.Prepare("SELECT email FROM Usuario WHERE id = ?;")
+	if !isValid {
+		log.Println("Invalid password")
+
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"message": "Invalid password",
+		})
+		return
+	}
+
+	if admin.TOTPEnabled {
+		h.loginAdminTOTP(c, admin.Admin, data)
+		return
+	}
+
+	if !h.allowOTPIssue(c, admin.Email) {
+		return
+	}
+
+	err = h.sendOTPEmail(c.Request.Context(), []string{admin.Email}, h.locale(c, admin.Email), LoginAdminAction{User: admin.User})
 
 	if err != nil {
-		log.Println("Error preparing statement", err)
+		log.Println("Error sending email", err)
 
 		c.JSON(http.StatusInternalServerError, gin.H{
-			"message": "Error preparing statement",
+			"message": "Error sending email",
 		})
 		return
 	}
 
-	defer stmt.Close()
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Login successful",
+	})
+}
+
+// checkAdminPassword verifies password against the stored hash, whatever
+// its format, and replaces hashes from imported accounts, with older
+// argon2 parameters or a retired pepper. Failing to replace it doesn't
+// fail the check.
+func (h *handlers) checkAdminPassword(c *gin.Context, user, stored, password string) (bool, error) {
+	ok, upgrade, err := h.hashes.Verify(c.Request.Context(), password, stored)
 
-	var email string
+	if err != nil || !ok || !upgrade {
+		return ok, err
+	}
 
-	err = stmt.QueryRow(idUsuario).Scan(&email)
+	hash, err := h.passwords.Hash(c.Request.Context(), []byte(password), h.passwordConfig())
 
 	if err != nil {
-		log.Println("Error querying user", err)
+		log.Println("Error hashing password", err)
+		return true, nil
+	}
 
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"message": "Error querying user",
+	err = h.Admins.UpdatePasswordHash(c.Request.Context(), user, stored, hash.String())
+
+	if err != nil && !errors.Is(err, repository.ErrNotFound) {
+		log.Println("Error updating password hash", err)
+	}
+
+	return true, nil
+}
+
+func (h *handlers) registerAdmin(c *gin.Context) {
+	var data models.RegisterAdminRequest
+
+	if err := c.BindJSON(&data); err != nil {
+		log.Println("Error binding json", err)
+
+		c.JSON(http.StatusBadRequest, gin.H{
+			"message": "Error binding json",
+		})
+		return
+	}
+
+	if data.Email == "" {
+		log.Println("Email not provided")
+
+		c.JSON(http.StatusBadRequest, gin.H{
+			"message": "Email not provided",
+		})
+		return
+	}
+
+	if !validateEmail(data.Email) {
+		log.Println("Invalid email")
+
+		c.JSON(http.StatusBadRequest, gin.H{
+			"message": "Invalid email",
 		})
 		return
 	}
 
-	isValid, err := argon2.ComparePasswordHash(data.Password, hashedPassword)
+	if data.Password == "" {
+		log.Println("Password not provided")
+
+		c.JSON(http.StatusBadRequest, gin.H{
+			"message": "Password not provided",
+		})
+		return
+	}
+
+	role := rbac.DefaultRole
+
+	if data.Role != "" {
+		role = rbac.Role(data.Role)
+	}
+
+	if !role.Valid() {
+		log.Println("Invalid role")
+
+		c.JSON(http.StatusBadRequest, gin.H{
+			"message": "Invalid role",
+		})
+		return
+	}
+
+	id, err := h.Users.IDByEmail(c.Request.Context(), data.Email)
+
+	if errors.Is(err, repository.ErrNotFound) {
+		log.Println("User not found")
+
+		c.JSON(http.StatusNotFound, gin.H{
+			"message": "User not found",
+		})
+		return
+	}
 
 	if err != nil {
-		log.Println("Error comparing password", err)
+		log.Println("Error querying user", err)
 
 		c.JSON(http.StatusInternalServerError, gin.H{
-			"message": "Error comparing password",
+			"message": "Error querying user",
 		})
 		return
 	}
 
-	if !isValid {
-		log.Println("Invalid password")
+	if !h.allowOTPIssue(c, data.Email) {
+		return
+	}
 
-		c.JSON(http.StatusUnauthorized, gin.H{
-			"message": "Invalid password",
+	hashedPassword, err := h.passwords.Hash(c.Request.Context(), []byte(data.Password), h.passwordConfig())
+
+	if err != nil {
+		log.Println("Error hashing password", err)
+
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Error hashing password",
 		})
 		return
 	}
 
-	err = sendOTPEmail([]string{email}, "loginAdmin "+usuario)
+	err = h.sendOTPEmail(c.Request.Context(), []string{data.Email}, templates.DefaultLocale, RegisterAdminAction{
+		Email:        data.Email,
+		PasswordHash: hashedPassword.String(),
+		UserID:       id,
+		Role:         role,
+	})
 
 	if err != nil {
 		log.Println("Error sending email", err)
@@ -132,12 +248,13 @@ func loginAdmin(c *gin.Context) {
 	}
 
 	c.JSON(http.StatusOK, gin.H{
-		"message": "Login successful",
+		"message": "Registration successful",
 	})
+
 }
 
-func registerAdmin(c *gin.Context) {
-	var data models.RegisterAdminRequest
+func (h *handlers) insertProduct(c *gin.Context) {
+	var data models.Producto
 
 	if err := c.BindJSON(&data); err != nil {
 		log.Println("Error binding json", err)
@@ -148,80 +265,314 @@ func registerAdmin(c *gin.Context) {
 		return
 	}
 
-	if data.Email == "" {
-		log.Println("Email not provided")
+	id, err := h.Products.Create(c.Request.Context(), data)
+
+	if err != nil {
+		log.Println("Error inserting product", err)
+
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Error inserting product",
+		})
+		return
+	}
+
+	h.respondProduct(c, id, "Product inserted successfully")
+
+}
+
+func (h *handlers) updateProduct(c *gin.Context) {
+	id, ok := productIDParam(c)
+
+	if !ok {
+		return
+	}
+
+	var data models.Producto
+
+	if err := c.BindJSON(&data); err != nil {
+		log.Println("Error binding json", err)
 
 		c.JSON(http.StatusBadRequest, gin.H{
-			"message": "Email not provided",
+			"message": "Error binding json",
 		})
 		return
 	}
 
-	if !validateEmail(data.Email) {
-		log.Println("Invalid email")
+	data.ID = id
+
+	h.saveProduct(c, data)
+}
+
+func (h *handlers) patchProduct(c *gin.Context) {
+	id, ok := productIDParam(c)
+
+	if !ok {
+		return
+	}
+
+	var data models.UpdateProductRequest
+
+	if err := c.BindJSON(&data); err != nil {
+		log.Println("Error binding json", err)
 
 		c.JSON(http.StatusBadRequest, gin.H{
-			"message": "Invalid email",
+			"message": "Error binding json",
 		})
 		return
 	}
 
-	if data.Password == "" {
-		log.Println("Password not provided")
+	product, err := h.Products.Get(c.Request.Context(), id)
+
+	if errors.Is(err, repository.ErrNotFound) || err == nil && product.Eliminado != nil {
+		log.Println("Product not found")
+
+		c.JSON(http.StatusNotFound, gin.H{
+			"message": "Product not found",
+		})
+		return
+	}
+
+	if err != nil {
+		log.Println("Error querying product", err)
+
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Error querying product",
+		})
+		return
+	}
+
+	if data.Nombre != nil {
+		product.Nombre = *data.Nombre
+	}
+
+	if data.Marca != nil {
+		product.Marca = *data.Marca
+	}
+
+	if data.Descripcion != nil {
+		product.Descripcion = *data.Descripcion
+	}
+
+	if data.Precio != nil {
+		product.Precio = *data.Precio
+	}
+
+	if data.Descuento != nil {
+		product.Descuento = *data.Descuento
+	}
+
+	if data.Stock != nil {
+		product.Stock = *data.Stock
+	}
+
+	if data.Imagen != nil {
+		product.Imagen = *data.Imagen
+	}
+
+	// The merged product has to pass the same checks as a full update.
+	if err := binding.Validator.ValidateStruct(&product); err != nil {
+		log.Println("Invalid product", err)
 
 		c.JSON(http.StatusBadRequest, gin.H{
-			"message": "Password not provided",
+			"message": "Invalid product",
 		})
 		return
 	}
 
-	stmt, err := db.DB.Prepare("SELECT id FROM Usuario WHERE email = ?;")
+	h.saveProduct(c, product)
+}
+
+func (h *handlers) saveProduct(c *gin.Context, product models.Producto) {
+	err := h.Products.Update(c.Request.Context(), product)
+
+	if errors.Is(err, repository.ErrNotFound) {
+		log.Println("Product not found")
+
+		c.JSON(http.StatusNotFound, gin.H{
+			"message": "Product not found",
+		})
+		return
+	}
 
 	if err != nil {
-		log.Println("Error preparing statement", err)
+		log.Println("Error updating product", err)
 
 		c.JSON(http.StatusInternalServerError, gin.H{
-			"message": "Error preparing statement",
+			"message": "Error updating product",
 		})
 		return
 	}
 
-	defer stmt.Close()
+	h.respondProduct(c, product.ID, "Product updated successfully")
+}
+
+func (h *handlers) deleteProduct(c *gin.Context) {
+	id, ok := productIDParam(c)
+
+	if !ok {
+		return
+	}
 
-	var id int
+	err := h.Products.Delete(c.Request.Context(), id, time.Now().UTC())
 
-	err = stmt.QueryRow(data.Email).Scan(&id)
+	if errors.Is(err, repository.ErrNotFound) {
+		log.Println("Product not found")
+
+		c.JSON(http.StatusNotFound, gin.H{
+			"message": "Product not found",
+		})
+		return
+	}
 
 	if err != nil {
-		log.Println("Error querying user", err)
+		log.Println("Error deleting product", err)
 
 		c.JSON(http.StatusInternalServerError, gin.H{
-			"message": "Error querying user",
+			"message": "Error deleting product",
 		})
 		return
 	}
 
-	hashedPassword, err := argon2.GenerateHash([]byte(data.Password), argon2.DefaultConfig())
+	h.respondProduct(c, id, "Product deleted successfully")
+}
+
+func (h *handlers) restoreProduct(c *gin.Context) {
+	id, ok := productIDParam(c)
+
+	if !ok {
+		return
+	}
+
+	err := h.Products.Restore(c.Request.Context(), id)
+
+	if errors.Is(err, repository.ErrNotFound) {
+		log.Println("Deleted product not found")
+
+		c.JSON(http.StatusNotFound, gin.H{
+			"message": "Deleted product not found",
+		})
+		return
+	}
 
 	if err != nil {
-		log.Println("Error hashing password", err)
+		log.Println("Error restoring product", err)
 
 		c.JSON(http.StatusInternalServerError, gin.H{
-			"message": "Error hashing password",
+			"message": "Error restoring product",
 		})
 		return
 	}
 
-	sendOTPEmail([]string{data.Email}, "registerAdmin "+data.Email+" "+hashedPassword.String()+" "+strconv.Itoa(id))
+	h.respondProduct(c, id, "Product restored successfully")
+}
+
+// respondProduct answers with the product as it was stored, so clients see
+// the ID and any value the database filled in.
+func (h *handlers) respondProduct(c *gin.Context, id int, message string) {
+	product, err := h.Products.Get(c.Request.Context(), id)
+
+	if err != nil {
+		log.Println("Error querying product", err)
+
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Error querying product",
+		})
+		return
+	}
 
 	c.JSON(http.StatusOK, gin.H{
-		"message": "Registration successful",
+		"message": message,
+		"product": product,
 	})
+}
+
+func (h *handlers) demoteAdmin(c *gin.Context) {
+
+	user := c.Param("user")
+
+	if user == "" {
+		log.Println("User not provided")
+
+		c.JSON(http.StatusBadRequest, gin.H{
+			"message": "User not provided",
+		})
+		return
+	}
+
+	if user == c.GetString(middleware.UserKey) {
+		log.Println("Admin tried to demote itself")
+
+		c.JSON(http.StatusBadRequest, gin.H{
+			"message": "Cannot remove yourself",
+		})
+		return
+	}
+
+	err := h.Admins.Delete(c.Request.Context(), user)
+
+	if errors.Is(err, repository.ErrNotFound) {
+		log.Println("Admin not found")
+
+		c.JSON(http.StatusNotFound, gin.H{
+			"message": "Admin not found",
+		})
+		return
+	}
+
+	if err != nil {
+		log.Println("Error deleting admin", err)
+
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Error deleting admin",
+		})
+		return
+	}
 
+	if err := h.Sessions.RevokeAll(user); err != nil {
+		log.Println("Error revoking sessions", err)
+
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Error revoking sessions",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Admin removed",
+	})
 }
 
-func insertProduct(c *gin.Context) {
-	var data models.Producto
+func listRoles(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Roles retrieved",
+		"roles":   rbac.Roles(),
+	})
+}
+
+func (h *handlers) listAdmins(c *gin.Context) {
+
+	admins, err := h.Admins.List(c.Request.Context())
+
+	if err != nil {
+		log.Println("Error querying admins", err)
+
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Error querying admins",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Admins retrieved",
+		"admins":  admins,
+	})
+}
+
+func (h *handlers) assignRole(c *gin.Context) {
+
+	user := c.Param("user")
+
+	var data models.AssignRoleRequest
 
 	if err := c.BindJSON(&data); err != nil {
 		log.Println("Error binding json", err)
@@ -232,34 +583,118 @@ func insertProduct(c *gin.Context) {
 		return
 	}
 
-	stmt, err := db.DB.Prepare(
-		"INSERT INTO Producto (nombre, descripcion, descuento, stock, imagen) VALUES (?, ?, ?, ?, ?, ?);",
-	)
+	role := rbac.Role(data.Role)
+
+	if !role.Valid() {
+		log.Println("Invalid role")
+
+		c.JSON(http.StatusBadRequest, gin.H{
+			"message": "Invalid role",
+		})
+		return
+	}
+
+	if user == c.GetString(middleware.UserKey) {
+		log.Println("Admin tried to change its own role")
+
+		c.JSON(http.StatusBadRequest, gin.H{
+			"message": "Cannot change your own role",
+		})
+		return
+	}
+
+	err := h.Admins.SetRole(c.Request.Context(), user, role)
+
+	if errors.Is(err, repository.ErrNotFound) {
+		log.Println("Admin not found")
+
+		c.JSON(http.StatusNotFound, gin.H{
+			"message": "Admin not found",
+		})
+		return
+	}
 
 	if err != nil {
-		log.Println("Error preparing statement", err)
+		log.Println("Error updating role", err)
 
 		c.JSON(http.StatusInternalServerError, gin.H{
-			"message": "Error preparing statement",
+			"message": "Error updating role",
 		})
 		return
 	}
 
-	defer stmt.Close()
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Role assigned",
+		"role":    role,
+	})
+}
+
+func (h *handlers) listOutbox(c *gin.Context) {
+
+	state := outbox.State(c.Query("state"))
 
-	_, err = stmt.Exec(data.Nombre, data.Descripcion, data.Descuento, data.Stock, data.Imagen)
+	switch state {
+	case "", outbox.StatePending, outbox.StateSent, outbox.StateDead:
+	default:
+		log.Println("Invalid outbox state", state)
+
+		c.JSON(http.StatusBadRequest, gin.H{
+			"message": "Invalid state",
+		})
+		return
+	}
+
+	emails, err := h.Outbox.List(c.Request.Context(), state)
 
 	if err != nil {
-		log.Println("Error inserting product", err)
+		log.Println("Error querying outbox", err)
 
 		c.JSON(http.StatusInternalServerError, gin.H{
-			"message": "Error inserting product",
+			"message": "Error querying outbox",
 		})
 		return
 	}
 
 	c.JSON(http.StatusOK, gin.H{
-		"message": "Product inserted successfully",
+		"message": "Emails retrieved",
+		"emails":  emails,
 	})
+}
+
+func (h *handlers) retryEmail(c *gin.Context) {
+
+	id, err := strconv.Atoi(c.Param("id"))
+
+	if err != nil {
+		log.Println("Invalid email ID", err)
+
+		c.JSON(http.StatusBadRequest, gin.H{
+			"message": "Invalid email ID",
+		})
+		return
+	}
 
+	err = h.Outbox.Retry(c.Request.Context(), id)
+
+	if errors.Is(err, outbox.ErrNotFound) {
+		log.Println("Failed email not found")
+
+		c.JSON(http.StatusNotFound, gin.H{
+			"message": "Failed email not found",
+		})
+		return
+	}
+
+	if err != nil {
+		log.Println("Error retrying email", err)
+
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Error retrying email",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Email queued",
+	})
 }
@@ -0,0 +1,242 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dvher/nibbin.cl_back/internal/config"
+	"github.com/dvher/nibbin.cl_back/internal/middleware"
+	"github.com/dvher/nibbin.cl_back/internal/rbac"
+	"github.com/dvher/nibbin.cl_back/internal/repository"
+	"github.com/dvher/nibbin.cl_back/internal/repository/memory"
+	"github.com/dvher/nibbin.cl_back/pkg/argon2"
+	"github.com/dvher/nibbin.cl_back/pkg/models"
+	"github.com/gin-gonic/gin"
+	"github.com/google/go-cmp/cmp"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestCheckAdminPassword(t *testing.T) {
+	ctx := context.Background()
+
+	weak := argon2.DefaultConfig()
+	weak.Memory = 1024
+	weak.Time = 1
+
+	old, err := argon2.GenerateHash([]byte("password"), weak)
+
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	imported, err := bcrypt.GenerateFromPassword([]byte("password"), bcrypt.MinCost)
+
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	tests := []struct {
+		name string
+		hash string
+	}{
+		{"weak argon2", old.String()},
+		{"legacy format", strings.TrimPrefix(old.String(), "$")},
+		{"imported bcrypt", string(imported)},
+	}
+
+	for _, tt := range tests {
+		store := memory.New()
+		h, err := newHandlers(config.Default(), Deps{Users: store.Users(), Admins: store.Admins()})
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if err := store.Users().Create(ctx, models.Usuario{User: "admin", Email: "admin@nibbin.cl"}); err != nil {
+			t.Error(err)
+			return
+		}
+
+		id, _ := store.Users().IDByUsername(ctx, "admin")
+
+		if err := store.Admins().Create(ctx, id, tt.hash, rbac.RoleSuperAdmin); err != nil {
+			t.Error(err)
+			return
+		}
+
+		c, _ := gin.CreateTestContext(httptest.NewRecorder())
+		c.Request = httptest.NewRequest("POST", "/admin/login", nil)
+
+		if ok, err := h.checkAdminPassword(c, "admin", tt.hash, "wrong"); err != nil || ok {
+			t.Errorf("%s: wrong password accepted, error %v\n", tt.name, err)
+			continue
+		}
+
+		if ok, err := h.checkAdminPassword(c, "admin", tt.hash, "password"); err != nil || !ok {
+			t.Errorf("%s: password rejected, error %v\n", tt.name, err)
+			continue
+		}
+
+		admin, err := store.Admins().Credentials(ctx, "admin")
+
+		if err != nil {
+			t.Error(err)
+			return
+		}
+
+		if argon2.NeedsRehash(admin.PasswordHash, h.passwordConfig()) {
+			t.Errorf("%s: got hash %s when should be upgraded\n", tt.name, admin.PasswordHash)
+			continue
+		}
+
+		match, err := argon2.ComparePasswordHash("password", admin.PasswordHash)
+
+		if err != nil || !match {
+			t.Errorf("%s: upgraded hash doesn't match the password: %v\n", tt.name, err)
+		}
+	}
+}
+
+func TestAdminProductLifecycle(t *testing.T) {
+	r, store := newTestServer(t)
+
+	ctx := context.Background()
+
+	if err := store.Users().Create(ctx, models.Usuario{User: "catalog", Email: "catalog@nibbin.cl"}); err != nil {
+		t.Error(err)
+		return
+	}
+
+	userID, _ := store.Users().IDByUsername(ctx, "catalog")
+
+	if err := store.Admins().Create(ctx, userID, "hash", rbac.RoleCatalogManager); err != nil {
+		t.Error(err)
+		return
+	}
+
+	admin, _ := store.Admins().Credentials(ctx, "catalog")
+
+	_, err := store.Tokens().Create(ctx, repository.APIToken{
+		AdminID: admin.ID,
+		Name:    "catalog",
+		Hash:    middleware.HashToken("nbn_catalog"),
+		Scopes:  []rbac.Permission{rbac.PermProductWrite},
+		Expires: time.Now().Add(time.Hour),
+		Created: time.Now(),
+	})
+
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	do := func(method, path, body string) (int, models.Producto) {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "Bearer nbn_catalog")
+
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+
+		var res struct {
+			Product models.Producto `json:"product"`
+		}
+
+		json.Unmarshal(w.Body.Bytes(), &res)
+
+		return w.Code, res.Product
+	}
+
+	code, product := do(http.MethodPost, "/admin/product",
+		`{"nombre": "Shampoo", "marca": "Nibbin", "descripcion": "300 ml", "precio": 5990, "descuento": 0.1, "stock": 5, "imagen": "shampoo.png"}`)
+
+	id := strconv.Itoa(product.ID)
+
+	want := models.Producto{
+		ID:          product.ID,
+		Nombre:      "Shampoo",
+		Marca:       "Nibbin",
+		Descripcion: "300 ml",
+		Precio:      5990,
+		Descuento:   0.1,
+		Stock:       5,
+		Imagen:      "shampoo.png",
+	}
+
+	if code != http.StatusOK || !cmp.Equal(product, want) {
+		t.Errorf("Got %d %v when creating when should be %d %v\n", code, product, http.StatusOK, want)
+		return
+	}
+
+	code, product = do(http.MethodPatch, "/admin/product/"+id, `{"stock": 0, "descuento": 0}`)
+	want.Stock = 0
+	want.Descuento = 0
+
+	if code != http.StatusOK || !cmp.Equal(product, want) {
+		t.Errorf("Got %d %v when patching when should be %d %v\n", code, product, http.StatusOK, want)
+		return
+	}
+
+	if code, _ := do(http.MethodPatch, "/admin/product/"+id, `{"precio": -1}`); code != http.StatusBadRequest {
+		t.Errorf("Got status %d for a negative price when should be %d\n", code, http.StatusBadRequest)
+	}
+
+	for _, discount := range []string{"-0.1", "1.5"} {
+		if code, _ := do(http.MethodPatch, "/admin/product/"+id, `{"descuento": `+discount+`}`); code != http.StatusBadRequest {
+			t.Errorf("Got status %d for a discount of %s when should be %d\n", code, discount, http.StatusBadRequest)
+		}
+	}
+
+	code, product = do(http.MethodPut, "/admin/product/"+id,
+		`{"nombre": "Shampoo sólido", "marca": "Nibbin", "descripcion": "80 g", "precio": 6990, "stock": 3, "imagen": "barra.png"}`)
+	want = models.Producto{
+		ID:          product.ID,
+		Nombre:      "Shampoo sólido",
+		Marca:       "Nibbin",
+		Descripcion: "80 g",
+		Precio:      6990,
+		Stock:       3,
+		Imagen:      "barra.png",
+	}
+
+	if code != http.StatusOK || !cmp.Equal(product, want) {
+		t.Errorf("Got %d %v when updating when should be %d %v\n", code, product, http.StatusOK, want)
+		return
+	}
+
+	code, product = do(http.MethodDelete, "/admin/product/"+id, "")
+
+	if code != http.StatusOK || product.Eliminado == nil {
+		t.Errorf("Got %d %v when deleting when should be %d and a deletion time\n", code, product, http.StatusOK)
+		return
+	}
+
+	steps := []struct {
+		name   string
+		method string
+		path   string
+		want   int
+	}{
+		{"catalog hides deleted", http.MethodGet, "/product/" + id, http.StatusNotFound},
+		{"patch deleted", http.MethodPatch, "/admin/product/" + id, http.StatusNotFound},
+		{"delete twice", http.MethodDelete, "/admin/product/" + id, http.StatusNotFound},
+		{"restore", http.MethodPost, "/admin/product/" + id + "/restore", http.StatusOK},
+		{"restore twice", http.MethodPost, "/admin/product/" + id + "/restore", http.StatusNotFound},
+		{"catalog shows restored", http.MethodGet, "/product/" + id, http.StatusOK},
+		{"unknown", http.MethodDelete, "/admin/product/" + id + "0", http.StatusNotFound},
+	}
+
+	for _, s := range steps {
+		if code, _ := do(s.method, s.path, `{"stock": 1}`); code != s.want {
+			t.Errorf("%s: got status %d when should be %d\n", s.name, code, s.want)
+		}
+	}
+}
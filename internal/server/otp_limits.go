@@ -0,0 +1,127 @@
+package server
+
+import (
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dvher/nibbin.cl_back/internal/ratelimit"
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	otpResendEvery   = time.Minute
+	otpIssueWindow   = time.Hour
+	otpIssueLimit    = 5
+	otpIssueIPLimit  = 20
+	otpVerifyWindow  = 15 * time.Minute
+	otpVerifyLimit   = 10
+	otpVerifyIPLimit = 30
+
+	passkeyLoginWindow  = 15 * time.Minute
+	passkeyLoginIPLimit = 30
+)
+
+// otpLimits bounds how often codes can be sent and checked, per email and
+// per client IP, so the SMTP account can't be used to spam an address and
+// the attempt limit can't be reset by asking for a new code. Passkey logins
+// are limited per IP as well.
+type otpLimits struct {
+	cooldown    *ratelimit.Limiter
+	issueEmail  *ratelimit.Limiter
+	issueIP     *ratelimit.Limiter
+	verifyEmail *ratelimit.Limiter
+	verifyIP    *ratelimit.Limiter
+	passkeyIP   *ratelimit.Limiter
+}
+
+func newOTPLimits() *otpLimits {
+	return &otpLimits{
+		cooldown:    ratelimit.New(1, otpResendEvery),
+		issueEmail:  ratelimit.New(otpIssueLimit, otpIssueWindow),
+		issueIP:     ratelimit.New(otpIssueIPLimit, otpIssueWindow),
+		verifyEmail: ratelimit.New(otpVerifyLimit, otpVerifyWindow),
+		verifyIP:    ratelimit.New(otpVerifyIPLimit, otpVerifyWindow),
+		passkeyIP:   ratelimit.New(passkeyLoginIPLimit, passkeyLoginWindow),
+	}
+}
+
+type limitCheck struct {
+	limiter *ratelimit.Limiter
+	key     string
+}
+
+// allowOTPIssue responds with 429 and returns false if no code may be sent
+// to email right now.
+func (h *handlers) allowOTPIssue(c *gin.Context, email string) bool {
+	email = normalizeEmail(email)
+
+	return allow(c, []limitCheck{
+		{h.limits.issueIP, c.ClientIP()},
+		{h.limits.cooldown, email},
+		{h.limits.issueEmail, email},
+	})
+}
+
+// allowOTPVerify responds with 429 and returns false if no more codes may
+// be checked for email right now.
+func (h *handlers) allowOTPVerify(c *gin.Context, email string) bool {
+	email = normalizeEmail(email)
+
+	return allow(c, []limitCheck{
+		{h.limits.verifyIP, c.ClientIP()},
+		{h.limits.verifyEmail, email},
+	})
+}
+
+// allowPasskeyLogin responds with 429 and returns false if the client IP
+// started too many passkey logins.
+func (h *handlers) allowPasskeyLogin(c *gin.Context) bool {
+	return allow(c, []limitCheck{
+		{h.limits.passkeyIP, c.ClientIP()},
+	})
+}
+
+// allow only records the attempt once every limit has room for it, so a
+// client already over its IP limit can't use up the budget of an email.
+func allow(c *gin.Context, checks []limitCheck) bool {
+	for _, check := range checks {
+		if ok, retry := check.limiter.Check(check.key); !ok {
+			tooManyRequests(c, retry)
+			return false
+		}
+	}
+
+	for _, check := range checks {
+		if ok, retry := check.limiter.Allow(check.key); !ok {
+			tooManyRequests(c, retry)
+			return false
+		}
+	}
+
+	return true
+}
+
+func tooManyRequests(c *gin.Context, retry time.Duration) {
+	seconds := int(math.Ceil(retry.Seconds()))
+
+	if seconds < 1 {
+		seconds = 1
+	}
+
+	log.Println("Too many requests")
+
+	c.Header("Retry-After", strconv.Itoa(seconds))
+
+	c.JSON(http.StatusTooManyRequests, gin.H{
+		"message":    "Too many requests",
+		"retryAfter": seconds,
+	})
+}
+
+func normalizeEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
@@ -0,0 +1,324 @@
+package server
+
+import (
+	"database/sql"
+	"errors"
+	"log"
+	"math/big"
+	"sync"
+	"time"
+)
+
+const (
+	otpTTL        = 3 * time.Minute
+	otpMaxTries   = 3
+	otpPurgeEvery = time.Minute
+)
+
+var ErrOTPNotFound = errors.New("otp not found")
+
+type OTPData struct {
+	Tries  int
+	Code   *big.Int
+	Action PendingAction
+}
+
+// OTPStore keeps the codes pending verification, keyed by the email as
+// normalizeEmail returns it. Entries expire on their own once their TTL
+// has passed.
+type OTPStore interface {
+	Set(email string, data OTPData, ttl time.Duration) error
+	Get(email string) (OTPData, error)
+	IncrementTries(email string) (OTPData, error)
+	// Consume deletes the entry only if it still holds code, so a code
+	// completes at most one action. It returns ErrOTPNotFound otherwise.
+	Consume(email string, code *big.Int) error
+	Delete(email string) error
+	Close() error
+}
+
+type otpEntry struct {
+	data    OTPData
+	expires time.Time
+}
+
+type memoryOTPStore struct {
+	mu      sync.Mutex
+	entries map[string]otpEntry
+	done    chan struct{}
+	once    sync.Once
+	wg      sync.WaitGroup
+}
+
+func NewMemoryOTPStore() OTPStore {
+	s := &memoryOTPStore{
+		entries: make(map[string]otpEntry),
+		done:    make(chan struct{}),
+	}
+
+	s.wg.Add(1)
+	go s.purge()
+
+	return s
+}
+
+func (s *memoryOTPStore) Set(email string, data OTPData, ttl time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.entries[email] = otpEntry{
+		data:    data,
+		expires: time.Now().Add(ttl),
+	}
+
+	return nil
+}
+
+func (s *memoryOTPStore) Get(email string) (OTPData, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entry, ok := s.entries[email]
+
+	if !ok || time.Now().After(entry.expires) {
+		delete(s.entries, email)
+		return OTPData{}, ErrOTPNotFound
+	}
+
+	return entry.data, nil
+}
+
+func (s *memoryOTPStore) IncrementTries(email string) (OTPData, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entry, ok := s.entries[email]
+
+	if !ok || time.Now().After(entry.expires) {
+		delete(s.entries, email)
+		return OTPData{}, ErrOTPNotFound
+	}
+
+	entry.data.Tries++
+	s.entries[email] = entry
+
+	return entry.data, nil
+}
+
+func (s *memoryOTPStore) Consume(email string, code *big.Int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entry, ok := s.entries[email]
+
+	if !ok || time.Now().After(entry.expires) || entry.data.Code.Cmp(code) != 0 {
+		return ErrOTPNotFound
+	}
+
+	delete(s.entries, email)
+
+	return nil
+}
+
+func (s *memoryOTPStore) Delete(email string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.entries, email)
+
+	return nil
+}
+
+func (s *memoryOTPStore) Close() error {
+	s.once.Do(func() {
+		close(s.done)
+	})
+
+	s.wg.Wait()
+
+	return nil
+}
+
+func (s *memoryOTPStore) purge() {
+	defer s.wg.Done()
+
+	ticker := time.NewTicker(otpPurgeEvery)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.done:
+			return
+		case now := <-ticker.C:
+			s.mu.Lock()
+			for email, entry := range s.entries {
+				if now.After(entry.expires) {
+					delete(s.entries, email)
+				}
+			}
+			s.mu.Unlock()
+		}
+	}
+}
+
+// The OTP table is created by internal/database/migrations/0002_otp.up.sql.
+type mysqlOTPStore struct {
+	db   *sql.DB
+	done chan struct{}
+	once sync.Once
+	wg   sync.WaitGroup
+}
+
+func NewMySQLOTPStore(db *sql.DB) OTPStore {
+	s := &mysqlOTPStore{
+		db:   db,
+		done: make(chan struct{}),
+	}
+
+	s.wg.Add(1)
+	go s.purge()
+
+	return s
+}
+
+func (s *mysqlOTPStore) Set(email string, data OTPData, ttl time.Duration) error {
+	kind, payload, err := encodeAction(data.Action)
+
+	if err != nil {
+		return err
+	}
+
+	_, err = s.db.Exec(
+		"INSERT INTO OTP (email, codigo, intentos, accion, datos, expira) VALUES (?, ?, ?, ?, ?, ?) "+
+			"ON DUPLICATE KEY UPDATE codigo = VALUES(codigo), intentos = VALUES(intentos), accion = VALUES(accion), "+
+			"datos = VALUES(datos), expira = VALUES(expira);",
+		email, data.Code.String(), data.Tries, kind, payload, time.Now().UTC().Add(ttl),
+	)
+
+	return err
+}
+
+func (s *mysqlOTPStore) Get(email string) (OTPData, error) {
+	return s.get(s.db.QueryRow(
+		"SELECT codigo, intentos, accion, datos FROM OTP WHERE email = ? AND expira > ?;",
+		email, time.Now().UTC(),
+	))
+}
+
+func (s *mysqlOTPStore) IncrementTries(email string) (OTPData, error) {
+	tx, err := s.db.Begin()
+
+	if err != nil {
+		return OTPData{}, err
+	}
+
+	defer tx.Rollback()
+
+	now := time.Now().UTC()
+
+	res, err := tx.Exec("UPDATE OTP SET intentos = intentos + 1 WHERE email = ? AND expira > ?;", email, now)
+
+	if err != nil {
+		return OTPData{}, err
+	}
+
+	if n, err := res.RowsAffected(); err != nil {
+		return OTPData{}, err
+	} else if n == 0 {
+		return OTPData{}, ErrOTPNotFound
+	}
+
+	data, err := s.get(tx.QueryRow("SELECT codigo, intentos, accion, datos FROM OTP WHERE email = ?;", email))
+
+	if err != nil {
+		return OTPData{}, err
+	}
+
+	return data, tx.Commit()
+}
+
+func (s *mysqlOTPStore) Consume(email string, code *big.Int) error {
+	res, err := s.db.Exec(
+		"DELETE FROM OTP WHERE email = ? AND codigo = ? AND expira > ?;",
+		email, code.String(), time.Now().UTC(),
+	)
+
+	if err != nil {
+		return err
+	}
+
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return ErrOTPNotFound
+	}
+
+	return nil
+}
+
+func (s *mysqlOTPStore) Delete(email string) error {
+	_, err := s.db.Exec("DELETE FROM OTP WHERE email = ?;", email)
+
+	return err
+}
+
+func (s *mysqlOTPStore) Close() error {
+	s.once.Do(func() {
+		close(s.done)
+	})
+
+	s.wg.Wait()
+
+	return nil
+}
+
+func (s *mysqlOTPStore) get(row *sql.Row) (OTPData, error) {
+	var data OTPData
+	var code string
+	var kind ActionKind
+	var payload []byte
+
+	err := row.Scan(&code, &data.Tries, &kind, &payload)
+
+	if errors.Is(err, sql.ErrNoRows) {
+		return OTPData{}, ErrOTPNotFound
+	}
+
+	if err != nil {
+		return OTPData{}, err
+	}
+
+	var ok bool
+
+	data.Code, ok = new(big.Int).SetString(code, 10)
+
+	if !ok {
+		return OTPData{}, errors.New("invalid stored otp code")
+	}
+
+	data.Action, err = decodeAction(kind, payload)
+
+	if err != nil {
+		return OTPData{}, err
+	}
+
+	return data, nil
+}
+
+func (s *mysqlOTPStore) purge() {
+	defer s.wg.Done()
+
+	ticker := time.NewTicker(otpPurgeEvery)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.done:
+			return
+		case now := <-ticker.C:
+			if _, err := s.db.Exec("DELETE FROM OTP WHERE expira <= ?;", now.UTC()); err != nil {
+				log.Println("Error purging expired OTPs", err)
+			}
+		}
+	}
+}
@@ -0,0 +1,105 @@
+package server
+
+import (
+	"errors"
+	"math/big"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestMemoryOTPStoreIncrementTries(t *testing.T) {
+	store := NewMemoryOTPStore()
+	defer store.Close()
+
+	err := store.Set("user@nibbin.cl", OTPData{Code: big.NewInt(123456), Action: LoginAction{}}, time.Minute)
+
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	var wg sync.WaitGroup
+
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := store.IncrementTries("user@nibbin.cl"); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+
+	wg.Wait()
+
+	data, err := store.Get("user@nibbin.cl")
+
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if data.Tries != 50 {
+		t.Errorf("Got %d tries when should be 50\n", data.Tries)
+	}
+}
+
+func TestMemoryOTPStoreExpiry(t *testing.T) {
+	store := NewMemoryOTPStore()
+	defer store.Close()
+
+	err := store.Set("user@nibbin.cl", OTPData{Code: big.NewInt(123456), Action: LoginAction{}}, -time.Second)
+
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if _, err := store.Get("user@nibbin.cl"); !errors.Is(err, ErrOTPNotFound) {
+		t.Errorf("Got error %v when should be %v\n", err, ErrOTPNotFound)
+	}
+
+	if _, err := store.IncrementTries("user@nibbin.cl"); !errors.Is(err, ErrOTPNotFound) {
+		t.Errorf("Got error %v when should be %v\n", err, ErrOTPNotFound)
+	}
+}
+
+func TestMemoryOTPStoreConsume(t *testing.T) {
+	store := NewMemoryOTPStore()
+	defer store.Close()
+
+	err := store.Set("user@nibbin.cl", OTPData{Code: big.NewInt(123456), Action: LoginAction{}}, time.Minute)
+
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if err := store.Consume("user@nibbin.cl", big.NewInt(654321)); !errors.Is(err, ErrOTPNotFound) {
+		t.Errorf("Got error %v for another code when should be %v\n", err, ErrOTPNotFound)
+	}
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+
+	consumed := 0
+
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if store.Consume("user@nibbin.cl", big.NewInt(123456)) == nil {
+				mu.Lock()
+				consumed++
+				mu.Unlock()
+			}
+		}()
+	}
+
+	wg.Wait()
+
+	if consumed != 1 {
+		t.Errorf("Got the code consumed %d times when should be 1\n", consumed)
+	}
+}
@@ -1,37 +1,25 @@
 package server
 
 import (
-	"fmt"
+	"errors"
 	"log"
 	"math/big"
 	"net/http"
 	"strconv"
-	"strings"
 
-	db "github.com/dvher/nibbin.cl_back/internal/database"
+	"github.com/dvher/nibbin.cl_back/internal/repository"
 	"github.com/dvher/nibbin.cl_back/pkg/models"
 	"github.com/gin-contrib/sessions"
 	"github.com/gin-gonic/gin"
 )
 
-type OTPData struct {
-	Tries  int
-	Code   *big.Int
-	Action string
-}
-
-var mailToOTP = make(map[string]OTPData)
-var mailToChan = make(map[string]chan any)
-
 func ping(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{
 		"message": "pong",
 	})
 }
 
-func verifyOTP(c *gin.Context) {
-
-	sess := sessions.Default(c)
+func (h *handlers) verifyOTP(c *gin.Context) {
 
 	var data models.OTPRequest
 
@@ -55,31 +43,6 @@ func verifyOTP(c *gin.Context) {
 		return
 	}
 
-	if x, ok := mailToOTP[data.Email]; ok {
-		if x.Tries >= 3 {
-			log.Println("Too many tries")
-
-			delete(mailToOTP, data.Email)
-
-			c.JSON(http.StatusBadRequest, gin.H{
-				"message": "Too many tries",
-			})
-			return
-		}
-		mailToOTP[data.Email] = OTPData{
-			Tries:  x.Tries + 1,
-			Code:   x.Code,
-			Action: x.Action,
-		}
-	} else {
-		log.Println("Email not found")
-
-		c.JSON(http.StatusBadRequest, gin.H{
-			"message": "Email not found",
-		})
-		return
-	}
-
 	if data.Email == "" {
 		log.Println("Email not provided")
 
@@ -98,181 +61,151 @@ func verifyOTP(c *gin.Context) {
 		return
 	}
 
-	if big.NewInt(int64(code)).Cmp(mailToOTP[data.Email].Code) != 0 {
-		log.Println("Invalid OTP")
-
-		c.JSON(http.StatusBadRequest, gin.H{
-			"message": "Invalid OTP",
-		})
+	if !h.allowOTPVerify(c, data.Email) {
 		return
 	}
 
-	if strings.HasPrefix(mailToOTP[data.Email].Action, "registerAdmin") {
-		s := strings.Split(mailToOTP[data.Email].Action, " ")
-
-		if len(s) != 4 {
-			log.Println("Invalid action")
-
-			c.JSON(http.StatusBadRequest, gin.H{
-				"message": "Invalid action",
-			})
-			return
-		}
-
-		password, id := s[2], s[3]
+	key := normalizeEmail(data.Email)
 
-		stmt, err := db.DB.Prepare("INSERT INTO Administrador (idUsuario, contrasena) VALUES (?, ?);")
+	otp, err := h.OTPs.IncrementTries(key)
 
-		if err != nil {
-			log.Println("Error preparing statement", err)
+	if errors.Is(err, ErrOTPNotFound) {
+		log.Println("Email not found")
 
-			c.JSON(http.StatusInternalServerError, gin.H{
-				"message": "Error preparing statement",
-			})
-			return
-		}
+		c.JSON(http.StatusBadRequest, gin.H{
+			"message": "Email not found",
+		})
+		return
+	}
 
-		defer stmt.Close()
+	if err != nil {
+		log.Println("Error retrieving OTP", err)
 
-		_, err = stmt.Exec(id, password)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Error retrieving OTP",
+		})
+		return
+	}
 
-		if err != nil {
-			log.Println("Error inserting admin", err)
+	if otp.Tries > otpMaxTries {
+		log.Println("Too many tries")
 
-			c.JSON(http.StatusInternalServerError, gin.H{
-				"message": "Error inserting admin",
-			})
-			return
+		if err := h.OTPs.Delete(key); err != nil {
+			log.Println("Error deleting OTP", err)
 		}
 
-		c.JSON(http.StatusOK, gin.H{
-			"message": "Admin registered",
+		c.JSON(http.StatusBadRequest, gin.H{
+			"message": "Too many tries",
 		})
 		return
 	}
 
-	str := fmt.Sprintf("%s verified", mailToOTP[data.Email].Action)
-
-	if strings.HasPrefix(mailToOTP[data.Email].Action, "login") {
-		s := strings.SplitN(mailToOTP[data.Email].Action, " ", 2)
+	if big.NewInt(int64(code)).Cmp(otp.Code) != 0 {
+		log.Println("Invalid OTP")
 
-		if len(s) != 2 {
-			log.Println("Invalid action")
+		c.JSON(http.StatusBadRequest, gin.H{
+			"message": "Invalid OTP",
+		})
+		return
+	}
 
-			c.JSON(http.StatusBadRequest, gin.H{
-				"message": "Invalid action",
-			})
-			return
-		}
+	// Only the request that removes the code may complete the action, so a
+	// code can't be replayed, not even by concurrent requests.
+	err = h.OTPs.Consume(key, otp.Code)
 
-		if s[1] != "unregistered" {
+	if errors.Is(err, ErrOTPNotFound) {
+		log.Println("OTP already used")
 
-			user := s[1]
+		c.JSON(http.StatusBadRequest, gin.H{
+			"message": "Invalid OTP",
+		})
+		return
+	}
 
-			sess.Set("user", user)
-			sess.Set("email", data.Email)
-			if err := sess.Save(); err != nil {
-				log.Println("Error saving session", err)
+	if err != nil {
+		log.Println("Error deleting OTP", err)
 
-				c.JSON(http.StatusInternalServerError, gin.H{
-					"message": "Error saving session",
-				})
-				return
-			}
-		}
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Error deleting OTP",
+		})
+		return
 	}
 
-	delete(mailToOTP, data.Email)
-
-	c.JSON(http.StatusOK, gin.H{
-		"message": str,
-	})
+	h.completeAction(c, data.Email, otp.Action)
 }
 
-func searchProducts(c *gin.Context) {
+// resendOTP sends a new code for the action pending on the email. The
+// attempts made with the previous code still count.
+func (h *handlers) resendOTP(c *gin.Context) {
 
-	search := c.Param("query")
-	userID := getUserID(c)
+	var data models.ResendOTPRequest
 
-	if search == "" {
-		log.Println("Search not provided")
+	if err := c.BindJSON(&data); err != nil {
+		log.Println("Error binding json", err)
 
 		c.JSON(http.StatusBadRequest, gin.H{
-			"message": "Search not provided",
+			"message": "Error binding json",
 		})
 		return
 	}
 
-	stmt, err := db.DB.Prepare(
-		"CALL SearchProductos(?, ?);",
-	)
+	if !h.allowOTPIssue(c, data.Email) {
+		return
+	}
 
-	if err != nil {
-		log.Println("Error preparing statement", err)
+	otp, err := h.OTPs.Get(normalizeEmail(data.Email))
 
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"message": "Error preparing statement",
+	// Answer as if a code was sent, so this can't tell which emails have
+	// a login in progress.
+	if errors.Is(err, ErrOTPNotFound) {
+		log.Println("No pending code")
+
+		c.JSON(http.StatusOK, gin.H{
+			"message": "Email sent",
 		})
 		return
 	}
 
-	defer stmt.Close()
-
-	rows, err := stmt.Query(userID, search)
-
 	if err != nil {
-		log.Println("Error querying products", err)
+		log.Println("Error retrieving OTP", err)
 
 		c.JSON(http.StatusInternalServerError, gin.H{
-			"message": "Error querying products",
+			"message": "Error retrieving OTP",
 		})
 		return
 	}
 
-	defer rows.Close()
-
-	var products []models.DescProducto
-
-	for rows.Next() {
-		var product models.DescProducto
+	err = h.sendOTPEmail(c.Request.Context(), []string{data.Email}, h.locale(c, data.Email), otp.Action)
 
-		if err := rows.Scan(&product.ID, &product.Nombre, &product.Marca, &product.Descripcion, &product.Descuento, &product.Stock, &product.Imagen, &product.Precio, &product.IsFavorite); err != nil {
-			log.Println("Error scanning products", err)
-
-			c.JSON(http.StatusInternalServerError, gin.H{
-				"message": "Error scanning products",
-			})
-			return
-		}
+	if err != nil {
+		log.Println("Error sending email", err)
 
-		products = append(products, product)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Error sending email",
+		})
+		return
 	}
 
 	c.JSON(http.StatusOK, gin.H{
-		"message":  "Products found",
-		"products": products,
+		"message": "Email sent",
 	})
 }
 
-func getProducts(c *gin.Context) {
+func (h *handlers) searchProducts(c *gin.Context) {
 
-	userID := getUserID(c)
-
-	var products []models.DescProducto
-
-	stmt, err := db.DB.Prepare("CALL DescProductos(?);")
+	search := c.Param("query")
+	userID := h.getUserID(c)
 
-	if err != nil {
-		log.Println("Error preparing statement", err)
+	if search == "" {
+		log.Println("Search not provided")
 
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"message": "Error preparing statement",
+		c.JSON(http.StatusBadRequest, gin.H{
+			"message": "Search not provided",
 		})
+		return
 	}
 
-	defer stmt.Close()
-
-	rows, err := stmt.Query(userID)
+	products, err := h.Products.Search(c.Request.Context(), userID, search)
 
 	if err != nil {
 		log.Println("Error querying products", err)
@@ -283,33 +216,25 @@ func getProducts(c *gin.Context) {
 		return
 	}
 
-	defer rows.Close()
+	c.JSON(http.StatusOK, gin.H{
+		"message":  "Products found",
+		"products": products,
+	})
+}
 
-	for rows.Next() {
-		var prod models.DescProducto
+func (h *handlers) getProducts(c *gin.Context) {
 
-		err = rows.Scan(
-			&prod.ID,
-			&prod.Nombre,
-			&prod.Marca,
-			&prod.Descripcion,
-			&prod.Precio,
-			&prod.Descuento,
-			&prod.Stock,
-			&prod.Imagen,
-			&prod.IsFavorite,
-		)
+	userID := h.getUserID(c)
 
-		if err != nil {
-			log.Println("Error scanning products", err)
+	products, err := h.Products.List(c.Request.Context(), userID)
 
-			c.JSON(http.StatusInternalServerError, gin.H{
-				"message": "Error scanning products",
-			})
-			return
-		}
+	if err != nil {
+		log.Println("Error querying products", err)
 
-		products = append(products, prod)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Error querying products",
+		})
+		return
 	}
 
 	c.JSON(http.StatusOK, gin.H{
@@ -319,26 +244,42 @@ func getProducts(c *gin.Context) {
 
 }
 
-func getProduct(c *gin.Context) {
+func (h *handlers) getProduct(c *gin.Context) {
 
-	id := c.Param("id")
+	id, ok := productIDParam(c)
 
-	if id == "" {
-		log.Println("ID not provided")
+	if !ok {
+		return
+	}
 
-		c.JSON(http.StatusBadRequest, gin.H{
-			"message": "ID not provided",
+	product, err := h.Products.ByID(c.Request.Context(), h.getUserID(c), id)
+
+	if errors.Is(err, repository.ErrNotFound) {
+		log.Println("Product not found")
+
+		c.JSON(http.StatusNotFound, gin.H{
+			"message": "Product not found",
+		})
+		return
+	}
+
+	if err != nil {
+		log.Println("Error querying product", err)
+
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Error querying product",
 		})
 		return
 	}
 
 	c.JSON(http.StatusOK, gin.H{
 		"message": "Product retrieved",
+		"product": product,
 	})
 
 }
 
-func toggleFavorite(c *gin.Context) {
+func (h *handlers) toggleFavorite(c *gin.Context) {
 
 	sess := sessions.Default(c)
 	var data models.Favorito
@@ -372,22 +313,9 @@ func toggleFavorite(c *gin.Context) {
 		return
 	}
 
-	stmt, err := db.DB.Prepare("SELECT id FROM Usuario WHERE usuario = ?;")
+	ctx := c.Request.Context()
 
-	if err != nil {
-		log.Println("Error preparing statement", err)
-
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"message": "Error preparing statement",
-		})
-		return
-	}
-
-	defer stmt.Close()
-
-	var id int
-
-	err = stmt.QueryRow(user.(string)).Scan(&id)
+	id, err := h.Users.IDByUsername(ctx, user.(string))
 
 	if err != nil {
 		log.Println("Error querying user", err)
@@ -398,24 +326,7 @@ func toggleFavorite(c *gin.Context) {
 		return
 	}
 
-	data.IDUsuario = id
-
-	stmt, err = db.DB.Prepare("SELECT EXISTS(SELECT * FROM Favorito WHERE idUsuario = ? AND idProducto = ?) AS existe;")
-
-	if err != nil {
-		log.Println("Error preparing statement", err)
-
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"message": "Error preparing statement",
-		})
-		return
-	}
-
-	defer stmt.Close()
-
-	var exists bool
-
-	err = stmt.QueryRow(id, data.IDProducto).Scan(&exists)
+	exists, err := h.Favorites.Exists(ctx, id, data.IDProducto)
 
 	if err != nil {
 		log.Println("Error querying favorite", err)
@@ -427,7 +338,7 @@ func toggleFavorite(c *gin.Context) {
 	}
 
 	if exists {
-		if err := unsetFavorite(data); err != nil {
+		if err := h.Favorites.Remove(ctx, id, data.IDProducto); err != nil {
 			log.Println("Error removing favorite", err)
 
 			c.JSON(http.StatusInternalServerError, gin.H{
@@ -442,7 +353,7 @@ func toggleFavorite(c *gin.Context) {
 		return
 
 	} else {
-		if err := setFavorite(data); err != nil {
+		if err := h.Favorites.Add(ctx, id, data.IDProducto); err != nil {
 			log.Println("Error adding favorite", err)
 
 			c.JSON(http.StatusInternalServerError, gin.H{
@@ -459,61 +370,17 @@ func toggleFavorite(c *gin.Context) {
 
 }
 
-func setFavorite(data models.Favorito) error {
-
-	stmt, err := db.DB.Prepare("INSERT INTO Favorito (idProducto, idUsuario) VALUES (?, ?);")
-
-	if err != nil {
-		return err
-	}
-
-	defer stmt.Close()
-
-	if _, err := stmt.Exec(data.IDProducto, data.IDUsuario); err != nil {
-		return err
-	}
-
-	return nil
-}
-
-func unsetFavorite(data models.Favorito) error {
-
-	stmt, err := db.DB.Prepare("DELETE FROM Favorito WHERE idProducto = ? AND idUsuario = ?;")
-
-	if err != nil {
-		return err
-	}
-
-	defer stmt.Close()
-
-	if _, err := stmt.Exec(data.IDProducto, data.IDUsuario); err != nil {
-		return err
-	}
-
-	return nil
-}
-
-func getUserID(c *gin.Context) int {
+func (h *handlers) getUserID(c *gin.Context) int {
 
 	sess := sessions.Default(c)
 
-	useriface := sess.Get("user")
+	user, ok := sess.Get("user").(string)
 
-	if _, ok := useriface.(string); !ok {
+	if !ok {
 		return 0
 	}
 
-	stmt, err := db.DB.Prepare("SELECT id FROM Usuario WHERE usuario = ?;")
-
-	if err != nil {
-		return 0
-	}
-
-	defer stmt.Close()
-
-	var id int
-
-	err = stmt.QueryRow(useriface.(string)).Scan(&id)
+	id, err := h.Users.IDByUsername(c.Request.Context(), user)
 
 	if err != nil {
 		return 0
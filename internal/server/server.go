@@ -1,30 +1,86 @@
 package server
 
 import (
+	"context"
+	"fmt"
 	"log"
 	"net/http"
-	"os"
 	"time"
 
+	"github.com/dvher/nibbin.cl_back/internal/config"
+	"github.com/dvher/nibbin.cl_back/internal/mailer"
 	"github.com/dvher/nibbin.cl_back/internal/middleware"
+	"github.com/dvher/nibbin.cl_back/internal/outbox"
+	"github.com/dvher/nibbin.cl_back/internal/rbac"
+	"github.com/dvher/nibbin.cl_back/internal/repository"
+	"github.com/dvher/nibbin.cl_back/internal/sessionstore"
+	"github.com/dvher/nibbin.cl_back/pkg/argon2"
+	"github.com/dvher/nibbin.cl_back/pkg/password"
+	"github.com/dvher/nibbin.cl_back/templates"
 	"github.com/gin-contrib/cors"
 	"github.com/gin-contrib/sessions"
-	"github.com/gin-contrib/sessions/cookie"
 	"github.com/gin-gonic/gin"
+	"github.com/go-webauthn/webauthn/webauthn"
+	gsessions "github.com/gorilla/sessions"
 	csrf "github.com/utrack/gin-csrf"
 )
 
-/*
-* When on production, change AlowOrigins to the domain of the front-end
-* and store.Options.Secure to true
- */
+// SessionStore is a sessions.Store whose sessions can be listed and
+// revoked server side.
+type SessionStore interface {
+	sessions.Store
+	Regenerate(session *gsessions.Session) error
+	List(user, current string) ([]sessionstore.Info, error)
+	Revoke(user string, id int) error
+	RevokeAll(user string) error
+	Close() error
+}
+
+// Outbox queues emails for background delivery.
+type Outbox interface {
+	Enqueue(ctx context.Context, msg mailer.Message) error
+	List(ctx context.Context, state outbox.State) ([]outbox.Entry, error)
+	Retry(ctx context.Context, id int) error
+}
+
+// Deps holds everything the handlers need from the outside world.
+type Deps struct {
+	Users       repository.UserRepository
+	Products    repository.ProductRepository
+	Favorites   repository.FavoriteRepository
+	Admins      repository.AdminRepository
+	Credentials repository.CredentialRepository
+	Tokens      repository.TokenRepository
+	OTPs        OTPStore
+	Sessions    SessionStore
+	Outbox      Outbox
+	Templates   *templates.Set
+}
+
+type handlers struct {
+	Deps
+	cfg            *config.Config
+	sessionOptions sessions.Options
+	actions        map[ActionKind]actionCompleter
+	limits         *otpLimits
+	webauthn       *webauthn.WebAuthn
+	passwords      *argon2.Pool
+	hashes         *password.Registry
+	decoyHash      string
+}
+
+func New(cfg *config.Config, deps Deps) (*gin.Engine, error) {
+
+	h, err := newHandlers(cfg, deps)
 
-func New() *gin.Engine {
+	if err != nil {
+		return nil, err
+	}
 
 	r := gin.Default()
 
 	r.Use(cors.New(cors.Config{
-		AllowOrigins:     []string{"http://localhost:3000", "http://nibbin.cl:3000"},
+		AllowOrigins:     cfg.CORSOrigins,
 		AllowMethods:     []string{"GET", "POST", "PUT", "DELETE", "PATCH", "OPTIONS"},
 		AllowHeaders:     []string{"Origin", "Content-Length", "Content-Type"},
 		ExposeHeaders:    []string{"Content-Length"},
@@ -32,20 +88,12 @@ func New() *gin.Engine {
 		MaxAge:           12 * time.Hour,
 	}))
 
-	store := cookie.NewStore([]byte(os.Getenv("SESSION_KEY")), []byte(os.Getenv("SESSION_ENC")))
+	deps.Sessions.Options(h.sessionOptions)
 
-	store.Options(sessions.Options{
-		Path:     "/",
-		MaxAge:   86400,
-		HttpOnly: true,
-		Secure:   false,
-		Domain:   "localhost",
-	})
-
-	r.Use(sessions.Sessions("nibbinSession", store))
+	r.Use(sessions.Sessions("nibbinSession", deps.Sessions))
 
-	r.Use(csrf.Middleware(csrf.Options{
-		Secret: os.Getenv("CSRF_SECRET"),
+	csrfCheck := csrf.Middleware(csrf.Options{
+		Secret: cfg.CSRFSecret,
 		ErrorFunc: func(c *gin.Context) {
 
 			log.Println("CSRF token mismatch")
@@ -71,32 +119,138 @@ func New() *gin.Engine {
 
 			return token.(string)
 		},
-	}))
+	})
 
 	r.SetTrustedProxies(nil)
 
 	public := r.Group("/")
 
+	public.Use(csrfCheck)
+
 	public.GET("/", ping)
 	public.GET("/islogged", isLogged)
-	public.GET("/product", getProducts)
-	public.GET("/product/:id", getProduct)
-	public.GET("/search/product/:query", searchProducts)
-	public.POST("/login", login)
-	public.POST("/verify", verifyOTP)
-	public.POST("/register", register)
-	public.PUT("/togglefavorite", toggleFavorite)
-	public.DELETE("/logout", logout)
+	public.GET("/product", h.getProducts)
+	public.GET("/product/:id", h.getProduct)
+	public.GET("/search/product/:query", h.searchProducts)
+	public.POST("/login", h.login)
+	public.POST("/verify", h.verifyOTP)
+	public.POST("/otp/resend", h.resendOTP)
+	public.POST("/register", h.register)
+	public.POST("/admin/login", h.loginAdmin)
+	public.PUT("/togglefavorite", h.toggleFavorite)
+	public.PUT("/email", h.changeEmail)
+	public.PUT("/locale", h.setLocale)
+	public.DELETE("/logout", h.logout)
+	public.GET("/sessions", h.listSessions)
+	public.DELETE("/sessions", h.revokeSessions)
+	public.DELETE("/sessions/:id", h.revokeSession)
+	public.DELETE("/account", h.deleteAccount)
+	public.POST("/webauthn/register/begin", h.beginPasskeyRegistration)
+	public.POST("/webauthn/register/finish", h.finishPasskeyRegistration)
+	public.POST("/webauthn/login/begin", h.beginPasskeyLogin)
+	public.POST("/webauthn/login/finish", h.finishPasskeyLogin)
+	public.GET("/webauthn/credentials", h.listPasskeys)
+	public.DELETE("/webauthn/credentials/:id", h.deletePasskey)
 
 	private := r.Group("/admin")
 
-	private.Use(middleware.Auth())
+	private.Use(skipCSRFForTokens(csrfCheck))
+	private.Use(middleware.Auth(deps.Admins, deps.Tokens))
 
-	private.POST("/product", insertProduct)
-	private.POST("/login", loginAdmin)
-	private.POST("/register", registerAdmin)
+	private.POST("/totp", middleware.RequireSession(), h.enrollTOTP)
+	private.POST("/totp/confirm", middleware.RequireSession(), h.confirmTOTP)
+	private.DELETE("/totp", middleware.RequireSession(), h.disableTOTP)
+	private.GET("/tokens", middleware.RequireSession(), h.listTokens)
+	private.POST("/tokens", middleware.RequireSession(), h.createToken)
+	private.DELETE("/tokens/:id", middleware.RequireSession(), h.revokeToken)
+	private.POST("/product", middleware.RequirePermission(rbac.PermProductWrite), h.insertProduct)
+	private.PUT("/product/:id", middleware.RequirePermission(rbac.PermProductWrite), h.updateProduct)
+	private.PATCH("/product/:id", middleware.RequirePermission(rbac.PermProductWrite), h.patchProduct)
+	private.DELETE("/product/:id", middleware.RequirePermission(rbac.PermProductWrite), h.deleteProduct)
+	private.POST("/product/:id/restore", middleware.RequirePermission(rbac.PermProductWrite), h.restoreProduct)
+	private.POST("/register", middleware.RequirePermission(rbac.PermAdminWrite), h.registerAdmin)
+	private.GET("/roles", middleware.RequirePermission(rbac.PermAdminRead), listRoles)
+	private.GET("/admins", middleware.RequirePermission(rbac.PermAdminRead), h.listAdmins)
+	private.PUT("/admins/:user/role", middleware.RequirePermission(rbac.PermAdminWrite), h.assignRole)
+	private.DELETE("/admins/:user", middleware.RequirePermission(rbac.PermAdminWrite), h.demoteAdmin)
+	private.GET("/outbox", middleware.RequirePermission(rbac.PermAdminRead), h.listOutbox)
+	private.POST("/outbox/:id/retry", middleware.RequirePermission(rbac.PermAdminWrite), h.retryEmail)
 
 	log.Println("Server started")
 
-	return r
+	return r, nil
+}
+
+// skipCSRFForTokens lets requests authenticated with an API token through
+// without a CSRF token. They don't use cookies, and middleware.Auth never
+// falls back to the session when an Authorization header is present, so
+// it is only used on the routes behind middleware.Auth.
+func skipCSRFForTokens(check gin.HandlerFunc) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if _, ok := middleware.BearerToken(c); ok {
+			c.Next()
+			return
+		}
+
+		check(c)
+	}
+}
+
+func newHandlers(cfg *config.Config, deps Deps) (*handlers, error) {
+	h := &handlers{
+		Deps: deps,
+		cfg:  cfg,
+		sessionOptions: sessions.Options{
+			Path:     "/",
+			MaxAge:   cfg.Session.MaxAge,
+			HttpOnly: true,
+			Secure:   cfg.Session.Secure,
+			Domain:   cfg.Session.Domain,
+		},
+		limits: newOTPLimits(),
+	}
+
+	policy := cfg.Argon2.Policy()
+
+	if err := policy.Check(h.passwordConfig()); err != nil {
+		return nil, fmt.Errorf("invalid argon2 configuration: %w", err)
+	}
+
+	h.passwords = argon2.NewPool(nil, policy, cfg.Argon2.Concurrency)
+	h.hashes = password.NewDefaultRegistry(h.passwords, h.passwordConfig())
+
+	decoy, err := argon2.GenerateSecureSalt(32)
+
+	if err != nil {
+		return nil, fmt.Errorf("generating decoy password: %w", err)
+	}
+
+	decoyHash, err := h.passwords.Hash(context.Background(), decoy, h.passwordConfig())
+
+	if err != nil {
+		return nil, fmt.Errorf("hashing decoy password: %w", err)
+	}
+
+	h.decoyHash = decoyHash.String()
+
+	wa, err := webauthn.New(&webauthn.Config{
+		RPDisplayName: "Nibbin",
+		RPID:          cfg.WebAuthn.RPID,
+		RPOrigins:     cfg.WebAuthnOrigins(),
+	})
+
+	if err != nil {
+		return nil, fmt.Errorf("configuring WebAuthn: %w", err)
+	}
+
+	h.webauthn = wa
+
+	h.actions = map[ActionKind]actionCompleter{
+		ActionLogin:         completeWith(h.completeLogin),
+		ActionLoginAdmin:    completeWith(h.completeLoginAdmin),
+		ActionRegisterAdmin: completeWith(h.completeRegisterAdmin),
+		ActionChangeEmail:   completeWith(h.completeChangeEmail),
+	}
+
+	return h, nil
 }
@@ -0,0 +1,521 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dvher/nibbin.cl_back/internal/config"
+	"github.com/dvher/nibbin.cl_back/internal/mailer"
+	"github.com/dvher/nibbin.cl_back/internal/outbox"
+	"github.com/dvher/nibbin.cl_back/internal/ratelimit"
+	"github.com/dvher/nibbin.cl_back/internal/repository/memory"
+	"github.com/dvher/nibbin.cl_back/internal/sessionstore"
+	"github.com/dvher/nibbin.cl_back/pkg/models"
+	"github.com/dvher/nibbin.cl_back/templates"
+	"github.com/gin-contrib/sessions"
+	"github.com/gin-contrib/sessions/cookie"
+	"github.com/gin-gonic/gin"
+	"github.com/google/go-cmp/cmp"
+	gsessions "github.com/gorilla/sessions"
+)
+
+type cookieSessions struct {
+	cookie.Store
+}
+
+func (cookieSessions) Regenerate(*gsessions.Session) error                    { return nil }
+func (cookieSessions) List(user, current string) ([]sessionstore.Info, error) { return nil, nil }
+func (cookieSessions) Revoke(user string, id int) error                       { return nil }
+func (cookieSessions) RevokeAll(user string) error                            { return nil }
+func (cookieSessions) Close() error                                           { return nil }
+
+// fileSessions keeps sessions server side by ID, like the MySQL store, so
+// tests can tell whether an ID survives a login.
+type fileSessions struct {
+	*gsessions.FilesystemStore
+	cookieSessions
+}
+
+func newFileSessions(t *testing.T) fileSessions {
+	return fileSessions{FilesystemStore: gsessions.NewFilesystemStore(t.TempDir(), []byte("secret"))}
+}
+
+func (s fileSessions) Get(r *http.Request, name string) (*gsessions.Session, error) {
+	return s.FilesystemStore.Get(r, name)
+}
+
+func (s fileSessions) New(r *http.Request, name string) (*gsessions.Session, error) {
+	return s.FilesystemStore.New(r, name)
+}
+
+func (s fileSessions) Save(r *http.Request, w http.ResponseWriter, session *gsessions.Session) error {
+	return s.FilesystemStore.Save(r, w, session)
+}
+
+func (s fileSessions) Options(options sessions.Options) {
+	s.FilesystemStore.Options = options.ToGorillaOptions()
+}
+
+func (fileSessions) Regenerate(session *gsessions.Session) error {
+	session.ID = ""
+	session.IsNew = true
+
+	return nil
+}
+
+func newTestServer(t *testing.T) (*gin.Engine, *memory.Store) {
+	return newTestServerWithOTPs(t, NewMemoryOTPStore())
+}
+
+func newTestServerWithOTPs(t *testing.T, otps OTPStore) (*gin.Engine, *memory.Store) {
+	return newTestServerWith(t, otps, cookieSessions{cookie.NewStore([]byte("secret"))})
+}
+
+func newTestServerWith(t *testing.T, otps OTPStore, sess SessionStore) (*gin.Engine, *memory.Store) {
+	gin.SetMode(gin.TestMode)
+
+	store := memory.New()
+
+	tmpl, err := templates.Parse()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mail := outbox.New(outbox.NewMemoryStore(), mailer.NewRecorder(), 24*time.Hour)
+
+	t.Cleanup(func() {
+		otps.Close()
+		mail.Close()
+	})
+
+	cfg := config.Default()
+	cfg.CSRFSecret = "secret"
+	cfg.Session.Key = "secret"
+
+	r, err := New(cfg, Deps{
+		Users:       store.Users(),
+		Products:    store.Products(),
+		Favorites:   store.Favorites(),
+		Admins:      store.Admins(),
+		Credentials: store.Credentials(),
+		Tokens:      store.Tokens(),
+		OTPs:        otps,
+		Sessions:    sess,
+		Outbox:      mail,
+		Templates:   tmpl,
+	})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return r, store
+}
+
+func TestGetProducts(t *testing.T) {
+	r, store := newTestServer(t)
+
+	ctx := context.Background()
+
+	for _, name := range []string{"Shampoo", "Acondicionador"} {
+		_, err := store.Products().Create(ctx, models.Producto{Nombre: name, Marca: "Nibbin", Stock: 1})
+
+		if err != nil {
+			t.Error(err)
+			return
+		}
+	}
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/product", nil))
+
+	if w.Code != http.StatusOK {
+		t.Errorf("Got status %d when should be %d\n", w.Code, http.StatusOK)
+		return
+	}
+
+	var body struct {
+		Products []models.DescProducto `json:"products"`
+	}
+
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+		t.Error(err)
+		return
+	}
+
+	var got []string
+
+	for _, p := range body.Products {
+		got = append(got, p.Nombre)
+	}
+
+	if want := []string{"Shampoo", "Acondicionador"}; !cmp.Equal(got, want) {
+		t.Errorf("Got products %v when should be %v\n", got, want)
+	}
+}
+
+func TestSearchProducts(t *testing.T) {
+	r, store := newTestServer(t)
+
+	ctx := context.Background()
+
+	for _, name := range []string{"Shampoo", "Acondicionador"} {
+		_, err := store.Products().Create(ctx, models.Producto{Nombre: name, Marca: "Nibbin", Stock: 1})
+
+		if err != nil {
+			t.Error(err)
+			return
+		}
+	}
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/search/product/sham", nil))
+
+	var body struct {
+		Products []models.DescProducto `json:"products"`
+	}
+
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if len(body.Products) != 1 || body.Products[0].Nombre != "Shampoo" {
+		t.Errorf("Got products %v when should be only Shampoo\n", body.Products)
+	}
+}
+
+func TestGetProduct(t *testing.T) {
+	r, store := newTestServer(t)
+
+	want := models.DescProducto{
+		ID:          1,
+		Nombre:      "Shampoo",
+		Marca:       "Nibbin",
+		Descripcion: "Shampoo sólido",
+		Precio:      5990,
+		Descuento:   0.1,
+		Stock:       3,
+		Imagen:      "shampoo.png",
+	}
+
+	_, err := store.Products().Create(context.Background(), models.Producto{
+		Nombre:      want.Nombre,
+		Marca:       want.Marca,
+		Descripcion: want.Descripcion,
+		Precio:      want.Precio,
+		Descuento:   want.Descuento,
+		Stock:       want.Stock,
+		Imagen:      want.Imagen,
+	})
+
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/product/1", nil))
+
+	if w.Code != http.StatusOK {
+		t.Errorf("Got status %d when should be %d\n", w.Code, http.StatusOK)
+		return
+	}
+
+	var body struct {
+		Product models.DescProducto `json:"product"`
+	}
+
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if diff := cmp.Diff(want, body.Product); diff != "" {
+		t.Errorf("Got product diff %s\n", diff)
+	}
+
+	for path, code := range map[string]int{
+		"/product/2":   http.StatusNotFound,
+		"/product/abc": http.StatusBadRequest,
+		"/product/0":   http.StatusBadRequest,
+	} {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+
+		if w.Code != code {
+			t.Errorf("Got status %d for %s when should be %d\n", w.Code, path, code)
+		}
+	}
+}
+
+func TestRegisterRegeneratesSession(t *testing.T) {
+	r, _ := newTestServerWith(t, NewMemoryOTPStore(), newFileSessions(t))
+
+	do := func(method, path, body string, cookies []*http.Cookie) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+
+		for _, cookie := range cookies {
+			req.AddCookie(cookie)
+		}
+
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+
+		return w
+	}
+
+	planted := do(http.MethodGet, "/islogged", "", nil).Result().Cookies()
+
+	w := do(http.MethodPost, "/register", `{"nombre": "Ana", "apellido": "Rojas", "email": "ana@nibbin.cl", "user": "ana",
+		"direccion": "Calle 1", "telefono": "123", "nacimiento": "2000-01-01"}`, planted)
+
+	if w.Code != http.StatusOK {
+		t.Errorf("Got status %d registering when should be %d: %s\n", w.Code, http.StatusOK, w.Body)
+		return
+	}
+
+	issued := w.Result().Cookies()
+
+	if len(issued) == 0 {
+		t.Error("Got no session cookie after registering\n")
+		return
+	}
+
+	issued = issued[len(issued)-1:]
+
+	if body := do(http.MethodGet, "/islogged", "", issued).Body.String(); !strings.Contains(body, `"Logged"`) {
+		t.Errorf("Got %s with the new session when should be logged in\n", body)
+	}
+
+	if body := do(http.MethodGet, "/islogged", "", planted).Body.String(); !strings.Contains(body, `"Not logged"`) {
+		t.Errorf("Got %s with the session from before registering when should not be logged in\n", body)
+	}
+}
+
+func TestAdminRequiresSession(t *testing.T) {
+	r, _ := newTestServer(t)
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/admin/roles", nil))
+
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("Got status %d when should be %d\n", w.Code, http.StatusUnauthorized)
+	}
+}
+
+func TestOTPResendCooldown(t *testing.T) {
+	r, _ := newTestServer(t)
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/islogged", nil))
+
+	cookies := w.Result().Cookies()
+
+	post := func(path, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+
+		for _, cookie := range cookies {
+			req.AddCookie(cookie)
+		}
+
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+
+		return w
+	}
+
+	// Without a pending code the answer is the same as with one.
+	if w := post("/otp/resend", `{"email": "nobody@nibbin.cl"}`); w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "Email sent") {
+		t.Errorf("Got %d %s without a pending code when should be %d Email sent\n", w.Code, w.Body, http.StatusOK)
+	}
+
+	if w := post("/login", `{"email": "user@nibbin.cl"}`); w.Code != http.StatusOK {
+		t.Errorf("Got status %d when should be %d: %s\n", w.Code, http.StatusOK, w.Body)
+		return
+	}
+
+	w = post("/otp/resend", `{"email": "user@nibbin.cl"}`)
+
+	if w.Code != http.StatusTooManyRequests {
+		t.Errorf("Got status %d when should be %d\n", w.Code, http.StatusTooManyRequests)
+		return
+	}
+
+	if retry := w.Header().Get("Retry-After"); retry == "" || retry == "0" {
+		t.Errorf("Got Retry-After %q when should be the seconds left\n", retry)
+	}
+}
+
+func TestOTPEmailCase(t *testing.T) {
+	otps := NewMemoryOTPStore()
+	r, _ := newTestServerWithOTPs(t, otps)
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/islogged", nil))
+
+	cookies := w.Result().Cookies()
+
+	post := func(path, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+
+		for _, cookie := range cookies {
+			req.AddCookie(cookie)
+		}
+
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+
+		return w
+	}
+
+	if w := post("/login", `{"email": "User@Nibbin.cl"}`); w.Code != http.StatusOK {
+		t.Errorf("Got status %d when should be %d: %s\n", w.Code, http.StatusOK, w.Body)
+		return
+	}
+
+	otp, err := otps.Get("user@nibbin.cl")
+
+	if err != nil {
+		t.Errorf("Got error %v looking up the code by the normalized email\n", err)
+		return
+	}
+
+	w = post("/verify", `{"email": "USER@nibbin.cl", "otp": "`+otp.Code.String()+`"}`)
+
+	if w.Code != http.StatusOK {
+		t.Errorf("Got status %d verifying with another case when should be %d: %s\n", w.Code, http.StatusOK, w.Body)
+	}
+}
+
+func TestChangeEmailTaken(t *testing.T) {
+	otps := NewMemoryOTPStore()
+	r, store := newTestServerWithOTPs(t, otps)
+
+	err := store.Users().Create(context.Background(), models.Usuario{Nombre: "Ana", Email: "ana@nibbin.cl", User: "ana"})
+
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/islogged", nil))
+
+	cookies := w.Result().Cookies()
+
+	do := func(method, path, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+
+		for _, cookie := range cookies {
+			req.AddCookie(cookie)
+		}
+
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+
+		if set := w.Result().Cookies(); len(set) > 0 {
+			cookies = set[len(set)-1:]
+		}
+
+		return w
+	}
+
+	w = do(http.MethodPost, "/register", `{"nombre": "Bea", "apellido": "Soto", "email": "bea@nibbin.cl", "user": "bea",
+		"direccion": "Calle 2", "telefono": "456", "nacimiento": "2000-01-01"}`)
+
+	if w.Code != http.StatusOK {
+		t.Errorf("Got status %d registering when should be %d: %s\n", w.Code, http.StatusOK, w.Body)
+		return
+	}
+
+	if w := do(http.MethodPut, "/email", `{"email": "ana@nibbin.cl"}`); w.Code != http.StatusConflict {
+		t.Errorf("Got status %d changing to a taken email when should be %d: %s\n", w.Code, http.StatusConflict, w.Body)
+	}
+
+	if _, err := otps.Get("ana@nibbin.cl"); !errors.Is(err, ErrOTPNotFound) {
+		t.Errorf("Got error %v looking up a code for the taken email when should be %v\n", err, ErrOTPNotFound)
+	}
+
+	if w := do(http.MethodPut, "/email", `{"email": "bea.soto@nibbin.cl"}`); w.Code != http.StatusOK {
+		t.Errorf("Got status %d changing to a free email when should be %d: %s\n", w.Code, http.StatusOK, w.Body)
+	}
+}
+
+func TestAllowChecksEveryLimitFirst(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	email := ratelimit.New(1, time.Hour)
+	ip := ratelimit.New(1, time.Hour)
+	ip.Allow("192.0.2.1")
+
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+
+	if allow(c, []limitCheck{{email, "victim@nibbin.cl"}, {ip, "192.0.2.1"}}) {
+		t.Errorf("Got allowed when the IP is over its limit\n")
+		return
+	}
+
+	// The rejected attempt didn't use up the email's budget.
+	if ok, _ := email.Check("victim@nibbin.cl"); !ok {
+		t.Errorf("Got email limit used up by a request rejected for its IP\n")
+	}
+}
+
+// brokenConsume is an OTP store that can't delete codes.
+type brokenConsume struct {
+	OTPStore
+}
+
+func (brokenConsume) Consume(string, *big.Int) error { return errors.New("connection lost") }
+
+func TestVerifyOTPFailsClosed(t *testing.T) {
+	for _, tt := range []struct {
+		name  string
+		store OTPStore
+		codes []int
+	}{
+		{"replay", NewMemoryOTPStore(), []int{http.StatusOK, http.StatusBadRequest}},
+		{"delete fails", brokenConsume{NewMemoryOTPStore()}, []int{http.StatusInternalServerError, http.StatusInternalServerError}},
+	} {
+		r, _ := newTestServerWithOTPs(t, tt.store)
+
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/islogged", nil))
+
+		cookies := w.Result().Cookies()
+
+		if err := tt.store.Set("user@nibbin.cl", OTPData{Code: big.NewInt(123456), Action: LoginAction{}}, time.Minute); err != nil {
+			t.Error(err)
+			return
+		}
+
+		for i, want := range tt.codes {
+			req := httptest.NewRequest(http.MethodPost, "/verify", strings.NewReader(`{"email": "user@nibbin.cl", "otp": "123456"}`))
+			req.Header.Set("Content-Type", "application/json")
+
+			for _, cookie := range cookies {
+				req.AddCookie(cookie)
+			}
+
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+
+			if w.Code != want {
+				t.Errorf("%s: got status %d for use %d when should be %d: %s\n", tt.name, w.Code, i+1, want, w.Body)
+			}
+		}
+	}
+}
@@ -0,0 +1,233 @@
+package server
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/dvher/nibbin.cl_back/internal/middleware"
+	"github.com/dvher/nibbin.cl_back/internal/rbac"
+	"github.com/dvher/nibbin.cl_back/internal/repository"
+	"github.com/dvher/nibbin.cl_back/pkg/models"
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	tokenPrefix = "nbn_"
+
+	defaultTokenDays = 90
+	maxTokenDays     = 365
+)
+
+func (h *handlers) createToken(c *gin.Context) {
+
+	var data models.CreateTokenRequest
+
+	if err := c.BindJSON(&data); err != nil {
+		log.Println("Error binding json", err)
+
+		c.JSON(http.StatusBadRequest, gin.H{
+			"message": "Error binding json",
+		})
+		return
+	}
+
+	if len(data.Name) > 100 {
+		log.Println("Token name too long")
+
+		c.JSON(http.StatusBadRequest, gin.H{
+			"message": "Token name too long",
+		})
+		return
+	}
+
+	if data.ExpiresInDays == 0 {
+		data.ExpiresInDays = defaultTokenDays
+	}
+
+	if data.ExpiresInDays < 0 || data.ExpiresInDays > maxTokenDays {
+		log.Println("Invalid token expiration")
+
+		c.JSON(http.StatusBadRequest, gin.H{
+			"message": "Invalid token expiration",
+			"maxDays": maxTokenDays,
+		})
+		return
+	}
+
+	role := c.MustGet(middleware.RoleKey).(rbac.Role)
+
+	scopes := make([]rbac.Permission, 0, len(data.Scopes))
+
+	for _, scope := range data.Scopes {
+		perm := rbac.Permission(scope)
+
+		if !role.Can(perm) {
+			log.Println("Invalid token scope", scope)
+
+			c.JSON(http.StatusBadRequest, gin.H{
+				"message": "Invalid token scope",
+				"scope":   scope,
+			})
+			return
+		}
+
+		scopes = append(scopes, perm)
+	}
+
+	if len(scopes) == 0 {
+		log.Println("Token without scopes")
+
+		c.JSON(http.StatusBadRequest, gin.H{
+			"message": "At least one scope is required",
+		})
+		return
+	}
+
+	admin, err := h.Admins.Credentials(c.Request.Context(), c.GetString(middleware.UserKey))
+
+	if err != nil {
+		log.Println("Error getting admin", err)
+
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Error getting admin",
+		})
+		return
+	}
+
+	raw, err := generateToken()
+
+	if err != nil {
+		log.Println("Error generating token", err)
+
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Error generating token",
+		})
+		return
+	}
+
+	now := time.Now().UTC()
+
+	token := repository.APIToken{
+		AdminID: admin.ID,
+		Name:    data.Name,
+		Hash:    middleware.HashToken(raw),
+		Scopes:  scopes,
+		Expires: now.AddDate(0, 0, data.ExpiresInDays),
+		Created: now,
+	}
+
+	token.ID, err = h.Tokens.Create(c.Request.Context(), token)
+
+	if err != nil {
+		log.Println("Error saving token", err)
+
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Error saving token",
+		})
+		return
+	}
+
+	// The token itself is only ever shown here.
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Token created",
+		"token":   raw,
+		"info":    token,
+	})
+}
+
+func (h *handlers) listTokens(c *gin.Context) {
+
+	admin, err := h.Admins.Credentials(c.Request.Context(), c.GetString(middleware.UserKey))
+
+	if err != nil {
+		log.Println("Error getting admin", err)
+
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Error getting admin",
+		})
+		return
+	}
+
+	tokens, err := h.Tokens.List(c.Request.Context(), admin.ID)
+
+	if err != nil {
+		log.Println("Error getting tokens", err)
+
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Error getting tokens",
+		})
+		return
+	}
+
+	if tokens == nil {
+		tokens = []repository.APIToken{}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"tokens": tokens,
+	})
+}
+
+func (h *handlers) revokeToken(c *gin.Context) {
+
+	id, err := strconv.Atoi(c.Param("id"))
+
+	if err != nil {
+		log.Println("Invalid token id", err)
+
+		c.JSON(http.StatusBadRequest, gin.H{
+			"message": "Invalid token id",
+		})
+		return
+	}
+
+	admin, err := h.Admins.Credentials(c.Request.Context(), c.GetString(middleware.UserKey))
+
+	if err != nil {
+		log.Println("Error getting admin", err)
+
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Error getting admin",
+		})
+		return
+	}
+
+	err = h.Tokens.Revoke(c.Request.Context(), admin.ID, id, time.Now().UTC())
+
+	if errors.Is(err, repository.ErrNotFound) {
+		log.Println("Token not found")
+
+		c.JSON(http.StatusNotFound, gin.H{
+			"message": "Token not found",
+		})
+		return
+	}
+
+	if err != nil {
+		log.Println("Error revoking token", err)
+
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Error revoking token",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Token revoked",
+	})
+}
+
+func generateToken() (string, error) {
+	b := make([]byte, 32)
+
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+
+	return tokenPrefix + base64.RawURLEncoding.EncodeToString(b), nil
+}
@@ -0,0 +1,106 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dvher/nibbin.cl_back/internal/middleware"
+	"github.com/dvher/nibbin.cl_back/internal/rbac"
+	"github.com/dvher/nibbin.cl_back/internal/repository"
+	"github.com/dvher/nibbin.cl_back/pkg/models"
+)
+
+func TestAPIToken(t *testing.T) {
+	r, store := newTestServer(t)
+
+	ctx := context.Background()
+
+	if err := store.Users().Create(ctx, models.Usuario{User: "erp", Email: "erp@nibbin.cl"}); err != nil {
+		t.Error(err)
+		return
+	}
+
+	userID, _ := store.Users().IDByUsername(ctx, "erp")
+
+	if err := store.Admins().Create(ctx, userID, "hash", rbac.RoleCatalogManager); err != nil {
+		t.Error(err)
+		return
+	}
+
+	admin, _ := store.Admins().Credentials(ctx, "erp")
+
+	mint := func(raw string, scopes ...rbac.Permission) int {
+		id, err := store.Tokens().Create(ctx, repository.APIToken{
+			AdminID: admin.ID,
+			Name:    raw,
+			Hash:    middleware.HashToken(raw),
+			Scopes:  scopes,
+			Expires: time.Now().Add(time.Hour),
+			Created: time.Now(),
+		})
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return id
+	}
+
+	writer := mint("nbn_writer", rbac.PermProductWrite)
+	mint("nbn_reader", rbac.PermProductRead)
+
+	do := func(method, path, token string) int {
+		req := httptest.NewRequest(method, path, strings.NewReader(`{"nombre": "Shampoo", "marca": "Nibbin", "descripcion": "300 ml", "precio": 5990, "descuento": 0.1, "stock": 5, "imagen": "shampoo.png"}`))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "Bearer "+token)
+
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+
+		return w.Code
+	}
+
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		token  string
+		want   int
+	}{
+		{"scoped", http.MethodPost, "/admin/product", "nbn_writer", http.StatusOK},
+		{"missing scope", http.MethodPost, "/admin/product", "nbn_reader", http.StatusForbidden},
+		{"unknown", http.MethodPost, "/admin/product", "nbn_unknown", http.StatusUnauthorized},
+		{"token management", http.MethodGet, "/admin/tokens", "nbn_writer", http.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		if got := do(tt.method, tt.path, tt.token); got != tt.want {
+			t.Errorf("%s: got status %d when should be %d\n", tt.name, got, tt.want)
+		}
+	}
+
+	// A bearer header doesn't exempt the public admin login from CSRF.
+	req := httptest.NewRequest(http.MethodPost, "/admin/login", strings.NewReader(`{"user": "erp", "password": "secret"}`))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer nbn_writer")
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+
+	if !strings.Contains(w.Body.String(), "CSRF token mismatch") {
+		t.Errorf("Got %d %s for the admin login with a token when should be a CSRF mismatch\n", w.Code, w.Body)
+	}
+
+	if err := store.Tokens().Revoke(ctx, admin.ID, writer, time.Now()); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if got := do(http.MethodPost, "/admin/product", "nbn_writer"); got != http.StatusUnauthorized {
+		t.Errorf("Got status %d with a revoked token when should be %d\n", got, http.StatusUnauthorized)
+	}
+}
@@ -0,0 +1,395 @@
+package server
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base32"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/dvher/nibbin.cl_back/internal/repository"
+	"github.com/dvher/nibbin.cl_back/pkg/models"
+	"github.com/dvher/nibbin.cl_back/pkg/totp"
+	"github.com/gin-contrib/sessions"
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	totpIssuer        = "Nibbin"
+	recoveryCodeCount = 10
+)
+
+// When an admin has enrolled an authenticator app, its code replaces the
+// emailed one at login. Recovery codes are shown once at enrollment and
+// only their argon2 hashes are stored.
+
+func (h *handlers) loginAdminTOTP(c *gin.Context, admin models.Admin, data models.LoginAdminRequest) {
+
+	if data.TOTP == "" && data.RecoveryCode == "" {
+		log.Println("TOTP code not provided")
+
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"message":      "TOTP code required",
+			"totpRequired": true,
+		})
+		return
+	}
+
+	if !h.allowOTPVerify(c, admin.Email) {
+		return
+	}
+
+	ok, err := h.verifySecondFactor(c.Request.Context(), admin.User, data.TOTP, data.RecoveryCode)
+
+	if err != nil {
+		log.Println("Error verifying TOTP", err)
+
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Error verifying TOTP",
+		})
+		return
+	}
+
+	if !ok {
+		log.Println("Invalid TOTP code")
+
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"message": "Invalid code",
+		})
+		return
+	}
+
+	h.completeLoginAdmin(c, admin.Email, LoginAdminAction{User: admin.User})
+}
+
+func (h *handlers) enrollTOTP(c *gin.Context) {
+
+	sess := sessions.Default(c)
+
+	user, _ := sess.Get("user").(string)
+	email, _ := sess.Get("email").(string)
+
+	var data models.TOTPRequest
+
+	if err := c.ShouldBindJSON(&data); err != nil && !errors.Is(err, io.EOF) {
+		log.Println("Error binding json", err)
+
+		c.JSON(http.StatusBadRequest, gin.H{
+			"message": "Error binding json",
+		})
+		return
+	}
+
+	state, err := h.Admins.TOTP(c.Request.Context(), user)
+
+	if err != nil {
+		log.Println("Error querying TOTP", err)
+
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Error querying TOTP",
+		})
+		return
+	}
+
+	// Replacing an active authenticator takes a code from it, or a
+	// recovery code, not just the session. Confirming needs the pending
+	// secret this returns, so it is covered too.
+	if state.Secret != "" {
+		if !h.allowOTPVerify(c, email) {
+			return
+		}
+
+		ok, err := h.verifySecondFactor(c.Request.Context(), user, data.Code, data.RecoveryCode)
+
+		if err != nil {
+			log.Println("Error verifying TOTP", err)
+
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"message": "Error verifying TOTP",
+			})
+			return
+		}
+
+		if !ok {
+			log.Println("Invalid TOTP code")
+
+			c.JSON(http.StatusUnauthorized, gin.H{
+				"message":      "Current TOTP code required",
+				"totpRequired": true,
+			})
+			return
+		}
+	}
+
+	secret, err := totp.GenerateSecret()
+
+	if err != nil {
+		log.Println("Error generating TOTP secret", err)
+
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Error generating TOTP secret",
+		})
+		return
+	}
+
+	if err := h.Admins.SetPendingTOTP(c.Request.Context(), user, secret); err != nil {
+		log.Println("Error saving TOTP secret", err)
+
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Error saving TOTP secret",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Confirm with a code from the authenticator app",
+		"secret":  secret,
+		"uri":     totp.URI(totpIssuer, email, secret),
+	})
+}
+
+func (h *handlers) confirmTOTP(c *gin.Context) {
+
+	sess := sessions.Default(c)
+
+	user, _ := sess.Get("user").(string)
+	email, _ := sess.Get("email").(string)
+
+	var data models.TOTPRequest
+
+	if err := c.BindJSON(&data); err != nil {
+		log.Println("Error binding json", err)
+
+		c.JSON(http.StatusBadRequest, gin.H{
+			"message": "Error binding json",
+		})
+		return
+	}
+
+	if !h.allowOTPVerify(c, email) {
+		return
+	}
+
+	state, err := h.Admins.TOTP(c.Request.Context(), user)
+
+	if err != nil {
+		log.Println("Error querying TOTP", err)
+
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Error querying TOTP",
+		})
+		return
+	}
+
+	if state.Pending == "" {
+		log.Println("No pending TOTP enrollment")
+
+		c.JSON(http.StatusBadRequest, gin.H{
+			"message": "No pending enrollment",
+		})
+		return
+	}
+
+	step, ok, err := totp.Validate(state.Pending, data.Code, time.Now())
+
+	if err != nil {
+		log.Println("Error validating TOTP", err)
+
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Error validating TOTP",
+		})
+		return
+	}
+
+	if !ok {
+		log.Println("Invalid TOTP code")
+
+		c.JSON(http.StatusBadRequest, gin.H{
+			"message": "Invalid code",
+		})
+		return
+	}
+
+	codes, hashes, err := h.generateRecoveryCodes(c.Request.Context())
+
+	if err != nil {
+		log.Println("Error generating recovery codes", err)
+
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Error generating recovery codes",
+		})
+		return
+	}
+
+	if err := h.Admins.EnableTOTP(c.Request.Context(), user, step, hashes); err != nil {
+		log.Println("Error enabling TOTP", err)
+
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Error enabling TOTP",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":       "TOTP enabled",
+		"recoveryCodes": codes,
+	})
+}
+
+func (h *handlers) disableTOTP(c *gin.Context) {
+
+	sess := sessions.Default(c)
+
+	user, _ := sess.Get("user").(string)
+	email, _ := sess.Get("email").(string)
+
+	var data models.TOTPRequest
+
+	if err := c.BindJSON(&data); err != nil {
+		log.Println("Error binding json", err)
+
+		c.JSON(http.StatusBadRequest, gin.H{
+			"message": "Error binding json",
+		})
+		return
+	}
+
+	if !h.allowOTPVerify(c, email) {
+		return
+	}
+
+	ok, err := h.verifySecondFactor(c.Request.Context(), user, data.Code, data.RecoveryCode)
+
+	if err != nil {
+		log.Println("Error verifying TOTP", err)
+
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Error verifying TOTP",
+		})
+		return
+	}
+
+	if !ok {
+		log.Println("Invalid TOTP code")
+
+		c.JSON(http.StatusBadRequest, gin.H{
+			"message": "Invalid code",
+		})
+		return
+	}
+
+	if err := h.Admins.DisableTOTP(c.Request.Context(), user); err != nil {
+		log.Println("Error disabling TOTP", err)
+
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Error disabling TOTP",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "TOTP disabled",
+	})
+}
+
+// verifySecondFactor checks an authenticator code, or else a recovery
+// code. Each of them is accepted only once.
+func (h *handlers) verifySecondFactor(ctx context.Context, user, code, recoveryCode string) (bool, error) {
+	if code != "" {
+		state, err := h.Admins.TOTP(ctx, user)
+
+		if err != nil {
+			return false, err
+		}
+
+		if state.Secret == "" {
+			return false, nil
+		}
+
+		step, ok, err := totp.Validate(state.Secret, code, time.Now())
+
+		if err != nil || !ok {
+			return false, err
+		}
+
+		err = h.Admins.UseTOTPStep(ctx, user, step)
+
+		if errors.Is(err, repository.ErrNotFound) {
+			log.Println("TOTP code replayed")
+			return false, nil
+		}
+
+		return err == nil, err
+	}
+
+	if recoveryCode == "" {
+		return false, nil
+	}
+
+	codes, err := h.Admins.RecoveryCodes(ctx, user)
+
+	if err != nil {
+		return false, err
+	}
+
+	recoveryCode = normalizeRecoveryCode(recoveryCode)
+
+	for _, stored := range codes {
+		ok, _, err := h.passwords.ComparePasswordHash(ctx, recoveryCode, stored.Hash)
+
+		if err != nil {
+			return false, err
+		}
+
+		if !ok {
+			continue
+		}
+
+		err = h.Admins.UseRecoveryCode(ctx, stored.ID)
+
+		if errors.Is(err, repository.ErrNotFound) {
+			return false, nil
+		}
+
+		return err == nil, err
+	}
+
+	return false, nil
+}
+
+// generateRecoveryCodes returns the codes to show, formatted as xxxxx-xxxxx,
+// and the hashes to store.
+func (h *handlers) generateRecoveryCodes(ctx context.Context) ([]string, []string, error) {
+	codes := make([]string, recoveryCodeCount)
+	hashes := make([]string, recoveryCodeCount)
+	encoding := base32.StdEncoding.WithPadding(base32.NoPadding)
+
+	for i := range codes {
+		raw := make([]byte, 7)
+
+		if _, err := rand.Read(raw); err != nil {
+			return nil, nil, err
+		}
+
+		code := strings.ToLower(encoding.EncodeToString(raw))[:10]
+
+		hash, err := h.passwords.Hash(ctx, []byte(code), h.passwordConfig())
+
+		if err != nil {
+			return nil, nil, err
+		}
+
+		codes[i] = code[:5] + "-" + code[5:]
+		hashes[i] = hash.String()
+	}
+
+	return codes, hashes, nil
+}
+
+func normalizeRecoveryCode(code string) string {
+	return strings.ToLower(strings.NewReplacer("-", "", " ", "").Replace(code))
+}
@@ -0,0 +1,185 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dvher/nibbin.cl_back/internal/config"
+	"github.com/dvher/nibbin.cl_back/internal/rbac"
+	"github.com/dvher/nibbin.cl_back/internal/repository/memory"
+	"github.com/dvher/nibbin.cl_back/pkg/argon2"
+	"github.com/dvher/nibbin.cl_back/pkg/models"
+	"github.com/dvher/nibbin.cl_back/pkg/totp"
+	"github.com/gin-contrib/sessions"
+	"github.com/gin-contrib/sessions/cookie"
+	"github.com/gin-gonic/gin"
+)
+
+func TestVerifySecondFactor(t *testing.T) {
+	ctx := context.Background()
+	store := memory.New()
+	h, err := newHandlers(config.Default(), Deps{Users: store.Users(), Admins: store.Admins()})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := store.Users().Create(ctx, models.Usuario{User: "admin", Email: "admin@nibbin.cl"}); err != nil {
+		t.Error(err)
+		return
+	}
+
+	id, err := store.Users().IDByUsername(ctx, "admin")
+
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if err := store.Admins().Create(ctx, id, "", rbac.RoleSuperAdmin); err != nil {
+		t.Error(err)
+		return
+	}
+
+	secret, err := totp.GenerateSecret()
+
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if err := store.Admins().SetPendingTOTP(ctx, "admin", secret); err != nil {
+		t.Error(err)
+		return
+	}
+
+	hash, err := argon2.GenerateHash([]byte("abcdefghij"), argon2.DefaultConfig())
+
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	// Enrolled a couple of steps ago, so the current code hasn't been used.
+	if err := store.Admins().EnableTOTP(ctx, "admin", totp.Step(time.Now())-2, []string{hash.String()}); err != nil {
+		t.Error(err)
+		return
+	}
+
+	code, err := totp.Code(secret, time.Now())
+
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	for i, want := range []bool{true, false} {
+		ok, err := h.verifySecondFactor(ctx, "admin", code, "")
+
+		if err != nil {
+			t.Error(err)
+			return
+		}
+
+		if ok != want {
+			t.Errorf("Got %v for use %d of the same code when should be %v\n", ok, i+1, want)
+		}
+	}
+
+	for i, want := range []bool{true, false} {
+		ok, err := h.verifySecondFactor(ctx, "admin", "", "ABCDE-FGHIJ")
+
+		if err != nil {
+			t.Error(err)
+			return
+		}
+
+		if ok != want {
+			t.Errorf("Got %v for use %d of the same recovery code when should be %v\n", ok, i+1, want)
+		}
+	}
+}
+
+func TestReplaceTOTP(t *testing.T) {
+	ctx := context.Background()
+	store := memory.New()
+	h, err := newHandlers(config.Default(), Deps{Users: store.Users(), Admins: store.Admins()})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := store.Users().Create(ctx, models.Usuario{User: "admin", Email: "admin@nibbin.cl"}); err != nil {
+		t.Error(err)
+		return
+	}
+
+	id, _ := store.Users().IDByUsername(ctx, "admin")
+
+	if err := store.Admins().Create(ctx, id, "", rbac.RoleSuperAdmin); err != nil {
+		t.Error(err)
+		return
+	}
+
+	secret, _ := totp.GenerateSecret()
+
+	if err := store.Admins().SetPendingTOTP(ctx, "admin", secret); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if err := store.Admins().EnableTOTP(ctx, "admin", totp.Step(time.Now())-2, nil); err != nil {
+		t.Error(err)
+		return
+	}
+
+	gin.SetMode(gin.TestMode)
+
+	// Stands for a stolen admin session.
+	r := gin.New()
+	r.Use(sessions.Sessions("nibbinSession", cookie.NewStore([]byte("secret"))))
+	r.Use(func(c *gin.Context) {
+		sess := sessions.Default(c)
+		sess.Set("user", "admin")
+		sess.Set("email", "admin@nibbin.cl")
+	})
+	r.POST("/admin/totp", h.enrollTOTP)
+	r.POST("/admin/totp/confirm", h.confirmTOTP)
+
+	post := func(path, body string) int {
+		req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+
+		return w.Code
+	}
+
+	if code := post("/admin/totp", ""); code != http.StatusUnauthorized {
+		t.Errorf("Got status %d replacing TOTP without a code when should be %d\n", code, http.StatusUnauthorized)
+		return
+	}
+
+	code, _ := totp.Code(secret, time.Now())
+
+	if got := post("/admin/totp", `{"code": "`+code+`"}`); got != http.StatusOK {
+		t.Errorf("Got status %d replacing TOTP with a current code when should be %d\n", got, http.StatusOK)
+		return
+	}
+
+	// Guessing the new code is rate limited like any other code check.
+	got := 0
+
+	for i := 0; i <= otpVerifyLimit; i++ {
+		got = post("/admin/totp/confirm", `{"code": "000000"}`)
+	}
+
+	if got != http.StatusTooManyRequests {
+		t.Errorf("Got status %d after %d guesses when should be %d\n", got, otpVerifyLimit+1, http.StatusTooManyRequests)
+	}
+}
@@ -1,16 +1,19 @@
 package server
 
 import (
+	"errors"
 	"log"
 	"net/http"
+	"strconv"
 
-	db "github.com/dvher/nibbin.cl_back/internal/database"
+	"github.com/dvher/nibbin.cl_back/internal/repository"
+	"github.com/dvher/nibbin.cl_back/internal/sessionstore"
 	"github.com/dvher/nibbin.cl_back/pkg/models"
 	"github.com/gin-contrib/sessions"
 	"github.com/gin-gonic/gin"
 )
 
-func login(c *gin.Context) {
+func (h *handlers) login(c *gin.Context) {
 
 	var data models.LoginRequest
 
@@ -43,56 +46,204 @@ func login(c *gin.Context) {
 		return
 	}
 
-	stmt, err := db.DB.Prepare("SELECT usuario FROM Usuario WHERE email = ?")
+	if !h.allowOTPIssue(c, to) {
+		return
+	}
+
+	usuario, err := h.Users.UsernameByEmail(c.Request.Context(), to)
+
+	if err != nil && !errors.Is(err, repository.ErrNotFound) {
+		log.Println("Error querying database", err)
+
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Error querying database",
+		})
+		return
+	}
+
+	err = h.sendOTPEmail(c.Request.Context(), []string{to}, h.locale(c, to), LoginAction{User: usuario})
 
 	if err != nil {
-		log.Println("Error preparing statement", err)
+		log.Println("Error sending email", err)
 
 		c.JSON(http.StatusInternalServerError, gin.H{
-			"message": "Error preparing statement",
+			"message": "Error sending email",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Email sent",
+	})
+
+}
+
+func (h *handlers) register(c *gin.Context) {
+
+	puntos := 0
+
+	var data models.RegisterRequest
+
+	if err := c.BindJSON(&data); err != nil {
+		log.Println("Error binding json", err)
+
+		c.JSON(http.StatusBadRequest, gin.H{
+			"message": "Error binding json",
 		})
 		return
 	}
 
-	defer stmt.Close()
+	if data.Nombre == "" || data.Apellido == "" || data.Email == "" || data.User == "" || data.Direccion == "" ||
+		data.Telefono == "" || data.Nacimiento == "" {
+		log.Println("Missing data")
 
-	var usuario string
+		c.JSON(http.StatusBadRequest, gin.H{
+			"message": "Missing data",
+		})
+		return
+	}
 
-	rows, err := stmt.Query(to)
+	if !validateEmail(data.Email) {
+		log.Println("Invalid email")
+
+		c.JSON(http.StatusBadRequest, gin.H{
+			"message": "Invalid email",
+		})
+		return
+	}
+
+	locale := data.Idioma
+
+	if locale == "" {
+		locale = h.Templates.Match(c.GetHeader("Accept-Language"))
+	}
+
+	if !h.Templates.Supported(locale) {
+		log.Println("Unsupported language", locale)
+
+		c.JSON(http.StatusBadRequest, gin.H{
+			"message": "Unsupported language",
+		})
+		return
+	}
+
+	err := h.Users.Create(c.Request.Context(), models.Usuario{
+		Nombre:     data.Nombre,
+		Apellido:   data.Apellido,
+		Email:      data.Email,
+		User:       data.User,
+		Puntos:     puntos,
+		Direccion:  data.Direccion,
+		Telefono:   data.Telefono,
+		Nacimiento: data.Nacimiento,
+		Idioma:     locale,
+	})
 
 	if err != nil {
-		log.Println("Error querying database", err)
+		log.Println("Error inserting user", err)
 
 		c.JSON(http.StatusInternalServerError, gin.H{
-			"message": "Error querying database",
+			"message": "Error inserting user",
 		})
 		return
 	}
 
-	defer rows.Close()
+	if err := h.setUserSession(c, data.User, data.Email); err != nil {
+		log.Println("Error saving session", err)
 
-	if rows.Next() {
-		err = rows.Scan(&usuario)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Error saving session",
+		})
+		return
+	}
 
-		if err != nil {
-			log.Println("Error scanning rows", err)
+	c.JSON(http.StatusOK, gin.H{
+		"message": "User created",
+	})
+}
+
+func (h *handlers) logout(c *gin.Context) {
+	if err := h.endSession(c); err != nil {
+		log.Println("Error saving session", err)
 
-			c.JSON(http.StatusInternalServerError, gin.H{
-				"message": "Error scanning rows",
-			})
-			return
-		}
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Error saving session",
+		})
+		return
 	}
 
-	action := "login "
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Logged out",
+	})
+}
+
+func (h *handlers) changeEmail(c *gin.Context) {
 
-	if usuario == "" {
-		action += "unregistered"
-	} else {
-		action += usuario
+	sess := sessions.Default(c)
+
+	user, ok := sess.Get("user").(string)
+
+	if !ok {
+		log.Println("User not logged in")
+
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"message": "User not logged in",
+		})
+		return
+	}
+
+	var data models.ChangeEmailRequest
+
+	if err := c.BindJSON(&data); err != nil {
+		log.Println("Error binding json", err)
+
+		c.JSON(http.StatusBadRequest, gin.H{
+			"message": "Error binding json",
+		})
+		return
+	}
+
+	if !validateEmail(data.Email) {
+		log.Println("Invalid email")
+
+		c.JSON(http.StatusBadRequest, gin.H{
+			"message": "Invalid email",
+		})
+		return
 	}
 
-	err = sendOTPEmail([]string{to}, action)
+	if !h.allowOTPIssue(c, data.Email) {
+		return
+	}
+
+	// A code for a taken address could never complete, and would replace
+	// any login code pending for it.
+	_, err := h.Users.IDByEmail(c.Request.Context(), data.Email)
+
+	if err == nil {
+		log.Println("Email already in use")
+
+		c.JSON(http.StatusConflict, gin.H{
+			"message": "Email already in use",
+		})
+		return
+	}
+
+	if !errors.Is(err, repository.ErrNotFound) {
+		log.Println("Error querying database", err)
+
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Error querying database",
+		})
+		return
+	}
+
+	email, _ := sess.Get("email").(string)
+
+	err = h.sendOTPEmail(c.Request.Context(), []string{data.Email}, h.locale(c, email), ChangeEmailAction{
+		User:     user,
+		NewEmail: data.Email,
+	})
 
 	if err != nil {
 		log.Println("Error sending email", err)
@@ -106,16 +257,22 @@ func login(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{
 		"message": "Email sent",
 	})
-
 }
 
-func register(c *gin.Context) {
+func (h *handlers) setLocale(c *gin.Context) {
 
-	sess := sessions.Default(c)
+	user, ok := sessions.Default(c).Get("user").(string)
 
-	puntos := 0
+	if !ok {
+		log.Println("User not logged in")
 
-	var data models.RegisterRequest
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"message": "User not logged in",
+		})
+		return
+	}
+
+	var data models.LocaleRequest
 
 	if err := c.BindJSON(&data); err != nil {
 		log.Println("Error binding json", err)
@@ -126,81 +283,190 @@ func register(c *gin.Context) {
 		return
 	}
 
-	if data.Nombre == "" || data.Apellido == "" || data.Email == "" || data.User == "" || data.Direccion == "" ||
-		data.Telefono == "" || data.Nacimiento == "" {
-		log.Println("Missing data")
+	if !h.Templates.Supported(data.Idioma) {
+		log.Println("Unsupported language", data.Idioma)
 
 		c.JSON(http.StatusBadRequest, gin.H{
-			"message": "Missing data",
+			"message": "Unsupported language",
 		})
 		return
 	}
 
-	if !validateEmail(data.Email) {
-		log.Println("Invalid email")
+	if err := h.Users.SetLocale(c.Request.Context(), user, data.Idioma); err != nil {
+		log.Println("Error updating language", err)
 
-		c.JSON(http.StatusBadRequest, gin.H{
-			"message": "Invalid email",
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Error updating language",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Language updated",
+	})
+}
+
+func (h *handlers) listSessions(c *gin.Context) {
+
+	sess := sessions.Default(c)
+
+	user, ok := sess.Get("user").(string)
+
+	if !ok {
+		log.Println("User not logged in")
+
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"message": "User not logged in",
 		})
 		return
 	}
 
-	stmt, err := db.DB.Prepare(
-		"INSERT INTO Usuario(nombre, apellido, email, usuario, puntos, direccion, telefono, nacimiento) VALUES(?, ?, ?, ?, ?, ?, ?, ?);",
-	)
+	infos, err := h.Sessions.List(user, sess.ID())
 
 	if err != nil {
-		log.Println("Error preparing statement", err)
+		log.Println("Error listing sessions", err)
 
 		c.JSON(http.StatusInternalServerError, gin.H{
-			"message": "Error preparing statement",
+			"message": "Error listing sessions",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":  "Sessions retrieved",
+		"sessions": infos,
+	})
+}
+
+func (h *handlers) revokeSession(c *gin.Context) {
+
+	sess := sessions.Default(c)
+
+	user, ok := sess.Get("user").(string)
+
+	if !ok {
+		log.Println("User not logged in")
+
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"message": "User not logged in",
 		})
 		return
 	}
 
-	defer stmt.Close()
+	id, err := strconv.Atoi(c.Param("id"))
+
+	if err != nil {
+		log.Println("Invalid session ID", err)
+
+		c.JSON(http.StatusBadRequest, gin.H{
+			"message": "Invalid session ID",
+		})
+		return
+	}
 
-	_, err = stmt.Exec(data.Nombre, data.Apellido, data.Email, data.User, puntos, data.Direccion, data.Telefono, data.Nacimiento)
+	err = h.Sessions.Revoke(user, id)
+
+	if errors.Is(err, sessionstore.ErrNotFound) {
+		log.Println("Session not found")
+
+		c.JSON(http.StatusNotFound, gin.H{
+			"message": "Session not found",
+		})
+		return
+	}
 
 	if err != nil {
-		log.Println("Error inserting user", err)
+		log.Println("Error revoking session", err)
 
 		c.JSON(http.StatusInternalServerError, gin.H{
-			"message": "Error inserting user",
+			"message": "Error revoking session",
 		})
 		return
 	}
 
-	sess.Set("user", data.User)
-	sess.Set("email", data.Email)
-	if err := sess.Save(); err != nil {
-		log.Println("Error saving session", err)
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Session revoked",
+	})
+}
+
+func (h *handlers) revokeSessions(c *gin.Context) {
+
+	sess := sessions.Default(c)
+
+	user, ok := sess.Get("user").(string)
+
+	if !ok {
+		log.Println("User not logged in")
+
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"message": "User not logged in",
+		})
+		return
+	}
+
+	if err := h.Sessions.RevokeAll(user); err != nil {
+		log.Println("Error revoking sessions", err)
 
 		c.JSON(http.StatusInternalServerError, gin.H{
-			"message": "Error saving session",
+			"message": "Error revoking sessions",
 		})
 		return
 	}
 
+	if err := h.endSession(c); err != nil {
+		log.Println("Error saving session", err)
+	}
+
 	c.JSON(http.StatusOK, gin.H{
-		"message": "User created",
+		"message": "Sessions revoked",
 	})
 }
 
-func logout(c *gin.Context) {
+func (h *handlers) deleteAccount(c *gin.Context) {
+
 	sess := sessions.Default(c)
 
-	sess.Clear()
-	if err := sess.Save(); err != nil {
-		log.Println("Error saving session", err)
+	user, ok := sess.Get("user").(string)
+
+	if !ok {
+		log.Println("User not logged in")
+
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"message": "User not logged in",
+		})
+		return
+	}
+
+	if err := h.Users.Delete(c.Request.Context(), user); err != nil {
+		log.Println("Error deleting user", err)
 
 		c.JSON(http.StatusInternalServerError, gin.H{
-			"message": "Error saving session",
+			"message": "Error deleting user",
 		})
 		return
 	}
 
+	if err := h.Sessions.RevokeAll(user); err != nil {
+		log.Println("Error revoking sessions", err)
+	}
+
+	if err := h.endSession(c); err != nil {
+		log.Println("Error saving session", err)
+	}
+
 	c.JSON(http.StatusOK, gin.H{
-		"message": "Logged out",
+		"message": "Account deleted",
 	})
 }
+
+func (h *handlers) endSession(c *gin.Context) error {
+	sess := sessions.Default(c)
+
+	opts := h.sessionOptions
+	opts.MaxAge = -1
+
+	sess.Clear()
+	sess.Options(opts)
+
+	return sess.Save()
+}
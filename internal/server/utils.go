@@ -1,58 +1,49 @@
 package server
 
 import (
-	"bytes"
+	"context"
 	"crypto/rand"
-	"crypto/tls"
+	"errors"
 	"log"
 	"math/big"
 	"net/http"
 	"net/mail"
-	"os"
 	"strconv"
-	"strings"
-	"text/template"
-	"time"
 
+	"github.com/dvher/nibbin.cl_back/internal/mailer"
+	"github.com/dvher/nibbin.cl_back/internal/repository"
+	"github.com/dvher/nibbin.cl_back/pkg/argon2"
+	"github.com/dvher/nibbin.cl_back/templates"
 	"github.com/gin-contrib/sessions"
 	"github.com/gin-gonic/gin"
 	csrf "github.com/utrack/gin-csrf"
-	gomail "gopkg.in/mail.v2"
 )
 
-func sendEmail(to []string, subject, body string) error {
-	message := gomail.NewMessage()
-	from := os.Getenv("SMTP_USER")
-	password := os.Getenv("SMTP_PASS")
-
-	smtpHost := os.Getenv("SMTP_HOST")
-	smtpPort, err := strconv.Atoi(os.Getenv("SMTP_PORT"))
+// sendEmail queues the email in the outbox, it is delivered in the background.
+func (h *handlers) sendEmail(ctx context.Context, to []string, locale, template string, data any) error {
+	email, err := h.Templates.Render(locale, template, data)
 
 	if err != nil {
-		log.Println("Error converting port to int", err)
+		log.Println("Error rendering email template", err)
 		return err
 	}
 
-	message.SetHeader("From", from)
-	message.SetHeader("To", to...)
-
-	message.SetHeader("Subject", subject)
-
-	message.SetBody("text/html", body)
-
-	d := gomail.NewDialer(smtpHost, smtpPort, from, password)
-
-	d.TLSConfig = &tls.Config{InsecureSkipVerify: true}
+	err = h.Outbox.Enqueue(ctx, mailer.Message{
+		To:      to,
+		Subject: email.Subject,
+		HTML:    email.HTML,
+		Text:    email.Text,
+	})
 
-	if err := d.DialAndSend(message); err != nil {
-		log.Println("Error sending email")
+	if err != nil {
+		log.Println("Error queueing email")
 		return err
 	}
 
 	return nil
 }
 
-func sendOTPEmail(to []string, action string) error {
+func (h *handlers) sendOTPEmail(ctx context.Context, to []string, locale string, action PendingAction) error {
 
 	code, err := rand.Int(rand.Reader, big.NewInt(899999))
 
@@ -62,87 +53,67 @@ func sendOTPEmail(to []string, action string) error {
 
 	code.Add(code, big.NewInt(100000))
 
-	mailToOTP[to[0]] = OTPData{
-		Tries:  0,
+	tries := 0
+
+	// Codes are kept per mailbox, whatever the case it was typed in, so they
+	// share one slot as they share one rate limit.
+	key := normalizeEmail(to[0])
+
+	// A new code doesn't give more attempts than the one it replaces.
+	if prev, err := h.OTPs.Get(key); err == nil {
+		tries = prev.Tries
+	} else if !errors.Is(err, ErrOTPNotFound) {
+		return err
+	}
+
+	err = h.OTPs.Set(key, OTPData{
+		Tries:  tries,
 		Code:   code,
 		Action: action,
-	}
+	}, otpTTL)
 
-	if strings.HasPrefix(action, "registerAdmin") {
+	if err != nil {
+		return err
+	}
 
-		t, err := parseTemplate("register.html", struct {
+	if _, ok := action.(RegisterAdminAction); ok {
+		return h.sendEmail(ctx, []string{h.cfg.AdminEmail}, templates.DefaultLocale, "register", struct {
 			Code  *big.Int
 			Email string
 		}{
 			Code:  code,
 			Email: to[0],
 		})
-
-		if err != nil {
-			return err
-		}
-
-		admin_email := os.Getenv("ADMIN_EMAIL")
-
-		err = sendEmail([]string{admin_email}, "Registrar administrador", t)
-
-		if err != nil {
-			return err
-		}
-
-		return nil
-
 	}
 
-	t, err := parseTemplate("verification.html", struct {
+	return h.sendEmail(ctx, to, locale, "verification", struct {
 		Code *big.Int
 	}{
 		Code: code,
 	})
-
-	if err != nil {
-		return err
-	}
-
-	err = sendEmail(to, "Código de verificación", t)
-
-	if err != nil {
-		return err
-	}
-
-	timer := time.NewTimer(3 * time.Minute)
-
-	go func() {
-		select {
-		case <-timer.C:
-			delete(mailToOTP, to[0])
-			break
-		case <-mailToChan[to[0]]:
-			break
-		}
-	}()
-
-	return nil
 }
 
-func parseTemplate(templateName string, data any) (string, error) {
-	var body bytes.Buffer
+// locale returns the stored preference of the user with email, or the best
+// match for the Accept-Language header.
+func (h *handlers) locale(c *gin.Context, email string) string {
+	if email != "" {
+		locale, err := h.Users.LocaleByEmail(c.Request.Context(), email)
 
-	t, err := template.ParseFiles("templates/" + templateName)
+		if err != nil && !errors.Is(err, repository.ErrNotFound) {
+			log.Println("Error querying locale", err)
+		}
 
-	if err != nil {
-		log.Println("Error parsing email template", err)
-		return "", err
+		if h.Templates.Supported(locale) {
+			return locale
+		}
 	}
 
-	err = t.Execute(&body, data)
-
-	if err != nil {
-		log.Println("Error executing email template", err)
-		return "", err
-	}
+	return h.Templates.Match(c.GetHeader("Accept-Language"))
+}
 
-	return body.String(), nil
+// passwordConfig is the cost of new password and recovery code hashes.
+func (h *handlers) passwordConfig() *argon2.Config {
+	return h.cfg.Argon2.HashConfig()
 }
 
 func validateEmail(email string) bool {
@@ -177,3 +148,20 @@ func isLogged(c *gin.Context) {
 		"user":    sess.Get("user"),
 	})
 }
+
+// productIDParam reads the :id route parameter, answering the request itself
+// when it isn't a valid product ID.
+func productIDParam(c *gin.Context) (int, bool) {
+	id, err := strconv.Atoi(c.Param("id"))
+
+	if err != nil || id <= 0 {
+		log.Println("Invalid ID", c.Param("id"))
+
+		c.JSON(http.StatusBadRequest, gin.H{
+			"message": "Invalid ID",
+		})
+		return 0, false
+	}
+
+	return id, true
+}
@@ -0,0 +1,492 @@
+package server
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dvher/nibbin.cl_back/internal/repository"
+	"github.com/dvher/nibbin.cl_back/pkg/models"
+	"github.com/gin-contrib/sessions"
+	"github.com/gin-gonic/gin"
+	"github.com/go-webauthn/webauthn/protocol"
+	"github.com/go-webauthn/webauthn/webauthn"
+)
+
+const (
+	webauthnRegisterKey = "webauthnRegister"
+	webauthnLoginKey    = "webauthnLogin"
+	maxPasskeyNameLen   = 100
+)
+
+// webauthnUser adapts a Usuario and its stored credentials to the
+// webauthn library. The user handle is the big endian Usuario id, so it
+// leaks nothing about the account.
+type webauthnUser struct {
+	models.Usuario
+	creds []repository.Credential
+}
+
+func (u webauthnUser) WebAuthnID() []byte {
+	id := make([]byte, 8)
+	binary.BigEndian.PutUint64(id, uint64(u.ID))
+
+	return id
+}
+
+func (u webauthnUser) WebAuthnName() string        { return u.Email }
+func (u webauthnUser) WebAuthnDisplayName() string { return u.Nombre + " " + u.Apellido }
+func (u webauthnUser) WebAuthnIcon() string        { return "" }
+
+func (u webauthnUser) WebAuthnCredentials() []webauthn.Credential {
+	creds := make([]webauthn.Credential, 0, len(u.creds))
+
+	for _, cred := range u.creds {
+		transports := make([]protocol.AuthenticatorTransport, 0, len(cred.Transports))
+
+		for _, t := range cred.Transports {
+			transports = append(transports, protocol.AuthenticatorTransport(t))
+		}
+
+		creds = append(creds, webauthn.Credential{
+			ID:              cred.CredentialID,
+			PublicKey:       cred.PublicKey,
+			AttestationType: cred.AttestationType,
+			Transport:       transports,
+			Authenticator: webauthn.Authenticator{
+				AAGUID:    cred.AAGUID,
+				SignCount: cred.SignCount,
+			},
+		})
+	}
+
+	return creds
+}
+
+func (u webauthnUser) exclusions() []protocol.CredentialDescriptor {
+	var list []protocol.CredentialDescriptor
+
+	for _, cred := range u.WebAuthnCredentials() {
+		list = append(list, cred.Descriptor())
+	}
+
+	return list
+}
+
+func (u webauthnUser) stored(id []byte) (repository.Credential, bool) {
+	for _, cred := range u.creds {
+		if bytes.Equal(cred.CredentialID, id) {
+			return cred, true
+		}
+	}
+
+	return repository.Credential{}, false
+}
+
+func (h *handlers) webauthnUserByID(c *gin.Context, id int) (webauthnUser, error) {
+	user, err := h.Users.ByID(c.Request.Context(), id)
+
+	if err != nil {
+		return webauthnUser{}, err
+	}
+
+	creds, err := h.Credentials.ByUser(c.Request.Context(), id)
+
+	if err != nil {
+		return webauthnUser{}, err
+	}
+
+	return webauthnUser{Usuario: user, creds: creds}, nil
+}
+
+func (h *handlers) beginPasskeyRegistration(c *gin.Context) {
+
+	id := h.getUserID(c)
+
+	if id == 0 {
+		log.Println("User not logged in")
+
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"message": "User not logged in",
+		})
+		return
+	}
+
+	user, err := h.webauthnUserByID(c, id)
+
+	if err != nil {
+		log.Println("Error getting user", err)
+
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Error getting user",
+		})
+		return
+	}
+
+	options, session, err := h.webauthn.BeginRegistration(
+		user,
+		webauthn.WithExclusions(user.exclusions()),
+		webauthn.WithResidentKeyRequirement(protocol.ResidentKeyRequirementRequired),
+	)
+
+	if err != nil {
+		log.Println("Error starting registration", err)
+
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Error starting registration",
+		})
+		return
+	}
+
+	if err := saveCeremony(c, webauthnRegisterKey, session); err != nil {
+		log.Println("Error saving session", err)
+
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Error saving session",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, options)
+}
+
+func (h *handlers) finishPasskeyRegistration(c *gin.Context) {
+
+	id := h.getUserID(c)
+
+	if id == 0 {
+		log.Println("User not logged in")
+
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"message": "User not logged in",
+		})
+		return
+	}
+
+	session, err := takeCeremony(c, webauthnRegisterKey)
+
+	if err != nil {
+		log.Println("No registration in progress", err)
+
+		c.JSON(http.StatusBadRequest, gin.H{
+			"message": "No registration in progress",
+		})
+		return
+	}
+
+	user, err := h.webauthnUserByID(c, id)
+
+	if err != nil {
+		log.Println("Error getting user", err)
+
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Error getting user",
+		})
+		return
+	}
+
+	parsed, err := protocol.ParseCredentialCreationResponseBody(c.Request.Body)
+
+	if err != nil {
+		log.Println("Invalid credential", err)
+
+		c.JSON(http.StatusBadRequest, gin.H{
+			"message": "Invalid credential",
+		})
+		return
+	}
+
+	cred, err := h.webauthn.CreateCredential(user, session, parsed)
+
+	if err != nil {
+		log.Println("Invalid credential", err)
+
+		c.JSON(http.StatusBadRequest, gin.H{
+			"message": "Invalid credential",
+		})
+		return
+	}
+
+	transports := make([]string, 0, len(parsed.Transports))
+
+	for _, t := range parsed.Transports {
+		transports = append(transports, string(t))
+	}
+
+	name := []rune(strings.ToValidUTF8(c.Query("name"), ""))
+
+	if len(name) > maxPasskeyNameLen {
+		name = name[:maxPasskeyNameLen]
+	}
+
+	err = h.Credentials.Create(c.Request.Context(), repository.Credential{
+		UserID:          id,
+		CredentialID:    cred.ID,
+		PublicKey:       cred.PublicKey,
+		AttestationType: cred.AttestationType,
+		Transports:      transports,
+		AAGUID:          cred.Authenticator.AAGUID,
+		SignCount:       cred.Authenticator.SignCount,
+		Name:            string(name),
+		Created:         time.Now().UTC(),
+	})
+
+	if err != nil {
+		log.Println("Error saving credential", err)
+
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Error saving credential",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Passkey registered",
+	})
+}
+
+// beginPasskeyLogin always starts a discoverable login, where the browser
+// offers the passkeys it holds for the site, so the answer is the same
+// whether or not an email has an account or passkeys.
+func (h *handlers) beginPasskeyLogin(c *gin.Context) {
+
+	if !h.allowPasskeyLogin(c) {
+		return
+	}
+
+	options, session, err := h.webauthn.BeginDiscoverableLogin()
+
+	if err != nil {
+		log.Println("Error starting login", err)
+
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Error starting login",
+		})
+		return
+	}
+
+	if err := saveCeremony(c, webauthnLoginKey, session); err != nil {
+		log.Println("Error saving session", err)
+
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Error saving session",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, options)
+}
+
+func (h *handlers) finishPasskeyLogin(c *gin.Context) {
+
+	session, err := takeCeremony(c, webauthnLoginKey)
+
+	if err != nil {
+		log.Println("No login in progress", err)
+
+		c.JSON(http.StatusBadRequest, gin.H{
+			"message": "No login in progress",
+		})
+		return
+	}
+
+	parsed, err := protocol.ParseCredentialRequestResponseBody(c.Request.Body)
+
+	if err != nil {
+		log.Println("Invalid assertion", err)
+
+		c.JSON(http.StatusBadRequest, gin.H{
+			"message": "Invalid assertion",
+		})
+		return
+	}
+
+	var user webauthnUser
+
+	lookup := func(_, userHandle []byte) (webauthn.User, error) {
+		if len(userHandle) != 8 {
+			return nil, repository.ErrNotFound
+		}
+
+		found, err := h.webauthnUserByID(c, int(binary.BigEndian.Uint64(userHandle)))
+
+		if err != nil {
+			return nil, err
+		}
+
+		user = found
+
+		return found, nil
+	}
+
+	cred, err := h.webauthn.ValidateDiscoverableLogin(lookup, session, parsed)
+
+	if err != nil {
+		log.Println("Invalid assertion", err)
+
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"message": "Invalid assertion",
+		})
+		return
+	}
+
+	// A counter that went backwards means the key may have been cloned;
+	// the user can still get in with an emailed code.
+	if cred.Authenticator.CloneWarning {
+		log.Println("Possible cloned authenticator for user", user.ID)
+
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"message": "Invalid assertion",
+		})
+		return
+	}
+
+	stored, ok := user.stored(cred.ID)
+
+	if !ok {
+		log.Println("Credential not registered for user", user.ID)
+
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"message": "Invalid assertion",
+		})
+		return
+	}
+
+	if err := h.Credentials.Touch(c.Request.Context(), stored.ID, cred.Authenticator.SignCount, time.Now().UTC()); err != nil {
+		log.Println("Error updating credential", err)
+
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Error updating credential",
+		})
+		return
+	}
+
+	h.completeLogin(c, user.Email, LoginAction{User: user.User})
+}
+
+func (h *handlers) listPasskeys(c *gin.Context) {
+
+	id := h.getUserID(c)
+
+	if id == 0 {
+		log.Println("User not logged in")
+
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"message": "User not logged in",
+		})
+		return
+	}
+
+	creds, err := h.Credentials.ByUser(c.Request.Context(), id)
+
+	if err != nil {
+		log.Println("Error getting passkeys", err)
+
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Error getting passkeys",
+		})
+		return
+	}
+
+	if creds == nil {
+		creds = []repository.Credential{}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"passkeys": creds,
+	})
+}
+
+func (h *handlers) deletePasskey(c *gin.Context) {
+
+	id := h.getUserID(c)
+
+	if id == 0 {
+		log.Println("User not logged in")
+
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"message": "User not logged in",
+		})
+		return
+	}
+
+	credID, err := strconv.Atoi(c.Param("id"))
+
+	if err != nil {
+		log.Println("Invalid passkey id", err)
+
+		c.JSON(http.StatusBadRequest, gin.H{
+			"message": "Invalid passkey id",
+		})
+		return
+	}
+
+	err = h.Credentials.Delete(c.Request.Context(), id, credID)
+
+	if errors.Is(err, repository.ErrNotFound) {
+		log.Println("Passkey not found")
+
+		c.JSON(http.StatusNotFound, gin.H{
+			"message": "Passkey not found",
+		})
+		return
+	}
+
+	if err != nil {
+		log.Println("Error deleting passkey", err)
+
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Error deleting passkey",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Passkey deleted",
+	})
+}
+
+// saveCeremony keeps the challenge of a ceremony in the session until the
+// matching finish request.
+func saveCeremony(c *gin.Context, key string, data *webauthn.SessionData) error {
+	raw, err := json.Marshal(data)
+
+	if err != nil {
+		return err
+	}
+
+	sess := sessions.Default(c)
+	sess.Set(key, string(raw))
+
+	return sess.Save()
+}
+
+// takeCeremony loads and forgets the ceremony, so each challenge is
+// answered at most once.
+func takeCeremony(c *gin.Context, key string) (webauthn.SessionData, error) {
+	var data webauthn.SessionData
+
+	sess := sessions.Default(c)
+
+	raw, ok := sess.Get(key).(string)
+
+	if !ok {
+		return data, repository.ErrNotFound
+	}
+
+	sess.Delete(key)
+
+	if err := sess.Save(); err != nil {
+		return data, err
+	}
+
+	err := json.Unmarshal([]byte(raw), &data)
+
+	return data, err
+}
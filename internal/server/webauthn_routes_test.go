@@ -0,0 +1,311 @@
+package server
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/go-webauthn/webauthn/protocol/webauthncbor"
+	"github.com/go-webauthn/webauthn/protocol/webauthncose"
+)
+
+const testOrigin = "http://localhost:3000"
+
+// softAuthenticator is a platform authenticator in software holding a
+// single P-256 credential.
+type softAuthenticator struct {
+	key        *ecdsa.PrivateKey
+	id         []byte
+	userHandle []byte
+	counter    uint32
+}
+
+func newSoftAuthenticator(t *testing.T) *softAuthenticator {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	id := make([]byte, 16)
+
+	if _, err := rand.Read(id); err != nil {
+		t.Fatal(err)
+	}
+
+	return &softAuthenticator{key: key, id: id}
+}
+
+type ceremonyOptions struct {
+	PublicKey struct {
+		Challenge string `json:"challenge"`
+		User      struct {
+			ID string `json:"id"`
+		} `json:"user"`
+	} `json:"publicKey"`
+}
+
+func b64(b []byte) string {
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func (a *softAuthenticator) clientData(kind, challenge string) []byte {
+	data, _ := json.Marshal(map[string]string{
+		"type":      kind,
+		"challenge": challenge,
+		"origin":    testOrigin,
+	})
+
+	return data
+}
+
+func (a *softAuthenticator) authData(flags byte, attested []byte) []byte {
+	rpID := sha256.Sum256([]byte("localhost"))
+
+	var buf bytes.Buffer
+
+	buf.Write(rpID[:])
+	buf.WriteByte(flags)
+	binary.Write(&buf, binary.BigEndian, a.counter)
+	buf.Write(attested)
+
+	return buf.Bytes()
+}
+
+// create answers navigator.credentials.create with "none" attestation.
+func (a *softAuthenticator) create(t *testing.T, options ceremonyOptions) string {
+	userHandle, err := base64.RawURLEncoding.DecodeString(options.PublicKey.User.ID)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	a.userHandle = userHandle
+
+	pub, err := webauthncbor.Marshal(webauthncose.EC2PublicKeyData{
+		PublicKeyData: webauthncose.PublicKeyData{
+			KeyType:   int64(webauthncose.EllipticKey),
+			Algorithm: int64(webauthncose.AlgES256),
+		},
+		Curve:  1,
+		XCoord: a.key.X.FillBytes(make([]byte, 32)),
+		YCoord: a.key.Y.FillBytes(make([]byte, 32)),
+	})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var attested bytes.Buffer
+
+	attested.Write(make([]byte, 16))
+	binary.Write(&attested, binary.BigEndian, uint16(len(a.id)))
+	attested.Write(a.id)
+	attested.Write(pub)
+
+	attestation, err := webauthncbor.Marshal(map[string]any{
+		"fmt":      "none",
+		"attStmt":  map[string]any{},
+		"authData": a.authData(0x45, attested.Bytes()),
+	})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	body, _ := json.Marshal(map[string]any{
+		"id":    b64(a.id),
+		"rawId": b64(a.id),
+		"type":  "public-key",
+		"response": map[string]any{
+			"clientDataJSON":    b64(a.clientData("webauthn.create", options.PublicKey.Challenge)),
+			"attestationObject": b64(attestation),
+			"transports":        []string{"internal"},
+		},
+	})
+
+	return string(body)
+}
+
+// get answers navigator.credentials.get.
+func (a *softAuthenticator) get(t *testing.T, options ceremonyOptions) string {
+	a.counter++
+
+	authData := a.authData(0x05, nil)
+	clientData := a.clientData("webauthn.get", options.PublicKey.Challenge)
+	clientHash := sha256.Sum256(clientData)
+	digest := sha256.Sum256(append(authData, clientHash[:]...))
+
+	sig, err := ecdsa.SignASN1(rand.Reader, a.key, digest[:])
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	body, _ := json.Marshal(map[string]any{
+		"id":    b64(a.id),
+		"rawId": b64(a.id),
+		"type":  "public-key",
+		"response": map[string]any{
+			"clientDataJSON":    b64(clientData),
+			"authenticatorData": b64(authData),
+			"signature":         b64(sig),
+			"userHandle":        b64(a.userHandle),
+		},
+	})
+
+	return string(body)
+}
+
+func TestPasskeyLogin(t *testing.T) {
+	r, _ := newTestServer(t)
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/islogged", nil))
+
+	cookies := w.Result().Cookies()
+
+	do := func(method, path, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+
+		for _, cookie := range cookies {
+			req.AddCookie(cookie)
+		}
+
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+
+		// A handler may save the session more than once, the last cookie wins.
+		if set := w.Result().Cookies(); len(set) > 0 {
+			cookies = set[len(set)-1:]
+		}
+
+		return w
+	}
+
+	options := func(w *httptest.ResponseRecorder) ceremonyOptions {
+		var opts ceremonyOptions
+
+		if err := json.Unmarshal(w.Body.Bytes(), &opts); err != nil {
+			t.Fatal(err)
+		}
+
+		return opts
+	}
+
+	w = do(http.MethodPost, "/register", `{"nombre": "Ana", "apellido": "Rojas", "email": "ana@nibbin.cl", "user": "ana",
+		"direccion": "Calle 1", "telefono": "123", "nacimiento": "2000-01-01"}`)
+
+	if w.Code != http.StatusOK {
+		t.Errorf("Got status %d registering when should be %d: %s\n", w.Code, http.StatusOK, w.Body)
+		return
+	}
+
+	auth := newSoftAuthenticator(t)
+
+	if w = do(http.MethodPost, "/webauthn/register/begin", ""); w.Code != http.StatusOK {
+		t.Errorf("Got status %d when should be %d: %s\n", w.Code, http.StatusOK, w.Body)
+		return
+	}
+
+	// Long names are cut to 100 characters, not bytes.
+	name := "Laptop" + strings.Repeat("ñ", 120)
+
+	w = do(http.MethodPost, "/webauthn/register/finish?name="+url.QueryEscape(name), auth.create(t, options(w)))
+
+	if w.Code != http.StatusOK {
+		t.Errorf("Got status %d when should be %d: %s\n", w.Code, http.StatusOK, w.Body)
+		return
+	}
+
+	if w = do(http.MethodDelete, "/logout", ""); w.Code != http.StatusOK {
+		t.Errorf("Got status %d logging out when should be %d\n", w.Code, http.StatusOK)
+		return
+	}
+
+	do(http.MethodGet, "/islogged", "")
+
+	if w = do(http.MethodPost, "/webauthn/login/begin", ""); w.Code != http.StatusOK {
+		t.Errorf("Got status %d when should be %d: %s\n", w.Code, http.StatusOK, w.Body)
+		return
+	}
+
+	assertion := auth.get(t, options(w))
+
+	if w = do(http.MethodPost, "/webauthn/login/finish", assertion); w.Code != http.StatusOK {
+		t.Errorf("Got status %d when should be %d: %s\n", w.Code, http.StatusOK, w.Body)
+		return
+	}
+
+	if w = do(http.MethodPost, "/webauthn/login/finish", assertion); w.Code != http.StatusBadRequest {
+		t.Errorf("Got status %d replaying the assertion when should be %d\n", w.Code, http.StatusBadRequest)
+	}
+
+	w = do(http.MethodGet, "/islogged", "")
+
+	if !strings.Contains(w.Body.String(), `"user":"ana"`) {
+		t.Errorf("Got %s when should be logged in as ana\n", w.Body)
+	}
+
+	w = do(http.MethodGet, "/webauthn/credentials", "")
+
+	if want := `"name":"Laptop` + strings.Repeat("ñ", 94) + `"`; !strings.Contains(w.Body.String(), want) {
+		t.Errorf("Got %s when should list the passkey as %s\n", w.Body, want)
+	}
+}
+
+func TestPasskeyLoginBegin(t *testing.T) {
+	r, _ := newTestServer(t)
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/islogged", nil))
+
+	cookies := w.Result().Cookies()
+
+	begin := func(body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/webauthn/login/begin", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+
+		for _, cookie := range cookies {
+			req.AddCookie(cookie)
+		}
+
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+
+		return w
+	}
+
+	// An unknown email gets the same discoverable options as anyone else.
+	w = begin(`{"email": "nobody@nibbin.cl"}`)
+
+	var opts ceremonyOptions
+
+	if err := json.Unmarshal(w.Body.Bytes(), &opts); w.Code != http.StatusOK || err != nil {
+		t.Errorf("Got %d %s for an unknown email when should be %d with options\n", w.Code, w.Body, http.StatusOK)
+		return
+	}
+
+	if strings.Contains(w.Body.String(), "allowCredentials") {
+		t.Errorf("Got %s when should not list credentials\n", w.Body)
+	}
+
+	for i := 1; i < passkeyLoginIPLimit; i++ {
+		begin("")
+	}
+
+	if w = begin(""); w.Code != http.StatusTooManyRequests {
+		t.Errorf("Got status %d over the IP limit when should be %d\n", w.Code, http.StatusTooManyRequests)
+	}
+}
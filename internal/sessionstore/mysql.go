@@ -0,0 +1,353 @@
+package sessionstore
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base32"
+	"encoding/hex"
+	"errors"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gin-contrib/sessions"
+	"github.com/gorilla/securecookie"
+	gsessions "github.com/gorilla/sessions"
+)
+
+const (
+	purgeEvery    = 10 * time.Minute
+	touchEvery    = time.Minute
+	maxDeviceLen  = 255
+	defaultMaxAge = 86400
+)
+
+var ErrNotFound = errors.New("session not found")
+
+/*
+* The Sesion table is created by internal/database/migrations/0003_sesion.up.sql.
+*
+* Only a SHA-256 of the session ID is stored, so a leaked table can't be
+* used to hijack sessions.
+ */
+
+// Info describes a live session as shown to its owner.
+type Info struct {
+	ID       int       `json:"id"`
+	Device   string    `json:"device"`
+	IP       string    `json:"ip"`
+	Created  time.Time `json:"created"`
+	LastSeen time.Time `json:"lastSeen"`
+	Current  bool      `json:"current"`
+}
+
+type MySQLStore struct {
+	Codecs  []securecookie.Codec
+	options *gsessions.Options
+	db      *sql.DB
+	done    chan struct{}
+	once    sync.Once
+	wg      sync.WaitGroup
+}
+
+func NewMySQLStore(db *sql.DB, keyPairs ...[]byte) *MySQLStore {
+	s := &MySQLStore{
+		Codecs: securecookie.CodecsFromPairs(keyPairs...),
+		options: &gsessions.Options{
+			Path:   "/",
+			MaxAge: defaultMaxAge,
+		},
+		db:   db,
+		done: make(chan struct{}),
+	}
+
+	s.wg.Add(1)
+	go s.purge()
+
+	return s
+}
+
+func (s *MySQLStore) Options(options sessions.Options) {
+	s.options = options.ToGorillaOptions()
+
+	for _, codec := range s.Codecs {
+		if sc, ok := codec.(*securecookie.SecureCookie); ok {
+			sc.MaxAge(options.MaxAge)
+		}
+	}
+}
+
+func (s *MySQLStore) Get(r *http.Request, name string) (*gsessions.Session, error) {
+	return gsessions.GetRegistry(r).Get(s, name)
+}
+
+func (s *MySQLStore) New(r *http.Request, name string) (*gsessions.Session, error) {
+	session := gsessions.NewSession(s, name)
+	opts := *s.options
+	session.Options = &opts
+	session.IsNew = true
+
+	c, err := r.Cookie(name)
+
+	if err != nil {
+		return session, nil
+	}
+
+	if err := securecookie.DecodeMulti(name, c.Value, &session.ID, s.Codecs...); err != nil {
+		session.ID = ""
+		return session, nil
+	}
+
+	err = s.load(session)
+
+	if errors.Is(err, ErrNotFound) {
+		session.ID = ""
+		return session, nil
+	}
+
+	if err != nil {
+		return session, err
+	}
+
+	session.IsNew = false
+
+	return session, nil
+}
+
+func (s *MySQLStore) Save(r *http.Request, w http.ResponseWriter, session *gsessions.Session) error {
+
+	if session.Options.MaxAge < 0 {
+		if session.ID != "" {
+			if _, err := s.db.Exec("DELETE FROM Sesion WHERE token = ?;", hashID(session.ID)); err != nil {
+				return err
+			}
+		}
+
+		http.SetCookie(w, gsessions.NewCookie(session.Name(), "", session.Options))
+		return nil
+	}
+
+	if session.ID == "" {
+		session.ID = strings.TrimRight(base32.StdEncoding.EncodeToString(securecookie.GenerateRandomKey(32)), "=")
+	}
+
+	data, err := securecookie.EncodeMulti(session.Name(), session.Values, s.Codecs...)
+
+	if err != nil {
+		return err
+	}
+
+	var user sql.NullString
+
+	if u, ok := session.Values["user"].(string); ok && u != "" {
+		user = sql.NullString{String: u, Valid: true}
+	}
+
+	now := time.Now().UTC()
+	expires := now.Add(time.Duration(s.maxAge(session)) * time.Second)
+
+	_, err = s.db.Exec(
+		"INSERT INTO Sesion (token, usuario, datos, dispositivo, ip, creado, visto, expira) VALUES (?, ?, ?, ?, ?, ?, ?, ?) "+
+			"ON DUPLICATE KEY UPDATE usuario = VALUES(usuario), datos = VALUES(datos), dispositivo = VALUES(dispositivo), "+
+			"ip = VALUES(ip), visto = VALUES(visto), expira = VALUES(expira);",
+		hashID(session.ID), user, data, device(r), clientIP(r), now, now, expires,
+	)
+
+	if err != nil {
+		return err
+	}
+
+	encoded, err := securecookie.EncodeMulti(session.Name(), session.ID, s.Codecs...)
+
+	if err != nil {
+		return err
+	}
+
+	http.SetCookie(w, gsessions.NewCookie(session.Name(), encoded, session.Options))
+
+	return nil
+}
+
+// maxAge is how long the row of session is kept. A MaxAge of 0 only makes
+// the cookie last as long as the browser, the row still needs an expiry.
+func (s *MySQLStore) maxAge(session *gsessions.Session) int {
+	if session.Options.MaxAge > 0 {
+		return session.Options.MaxAge
+	}
+
+	if s.options.MaxAge > 0 {
+		return s.options.MaxAge
+	}
+
+	return defaultMaxAge
+}
+
+// Regenerate drops the stored row of session and clears its ID, so the next
+// Save issues a new one. Call it whenever the session gains privileges, so a
+// session ID planted or read before login isn't promoted with it.
+func (s *MySQLStore) Regenerate(session *gsessions.Session) error {
+	if session.ID == "" {
+		return nil
+	}
+
+	if _, err := s.db.Exec("DELETE FROM Sesion WHERE token = ?;", hashID(session.ID)); err != nil {
+		return err
+	}
+
+	session.ID = ""
+	session.IsNew = true
+
+	return nil
+}
+
+// List returns the live sessions of user, flagging the one whose ID is
+// current.
+func (s *MySQLStore) List(user, current string) ([]Info, error) {
+	rows, err := s.db.Query(
+		"SELECT id, token, dispositivo, ip, creado, visto FROM Sesion WHERE usuario = ? AND expira > ? ORDER BY visto DESC;",
+		user, time.Now().UTC(),
+	)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	currentToken := hashID(current)
+
+	var infos []Info
+
+	for rows.Next() {
+		var info Info
+		var token string
+
+		if err := rows.Scan(&info.ID, &token, &info.Device, &info.IP, &info.Created, &info.LastSeen); err != nil {
+			return nil, err
+		}
+
+		info.Current = current != "" && token == currentToken
+
+		infos = append(infos, info)
+	}
+
+	return infos, rows.Err()
+}
+
+// Revoke deletes the session with the given row ID if it belongs to user.
+func (s *MySQLStore) Revoke(user string, id int) error {
+	res, err := s.db.Exec("DELETE FROM Sesion WHERE id = ? AND usuario = ?;", id, user)
+
+	if err != nil {
+		return err
+	}
+
+	n, err := res.RowsAffected()
+
+	if err != nil {
+		return err
+	}
+
+	if n == 0 {
+		return ErrNotFound
+	}
+
+	return nil
+}
+
+// RevokeAll deletes every session of user.
+func (s *MySQLStore) RevokeAll(user string) error {
+	_, err := s.db.Exec("DELETE FROM Sesion WHERE usuario = ?;", user)
+
+	return err
+}
+
+func (s *MySQLStore) Close() error {
+	s.once.Do(func() {
+		close(s.done)
+	})
+
+	s.wg.Wait()
+
+	return nil
+}
+
+func (s *MySQLStore) load(session *gsessions.Session) error {
+	token := hashID(session.ID)
+	now := time.Now().UTC()
+
+	var data string
+
+	err := s.db.QueryRow("SELECT datos FROM Sesion WHERE token = ? AND expira > ?;", token, now).Scan(&data)
+
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrNotFound
+	}
+
+	if err != nil {
+		return err
+	}
+
+	if err := securecookie.DecodeMulti(session.Name(), data, &session.Values, s.Codecs...); err != nil {
+		return ErrNotFound
+	}
+
+	_, err = s.db.Exec("UPDATE Sesion SET visto = ? WHERE token = ? AND visto < ?;", now, token, now.Add(-touchEvery))
+
+	return err
+}
+
+func (s *MySQLStore) purge() {
+	defer s.wg.Done()
+
+	ticker := time.NewTicker(purgeEvery)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.done:
+			return
+		case now := <-ticker.C:
+			if _, err := s.db.Exec("DELETE FROM Sesion WHERE expira <= ?;", now.UTC()); err != nil {
+				log.Println("Error purging expired sessions", err)
+			}
+		}
+	}
+}
+
+func hashID(id string) string {
+	sum := sha256.Sum256([]byte(id))
+
+	return hex.EncodeToString(sum[:])
+}
+
+func device(r *http.Request) string {
+	ua := strings.ToValidUTF8(r.UserAgent(), "")
+
+	if len(ua) <= maxDeviceLen {
+		return ua
+	}
+
+	// Cut on a rune boundary so the column never holds half a character.
+	n := maxDeviceLen
+
+	for n > 0 && !utf8.RuneStart(ua[n]) {
+		n--
+	}
+
+	return ua[:n]
+}
+
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+
+	if err != nil {
+		return r.RemoteAddr
+	}
+
+	return host
+}
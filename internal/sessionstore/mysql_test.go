@@ -0,0 +1,330 @@
+package sessionstore
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"io"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+	"unicode/utf8"
+
+	"github.com/google/go-cmp/cmp"
+	gsessions "github.com/gorilla/sessions"
+)
+
+// expectation is one statement the store is expected to run, in order.
+type expectation struct {
+	query    string
+	args     []driver.Value
+	columns  []string
+	rows     [][]driver.Value
+	affected int64
+}
+
+// anyArg matches any argument, for timestamps taken inside the store.
+type anyArg struct{}
+
+// argFunc matches the arguments it returns true for.
+type argFunc func(driver.Value) bool
+
+type script struct {
+	mu    sync.Mutex
+	steps []expectation
+}
+
+var (
+	scriptsMu sync.Mutex
+	scripts   = map[string]*script{}
+)
+
+func init() {
+	sql.Register("sessionstore_test", scriptDriver{})
+}
+
+type scriptDriver struct{}
+
+func (scriptDriver) Open(name string) (driver.Conn, error) {
+	scriptsMu.Lock()
+	defer scriptsMu.Unlock()
+
+	return scriptConn{scripts[name]}, nil
+}
+
+type scriptConn struct {
+	s *script
+}
+
+func (c scriptConn) Prepare(string) (driver.Stmt, error) { return nil, errors.New("not supported") }
+func (c scriptConn) Close() error                        { return nil }
+func (c scriptConn) Begin() (driver.Tx, error)           { return nil, errors.New("not supported") }
+
+func (c scriptConn) QueryContext(_ context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	step, err := c.s.next(query, args)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &scriptRows{columns: step.columns, rows: step.rows}, nil
+}
+
+func (c scriptConn) ExecContext(_ context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	step, err := c.s.next(query, args)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return driver.RowsAffected(step.affected), nil
+}
+
+func (s *script) next(query string, args []driver.NamedValue) (expectation, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if len(s.steps) == 0 {
+		return expectation{}, fmt.Errorf("unexpected query %q", query)
+	}
+
+	step := s.steps[0]
+	s.steps = s.steps[1:]
+
+	if !strings.HasPrefix(query, step.query) {
+		return expectation{}, fmt.Errorf("got query %q when should start with %q", query, step.query)
+	}
+
+	if len(args) != len(step.args) {
+		return expectation{}, fmt.Errorf("got %d args for %q when should be %d", len(args), query, len(step.args))
+	}
+
+	for i, want := range step.args {
+		if _, ok := want.(anyArg); ok {
+			continue
+		}
+
+		if match, ok := want.(argFunc); ok {
+			if !match(args[i].Value) {
+				return expectation{}, fmt.Errorf("got arg %d = %v for %q when should match", i, args[i].Value, query)
+			}
+
+			continue
+		}
+
+		if args[i].Value != want {
+			return expectation{}, fmt.Errorf("got arg %d = %v for %q when should be %v", i, args[i].Value, query, want)
+		}
+	}
+
+	return step, nil
+}
+
+type scriptRows struct {
+	columns []string
+	rows    [][]driver.Value
+}
+
+func (r *scriptRows) Columns() []string { return r.columns }
+func (r *scriptRows) Close() error      { return nil }
+
+func (r *scriptRows) Next(dest []driver.Value) error {
+	if len(r.rows) == 0 {
+		return io.EOF
+	}
+
+	copy(dest, r.rows[0])
+	r.rows = r.rows[1:]
+
+	return nil
+}
+
+func newScriptedStore(t *testing.T, steps ...expectation) *MySQLStore {
+	s := &script{steps: steps}
+
+	scriptsMu.Lock()
+	scripts[t.Name()] = s
+	scriptsMu.Unlock()
+
+	db, err := sql.Open("sessionstore_test", t.Name())
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	store := NewMySQLStore(db, []byte("secret"))
+
+	t.Cleanup(func() {
+		store.Close()
+		db.Close()
+
+		if len(s.steps) != 0 {
+			t.Errorf("Got %d statements that were never run\n", len(s.steps))
+		}
+	})
+
+	return store
+}
+
+func TestList(t *testing.T) {
+	created := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	seen := created.Add(time.Hour)
+
+	store := newScriptedStore(t, expectation{
+		query:   "SELECT id, token, dispositivo, ip, creado, visto FROM Sesion WHERE usuario = ?",
+		args:    []driver.Value{"ana", anyArg{}},
+		columns: []string{"id", "token", "dispositivo", "ip", "creado", "visto"},
+		rows: [][]driver.Value{
+			{int64(1), hashID("current"), "Firefox", "192.0.2.1", created, seen},
+			{int64(2), hashID("other"), "Safari", "192.0.2.2", created, created},
+		},
+	})
+
+	got, err := store.List("ana", "current")
+
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	want := []Info{
+		{ID: 1, Device: "Firefox", IP: "192.0.2.1", Created: created, LastSeen: seen, Current: true},
+		{ID: 2, Device: "Safari", IP: "192.0.2.2", Created: created, LastSeen: created},
+	}
+
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("Got sessions diff %s\n", diff)
+	}
+}
+
+func TestRevoke(t *testing.T) {
+	store := newScriptedStore(t,
+		expectation{query: "DELETE FROM Sesion WHERE id = ? AND usuario = ?;", args: []driver.Value{int64(1), "ana"}, affected: 1},
+		expectation{query: "DELETE FROM Sesion WHERE id = ? AND usuario = ?;", args: []driver.Value{int64(2), "ana"}, affected: 0},
+	)
+
+	if err := store.Revoke("ana", 1); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if err := store.Revoke("ana", 2); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Got error %v for another user's session when should be %v\n", err, ErrNotFound)
+	}
+}
+
+func TestRevokeAll(t *testing.T) {
+	store := newScriptedStore(t, expectation{
+		query:    "DELETE FROM Sesion WHERE usuario = ?;",
+		args:     []driver.Value{"ana"},
+		affected: 3,
+	})
+
+	if err := store.RevokeAll("ana"); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestRegenerate(t *testing.T) {
+	store := newScriptedStore(t, expectation{
+		query:    "DELETE FROM Sesion WHERE token = ?;",
+		args:     []driver.Value{hashID("planted")},
+		affected: 1,
+	})
+
+	session := gsessions.NewSession(store, "nibbinSession")
+	session.ID = "planted"
+	session.Values["csrfSalt"] = "salt"
+
+	if err := store.Regenerate(session); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if session.ID != "" {
+		t.Errorf("Got session ID %q when should be empty\n", session.ID)
+	}
+
+	if session.Values["csrfSalt"] != "salt" {
+		t.Errorf("Got values %v when should keep the CSRF salt\n", session.Values)
+	}
+
+	// A session without an ID was never stored, there is nothing to drop.
+	if err := store.Regenerate(gsessions.NewSession(store, "nibbinSession")); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestSaveMaxAge(t *testing.T) {
+	// A row expiring a day from now, whatever the cookie says.
+	expiresInADay := argFunc(func(v driver.Value) bool {
+		expires, ok := v.(time.Time)
+		left := time.Until(expires)
+
+		return ok && left > 23*time.Hour && left <= 24*time.Hour
+	})
+
+	store := newScriptedStore(t,
+		expectation{
+			query:    "INSERT INTO Sesion",
+			args:     []driver.Value{anyArg{}, nil, anyArg{}, "", "192.0.2.1", anyArg{}, anyArg{}, expiresInADay},
+			affected: 1,
+		},
+		expectation{query: "DELETE FROM Sesion WHERE token = ?;", args: []driver.Value{anyArg{}}, affected: 1},
+	)
+
+	r := httptest.NewRequest("GET", "/", nil)
+	r.RemoteAddr = "192.0.2.1:1234"
+
+	session := gsessions.NewSession(store, "nibbinSession")
+	session.Options = &gsessions.Options{Path: "/", MaxAge: 0}
+
+	w := httptest.NewRecorder()
+
+	if err := store.Save(r, w, session); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if session.ID == "" {
+		t.Errorf("Got no session ID for a browser session cookie\n")
+		return
+	}
+
+	if cookie := w.Result().Cookies()[0]; cookie.Value == "" || cookie.MaxAge != 0 {
+		t.Errorf("Got cookie %v when should be a browser session cookie\n", cookie)
+	}
+
+	session.Options.MaxAge = -1
+
+	if err := store.Save(r, httptest.NewRecorder(), session); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestDevice(t *testing.T) {
+	r := httptest.NewRequest("GET", "/", nil)
+
+	// 254 ASCII bytes followed by a two byte rune straddling the limit.
+	r.Header.Set("User-Agent", strings.Repeat("a", maxDeviceLen-1)+"ñ")
+
+	got := device(r)
+
+	if !utf8.ValidString(got) {
+		t.Errorf("Got invalid UTF-8 device %q\n", got)
+	}
+
+	if len(got) != maxDeviceLen-1 {
+		t.Errorf("Got device of %d bytes when should be %d\n", len(got), maxDeviceLen-1)
+	}
+
+	r.Header.Set("User-Agent", "Mozilla\xff/5.0")
+
+	if got := device(r); got != "Mozilla/5.0" {
+		t.Errorf("Got device %q when should be %q\n", got, "Mozilla/5.0")
+	}
+}
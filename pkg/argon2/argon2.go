@@ -2,11 +2,9 @@ package argon2
 
 import (
 	"crypto/rand"
-	"crypto/subtle"
 	"encoding/base64"
 	"errors"
 	"fmt"
-	"os"
 	"strings"
 
 	"golang.org/x/crypto/argon2"
@@ -34,6 +32,8 @@ type Argon2Hash struct {
 	Salt   []byte
 	Hash   []byte
 	Config *Config
+	// KeyID names the pepper of the Keyring the hash was made with.
+	KeyID string
 }
 
 var (
@@ -45,47 +45,60 @@ var (
 
 func GenerateHash(password []byte, config *Config) (*Argon2Hash, error) {
 
-	if config == nil {
-		return nil, ErrInvalidParams
+	k, err := defaultKeys()
+
+	if err != nil {
+		return nil, err
 	}
 
-	salt, err := GenerateSecureSalt(config.SaltLength)
+	return k.GenerateHash(password, config)
+}
+
+func GenerateHashWithSalt(password, salt []byte, config *Config) (*Argon2Hash, error) {
+
+	k, err := defaultKeys()
 
 	if err != nil {
 		return nil, err
 	}
 
-	return GenerateHashWithSalt(password, salt, config)
+	return k.GenerateHashWithSalt(password, salt, config)
 }
 
-func GenerateHashWithSalt(password, salt []byte, config *Config) (*Argon2Hash, error) {
+func generate(password, salt []byte, config *Config, keyID string, pepper []byte) (*Argon2Hash, error) {
 
 	if config == nil {
 		return nil, ErrInvalidParams
 	}
 
-	pepper := []byte(os.Getenv("SECRET_PEPPER"))
-
-	password = append(password, pepper...)
+	peppered := make([]byte, 0, len(password)+len(pepper))
+	peppered = append(peppered, password...)
+	peppered = append(peppered, pepper...)
 
 	var hash []byte
 
 	if config.Type == Argon2id {
-		hash = argon2.IDKey(password, salt, config.Time, config.Memory, config.Threads, config.KeyLength)
+		hash = argon2.IDKey(peppered, salt, config.Time, config.Memory, config.Threads, config.KeyLength)
 	} else if config.Type == Argon2i {
-		hash = argon2.Key(password, salt, config.Time, config.Memory, config.Threads, config.KeyLength)
+		hash = argon2.Key(peppered, salt, config.Time, config.Memory, config.Threads, config.KeyLength)
 	}
 
 	return &Argon2Hash{
 		Salt:   salt,
 		Hash:   hash,
 		Config: config,
+		KeyID:  keyID,
 	}, nil
 }
 
+// DecodeHash reads PHC strings ($argon2id$v=19$m=...,t=...,p=...$salt$hash)
+// as well as the legacy format this package used to write, the same
+// without the leading $.
+// The parameters are returned as stored, check them with Policy.Check
+// before hashing with them.
 func DecodeHash(hashStr string) (*Argon2Hash, error) {
 
-	vals := strings.Split(hashStr, "$")
+	vals := strings.Split(strings.TrimPrefix(hashStr, "$"), "$")
 
 	if len(vals) != 5 {
 		return nil, ErrInvalidHash
@@ -115,12 +128,18 @@ func DecodeHash(hashStr string) (*Argon2Hash, error) {
 
 	config := &Config{}
 
-	_, err = fmt.Sscanf(vals[2], "m=%d,t=%d,p=%d", &config.Memory, &config.Time, &config.Threads)
+	params, keyID, _ := strings.Cut(vals[2], ",keyid=")
+
+	_, err = fmt.Sscanf(params, "m=%d,t=%d,p=%d", &config.Memory, &config.Time, &config.Threads)
 
 	if err != nil {
 		return nil, err
 	}
 
+	if err := validKeyID(keyID); err != nil {
+		return nil, err
+	}
+
 	config.Type = argonType
 
 	salt, err := base64.RawStdEncoding.Strict().DecodeString(vals[3])
@@ -143,24 +162,37 @@ func DecodeHash(hashStr string) (*Argon2Hash, error) {
 		Hash:   hash,
 		Salt:   salt,
 		Config: config,
+		KeyID:  keyID,
 	}, nil
 }
 
+// ComparePasswordHash checks password with the default keyring, using the
+// cost stored in hash without bounding it. Use Pool.ComparePasswordHash for
+// hashes that may not be trusted, and to learn whether the hash was made
+// with a retired pepper.
 func ComparePasswordHash(password, hash string) (bool, error) {
 
-	originalHash, err := DecodeHash(hash)
+	k, err := defaultKeys()
 
 	if err != nil {
 		return false, err
 	}
 
-	newHash, err := GenerateHashWithSalt([]byte(password), originalHash.Salt, originalHash.Config)
+	match, _, err := k.ComparePasswordHash(password, hash)
+
+	return match, err
+}
+
+// NeedsRehash checks encoded against target and the default keyring, see
+// Keyring.NeedsRehash.
+func NeedsRehash(encoded string, target *Config) bool {
+	k, err := defaultKeys()
 
 	if err != nil {
-		return false, err
+		return false
 	}
 
-	return subtle.ConstantTimeCompare(newHash.Hash, originalHash.Hash) == 1, nil
+	return k.NeedsRehash(encoded, target)
 }
 
 func GenerateSecureSalt(length uint32) ([]byte, error) {
@@ -184,18 +216,24 @@ func (v Version) String() string {
 	return ""
 }
 
+// String encodes the hash in the PHC string format, which libsodium,
+// passlib and the argon2 reference implementation understand.
 func (hash *Argon2Hash) String() (encodedHash string) {
 
 	b64Hash := base64.RawStdEncoding.EncodeToString(hash.Hash)
 	b64Salt := base64.RawStdEncoding.EncodeToString(hash.Salt)
 
+	params := fmt.Sprintf("m=%d,t=%d,p=%d", hash.Config.Memory, hash.Config.Time, hash.Config.Threads)
+
+	if hash.KeyID != "" {
+		params += ",keyid=" + hash.KeyID
+	}
+
 	encodedHash = fmt.Sprintf(
-		"%s$v=%d$m=%d,t=%d,p=%d$%s$%s",
+		"$%s$v=%d$%s$%s$%s",
 		hash.Config.Type.String(),
 		argon2.Version,
-		hash.Config.Memory,
-		hash.Config.Time,
-		hash.Config.Threads,
+		params,
 		b64Salt,
 		b64Hash,
 	)
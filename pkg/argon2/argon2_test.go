@@ -1,6 +1,7 @@
 package argon2
 
 import (
+	"strings"
 	"testing"
 
 	"github.com/google/go-cmp/cmp"
@@ -197,3 +198,99 @@ func TestComparePasswordHash(t *testing.T) {
 	}
 
 }
+
+func TestNeedsRehash(t *testing.T) {
+	k, err := NewKeyring("2", []byte("pepper"), map[string][]byte{"1": []byte("old")})
+
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	old, err := NewKeyring("1", []byte("old"), nil)
+
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	weak := DefaultConfig()
+	weak.Memory = 1024
+	weak.Time = 1
+
+	current, _ := k.GenerateHash([]byte("password"), DefaultConfig())
+	cheap, _ := k.GenerateHash([]byte("password"), weak)
+	retired, _ := old.GenerateHash([]byte("password"), DefaultConfig())
+
+	argon2i := DefaultConfig()
+	argon2i.Type = Argon2i
+
+	legacyType, _ := k.GenerateHash([]byte("password"), argon2i)
+
+	tests := []struct {
+		name string
+		hash string
+		want bool
+	}{
+		{"current", current.String(), false},
+		{"weaker parameters", cheap.String(), true},
+		{"retired pepper", retired.String(), true},
+		{"argon2i", legacyType.String(), true},
+		{"legacy format", strings.TrimPrefix(current.String(), "$"), true},
+		{"garbage", "not a hash", true},
+	}
+
+	for _, tt := range tests {
+		if got := k.NeedsRehash(tt.hash, DefaultConfig()); got != tt.want {
+			t.Errorf("%s: got %v when should be %v\n", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestPHCFormat(t *testing.T) {
+	k, err := NewKeyring("", nil, nil)
+
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	// From the argon2 reference implementation README.
+	reference := "$argon2i$v=19$m=65536,t=2,p=4$c29tZXNhbHQ$RdescudvJCsgt3ub+b+dWRWJTmaaJObG"
+
+	match, _, err := k.ComparePasswordHash("password", reference)
+
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if !match {
+		t.Errorf("Reference hash %s tested different when should be equal\n", reference)
+		return
+	}
+
+	decoded, err := DecodeHash(reference)
+
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if got := decoded.String(); got != reference {
+		t.Errorf("Got %s when should be %s\n", got, reference)
+	}
+
+	legacy := strings.TrimPrefix(reference, "$")
+
+	match, _, err = k.ComparePasswordHash("password", legacy)
+
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if !match {
+		t.Errorf("Legacy hash %s tested different when should be equal\n", legacy)
+	}
+}
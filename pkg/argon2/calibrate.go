@@ -0,0 +1,77 @@
+package argon2
+
+import (
+	"time"
+)
+
+// MinMemory is the smallest memory cost, in KiB, Calibrate will settle for.
+const MinMemory = 8 * 1024
+
+// Calibrate benchmarks argon2id on this machine and returns the strongest
+// parameters that hash within target using at most maxMemory KiB and the
+// given number of threads. Memory is preferred over iterations: it is
+// halved until one pass fits, then passes are added while they fit. The
+// result never leaves policy, maxMemory and threads are clamped to it.
+func Calibrate(target time.Duration, policy Policy, maxMemory uint32, threads uint8) (*Config, error) {
+	return calibrate(target, policy, maxMemory, threads, measure)
+}
+
+func calibrate(target time.Duration, policy Policy, maxMemory uint32, threads uint8, measure func(*Config) time.Duration) (*Config, error) {
+
+	if maxMemory > policy.MaxMemory {
+		maxMemory = policy.MaxMemory
+	}
+
+	if threads > policy.MaxThreads {
+		threads = policy.MaxThreads
+	}
+
+	if target <= 0 || maxMemory < MinMemory || threads == 0 {
+		return nil, ErrInvalidParams
+	}
+
+	config := DefaultConfig()
+	config.Memory = maxMemory
+	config.Time = 1
+	config.Threads = threads
+
+	for measure(config) > target && config.Memory/2 >= MinMemory {
+		config.Memory /= 2
+	}
+
+	for config.Time < policy.MaxTime {
+		next := *config
+		next.Time++
+
+		if measure(&next) > target {
+			return config, nil
+		}
+
+		config = &next
+	}
+
+	return config, nil
+}
+
+// measure times a hash with config, taking the best of a few runs so a
+// scheduling hiccup doesn't skew the result.
+func measure(config *Config) time.Duration {
+	password := []byte("calibration password")
+	salt := make([]byte, config.SaltLength)
+
+	best := time.Duration(-1)
+
+	for i := 0; i < 3; i++ {
+		start := time.Now()
+
+		if _, err := generate(password, salt, config, "", nil); err != nil {
+			return 0
+		}
+
+		if d := time.Since(start); best < 0 || d < best {
+			best = d
+		}
+	}
+
+	return best
+}
@@ -0,0 +1,69 @@
+package argon2
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCalibrate(t *testing.T) {
+	// One millisecond per MiB per pass.
+	fake := func(config *Config) time.Duration {
+		return time.Duration(config.Memory/1024*config.Time) * time.Millisecond
+	}
+
+	tests := []struct {
+		target    time.Duration
+		maxMemory uint32
+		memory    uint32
+		time      uint32
+	}{
+		{500 * time.Millisecond, 64 * 1024, 64 * 1024, 7},
+		{100 * time.Millisecond, 256 * 1024, 64 * 1024, 1},
+		{10 * time.Millisecond, 64 * 1024, MinMemory, 1},
+	}
+
+	for _, tt := range tests {
+		got, err := calibrate(tt.target, DefaultPolicy(), tt.maxMemory, 2, fake)
+
+		if err != nil {
+			t.Error(err)
+			return
+		}
+
+		if got.Memory != tt.memory || got.Time != tt.time || got.Threads != 2 || got.Type != Argon2id {
+			t.Errorf("Got m=%d,t=%d for %v when should be m=%d,t=%d\n", got.Memory, got.Time, tt.target, tt.memory, tt.time)
+		}
+	}
+
+	// A generous target on a fast machine stays within DefaultPolicy.
+	got, err := calibrate(time.Hour, DefaultPolicy(), 1024*1024, 64, fake)
+
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if err := DefaultPolicy().Check(got); err != nil {
+		t.Errorf("Got calibrated cost outside of the policy: %v\n", err)
+	}
+
+	// And within a tighter configured one.
+	tight := DefaultPolicy()
+	tight.MaxMemory = 32 * 1024
+	tight.MaxTime = 4
+
+	got, err = calibrate(time.Hour, tight, 1024*1024, 2, fake)
+
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if err := tight.Check(got); err != nil {
+		t.Errorf("Got calibrated cost outside of the configured policy: %v\n", err)
+	}
+
+	if _, err := calibrate(time.Second, DefaultPolicy(), 1024, 2, fake); err != ErrInvalidParams {
+		t.Errorf("Got error %v when should be %v\n", err, ErrInvalidParams)
+	}
+}
@@ -0,0 +1,179 @@
+package argon2
+
+import (
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+)
+
+var (
+	ErrUnknownPepper = errors.New("unknown pepper key id")
+	ErrInvalidKeyID  = errors.New("invalid pepper key id")
+)
+
+// Keyring holds the pepper new hashes are made with and the retired ones
+// still accepted when verifying. Hashes made before key IDs existed have
+// none and use the pepper registered under the empty ID.
+type Keyring struct {
+	current string
+	peppers map[string][]byte
+}
+
+// NewKeyring returns a keyring hashing with the pepper of currentID, which
+// may be empty to keep writing hashes without a key ID.
+func NewKeyring(currentID string, current []byte, retired map[string][]byte) (*Keyring, error) {
+	k := &Keyring{
+		current: currentID,
+		peppers: make(map[string][]byte, len(retired)+1),
+	}
+
+	for id, pepper := range retired {
+		if err := validKeyID(id); err != nil {
+			return nil, err
+		}
+
+		k.peppers[id] = pepper
+	}
+
+	if err := validKeyID(currentID); err != nil {
+		return nil, err
+	}
+
+	k.peppers[currentID] = current
+
+	return k, nil
+}
+
+// KeyringFromEnv builds the keyring from SECRET_PEPPER and SECRET_PEPPER_ID,
+// the current pepper and its key ID, and SECRET_PEPPER_RETIRED, a comma
+// separated list of id:pepper pairs.
+func KeyringFromEnv() (*Keyring, error) {
+	retired := make(map[string][]byte)
+
+	if list := os.Getenv("SECRET_PEPPER_RETIRED"); list != "" {
+		for _, entry := range strings.Split(list, ",") {
+			id, pepper, ok := strings.Cut(entry, ":")
+
+			if !ok {
+				return nil, fmt.Errorf("invalid SECRET_PEPPER_RETIRED entry %q, should be id:pepper", entry)
+			}
+
+			retired[id] = []byte(pepper)
+		}
+	}
+
+	return NewKeyring(os.Getenv("SECRET_PEPPER_ID"), []byte(os.Getenv("SECRET_PEPPER")), retired)
+}
+
+// CurrentKeyID is the key ID given to new hashes.
+func (k *Keyring) CurrentKeyID() string {
+	return k.current
+}
+
+func (k *Keyring) GenerateHash(password []byte, config *Config) (*Argon2Hash, error) {
+
+	if config == nil {
+		return nil, ErrInvalidParams
+	}
+
+	salt, err := GenerateSecureSalt(config.SaltLength)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return k.GenerateHashWithSalt(password, salt, config)
+}
+
+func (k *Keyring) GenerateHashWithSalt(password, salt []byte, config *Config) (*Argon2Hash, error) {
+	return generate(password, salt, config, k.current, k.peppers[k.current])
+}
+
+// ComparePasswordHash checks password against the encoded hash. retired
+// reports that the hash was made with a pepper other than the current one
+// and should be replaced once the password is known to match.
+//
+// The hash is verified with whatever cost it asks for. Hashes that may not
+// be trusted should go through Pool.ComparePasswordHash, which bounds them
+// with its Policy first.
+func (k *Keyring) ComparePasswordHash(password, hash string) (match, retired bool, err error) {
+
+	originalHash, err := DecodeHash(hash)
+
+	if err != nil {
+		return false, false, err
+	}
+
+	return k.compare(password, originalHash)
+}
+
+func (k *Keyring) compare(password string, originalHash *Argon2Hash) (match, retired bool, err error) {
+
+	pepper, ok := k.peppers[originalHash.KeyID]
+
+	if !ok {
+		return false, false, fmt.Errorf("%w %q", ErrUnknownPepper, originalHash.KeyID)
+	}
+
+	newHash, err := generate([]byte(password), originalHash.Salt, originalHash.Config, originalHash.KeyID, pepper)
+
+	if err != nil {
+		return false, false, err
+	}
+
+	match = subtle.ConstantTimeCompare(newHash.Hash, originalHash.Hash) == 1
+
+	return match, originalHash.KeyID != k.current, nil
+}
+
+// NeedsRehash reports whether the encoded hash should be replaced by one
+// made with target and the current pepper. Unreadable hashes and hashes in
+// the legacy format need one too.
+func (k *Keyring) NeedsRehash(encoded string, target *Config) bool {
+	hash, err := DecodeHash(encoded)
+
+	if err != nil || !strings.HasPrefix(encoded, "$") {
+		return true
+	}
+
+	return hash.KeyID != k.current || *hash.Config != *target
+}
+
+var (
+	defaultMu      sync.Mutex
+	defaultKeyring *Keyring
+)
+
+// SetDefaultKeyring replaces the keyring used by the package level
+// functions, which otherwise read the environment on every call.
+func SetDefaultKeyring(k *Keyring) {
+	defaultMu.Lock()
+	defer defaultMu.Unlock()
+
+	defaultKeyring = k
+}
+
+func defaultKeys() (*Keyring, error) {
+	defaultMu.Lock()
+	k := defaultKeyring
+	defaultMu.Unlock()
+
+	if k != nil {
+		return k, nil
+	}
+
+	return KeyringFromEnv()
+}
+
+func validKeyID(id string) error {
+	for _, r := range id {
+		if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '-' || r == '_') {
+			return fmt.Errorf("%w %q", ErrInvalidKeyID, id)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,95 @@
+package argon2
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestKeyringRotation(t *testing.T) {
+	password := []byte("password")
+
+	legacy, err := NewKeyring("", []byte("old"), nil)
+
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	v1, err := NewKeyring("1", []byte("first"), map[string][]byte{"": []byte("old")})
+
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	v2, err := NewKeyring("2", []byte("second"), map[string][]byte{"": []byte("old"), "1": []byte("first")})
+
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	legacyHash, err := legacy.GenerateHash(password, DefaultConfig())
+
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if strings.Contains(legacyHash.String(), "keyid") {
+		t.Errorf("Got %s when should have no key id\n", legacyHash)
+		return
+	}
+
+	v1Hash, err := v1.GenerateHash(password, DefaultConfig())
+
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if !strings.Contains(v1Hash.String(), ",keyid=1$") {
+		t.Errorf("Got %s when should carry key id 1\n", v1Hash)
+		return
+	}
+
+	tests := []struct {
+		name    string
+		keyring *Keyring
+		hash    string
+		retired bool
+	}{
+		{"legacy with legacy", legacy, legacyHash.String(), false},
+		{"legacy with v1", v1, legacyHash.String(), true},
+		{"v1 with v1", v1, v1Hash.String(), false},
+		{"v1 with v2", v2, v1Hash.String(), true},
+	}
+
+	for _, tt := range tests {
+		match, retired, err := tt.keyring.ComparePasswordHash(string(password), tt.hash)
+
+		if err != nil {
+			t.Errorf("%s: %v\n", tt.name, err)
+			continue
+		}
+
+		if !match {
+			t.Errorf("%s: password tested different when should be equal\n", tt.name)
+		}
+
+		if retired != tt.retired {
+			t.Errorf("%s: got retired %v when should be %v\n", tt.name, retired, tt.retired)
+		}
+
+		match, _, err = tt.keyring.ComparePasswordHash("wrong", tt.hash)
+
+		if err != nil || match {
+			t.Errorf("%s: wrong password tested equal\n", tt.name)
+		}
+	}
+
+	if _, _, err := legacy.ComparePasswordHash(string(password), v1Hash.String()); !errors.Is(err, ErrUnknownPepper) {
+		t.Errorf("Got error %v when should be %v\n", err, ErrUnknownPepper)
+	}
+}
@@ -0,0 +1,196 @@
+package argon2
+
+import (
+	"context"
+	"fmt"
+)
+
+// Policy bounds the parameters a stored hash may ask for, so a corrupted
+// or planted hash can't make verification allocate gigabytes. Memory is
+// in KiB, salt and key lengths in bytes.
+type Policy struct {
+	MinMemory, MaxMemory         uint32
+	MinTime, MaxTime             uint32
+	MinThreads, MaxThreads       uint8
+	MinSaltLength, MaxSaltLength uint32
+	MinKeyLength, MaxKeyLength   uint32
+}
+
+// ParamError reports a parameter outside of the Policy.
+type ParamError struct {
+	Param    string
+	Value    uint32
+	Min, Max uint32
+}
+
+func (e *ParamError) Error() string {
+	return fmt.Sprintf("argon2 parameter %s=%d outside of [%d, %d]", e.Param, e.Value, e.Min, e.Max)
+}
+
+func DefaultPolicy() Policy {
+	return Policy{
+		MinMemory:     8,
+		MaxMemory:     256 * 1024,
+		MinTime:       1,
+		MaxTime:       16,
+		MinThreads:    1,
+		MaxThreads:    16,
+		MinSaltLength: 8,
+		MaxSaltLength: 64,
+		MinKeyLength:  16,
+		MaxKeyLength:  64,
+	}
+}
+
+// Check returns a *ParamError for the first parameter out of bounds.
+func (p Policy) Check(config *Config) error {
+	if config == nil {
+		return ErrInvalidParams
+	}
+
+	checks := []struct {
+		param         string
+		value, lo, hi uint32
+	}{
+		{"m", config.Memory, p.MinMemory, p.MaxMemory},
+		{"t", config.Time, p.MinTime, p.MaxTime},
+		{"p", uint32(config.Threads), uint32(p.MinThreads), uint32(p.MaxThreads)},
+		{"salt", config.SaltLength, p.MinSaltLength, p.MaxSaltLength},
+		{"key", config.KeyLength, p.MinKeyLength, p.MaxKeyLength},
+	}
+
+	for _, c := range checks {
+		if c.value < c.lo || c.value > c.hi {
+			return &ParamError{Param: c.param, Value: c.value, Min: c.lo, Max: c.hi}
+		}
+	}
+
+	return nil
+}
+
+// Pool runs argon2 for concurrent callers, at most a fixed number at a
+// time, and checks every hash against its Policy before doing any work.
+type Pool struct {
+	keys   *Keyring
+	policy Policy
+	sem    chan struct{}
+}
+
+// NewPool returns a Pool using keys, or the default keyring when nil, that
+// runs up to concurrency hashes at once.
+func NewPool(keys *Keyring, policy Policy, concurrency int) *Pool {
+	if concurrency < 1 {
+		concurrency = 1
+	}
+
+	return &Pool{
+		keys:   keys,
+		policy: policy,
+		sem:    make(chan struct{}, concurrency),
+	}
+}
+
+func (p *Pool) Policy() Policy {
+	return p.policy
+}
+
+// Hash makes a new hash of password with config, waiting for a free slot
+// until ctx is done.
+func (p *Pool) Hash(ctx context.Context, password []byte, config *Config) (*Argon2Hash, error) {
+
+	if err := p.policy.Check(config); err != nil {
+		return nil, err
+	}
+
+	k, err := p.keyring()
+
+	if err != nil {
+		return nil, err
+	}
+
+	if err := p.acquire(ctx); err != nil {
+		return nil, err
+	}
+
+	defer p.release()
+
+	return k.GenerateHash(password, config)
+}
+
+// ComparePasswordHash is Keyring.ComparePasswordHash through the pool,
+// rejecting hashes outside of its Policy with a *ParamError.
+func (p *Pool) ComparePasswordHash(ctx context.Context, password, hash string) (match, retired bool, err error) {
+
+	decoded, err := DecodeHash(hash)
+
+	if err != nil {
+		return false, false, err
+	}
+
+	if err := p.policy.Check(decoded.Config); err != nil {
+		return false, false, err
+	}
+
+	k, err := p.keyring()
+
+	if err != nil {
+		return false, false, err
+	}
+
+	if err := p.acquire(ctx); err != nil {
+		return false, false, err
+	}
+
+	defer p.release()
+
+	return k.compare(password, decoded)
+}
+
+func (p *Pool) NeedsRehash(encoded string, target *Config) bool {
+	k, err := p.keyring()
+
+	if err != nil {
+		return false
+	}
+
+	return k.NeedsRehash(encoded, target)
+}
+
+// Run calls fn in a slot of the pool, so other expensive hashing shares
+// the same concurrency limit.
+func (p *Pool) Run(ctx context.Context, fn func()) error {
+	if err := p.acquire(ctx); err != nil {
+		return err
+	}
+
+	defer p.release()
+
+	fn()
+
+	return nil
+}
+
+func (p *Pool) keyring() (*Keyring, error) {
+	if p.keys != nil {
+		return p.keys, nil
+	}
+
+	return defaultKeys()
+}
+
+func (p *Pool) acquire(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	select {
+	case p.sem <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (p *Pool) release() {
+	<-p.sem
+}
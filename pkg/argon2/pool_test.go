@@ -0,0 +1,105 @@
+package argon2
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestPoolPolicy(t *testing.T) {
+	pool := NewPool(nil, DefaultPolicy(), 1)
+
+	huge := "$argon2id$v=19$m=4194304,t=1,p=1$c29tZXNhbHQ$RdescudvJCsgt3ub+b+dWRWJTmaaJObG"
+
+	_, _, err := pool.ComparePasswordHash(context.Background(), "password", huge)
+
+	var paramErr *ParamError
+
+	if !errors.As(err, &paramErr) {
+		t.Errorf("Got error %v when should be a *ParamError\n", err)
+		return
+	}
+
+	if paramErr.Param != "m" {
+		t.Errorf("Got parameter %s when should be m\n", paramErr.Param)
+	}
+
+	config := DefaultConfig()
+	config.Threads = 64
+
+	if _, err := pool.Hash(context.Background(), []byte("password"), config); !errors.As(err, &paramErr) {
+		t.Errorf("Got error %v when should be a *ParamError\n", err)
+	}
+}
+
+func TestPoolConfiguredPolicy(t *testing.T) {
+	ctx := context.Background()
+	keys, err := NewKeyring("", []byte("pepper"), nil)
+
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	// Beyond DefaultPolicy, but cheap with little memory.
+	config := DefaultConfig()
+	config.Memory = 64
+	config.Time = 20
+
+	loose := DefaultPolicy()
+	loose.MaxTime = 32
+
+	hash, err := NewPool(keys, loose, 1).Hash(ctx, []byte("password"), config)
+
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	match, _, err := NewPool(keys, loose, 1).ComparePasswordHash(ctx, "password", hash.String())
+
+	if err != nil || !match {
+		t.Errorf("Got match %v, error %v with a looser policy when should match\n", match, err)
+	}
+
+	tight := DefaultPolicy()
+	tight.MaxTime = 10
+
+	var paramErr *ParamError
+
+	if _, _, err := NewPool(keys, tight, 1).ComparePasswordHash(ctx, "password", hash.String()); !errors.As(err, &paramErr) {
+		t.Errorf("Got error %v with a tighter policy when should be a *ParamError\n", err)
+	}
+}
+
+func TestPoolContext(t *testing.T) {
+	pool := NewPool(nil, DefaultPolicy(), 1)
+
+	hash, err := pool.Hash(context.Background(), []byte("password"), DefaultConfig())
+
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	// Hold the only slot, so the next caller has to wait.
+	if err := pool.acquire(context.Background()); err != nil {
+		t.Error(err)
+		return
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if _, _, err := pool.ComparePasswordHash(ctx, "password", hash.String()); !errors.Is(err, context.Canceled) {
+		t.Errorf("Got error %v when should be %v\n", err, context.Canceled)
+	}
+
+	pool.release()
+
+	match, _, err := pool.ComparePasswordHash(context.Background(), "password", hash.String())
+
+	if err != nil || !match {
+		t.Errorf("Got match %v, error %v when should match\n", match, err)
+	}
+}
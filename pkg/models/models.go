@@ -1,9 +1,15 @@
 package models
 
+import "time"
+
 type LoginRequest struct {
 	Email string `json:"email" binding:"required,email"`
 }
 
+type ResendOTPRequest struct {
+	Email string `json:"email" binding:"required,email"`
+}
+
 type OTPRequest struct {
 	OTP   string `json:"otp"   binding:"required"`
 	Email string `json:"email" binding:"required,email"`
@@ -17,16 +23,36 @@ type RegisterRequest struct {
 	Direccion  string `json:"direccion"  binding:"required"`
 	Telefono   string `json:"telefono"   binding:"required"`
 	Nacimiento string `json:"nacimiento" binding:"required"`
+	Idioma     string `json:"idioma"`
 }
 
 type LoginAdminRequest struct {
-	User     string `json:"user"     binding:"required"`
-	Password string `json:"password" binding:"required"`
+	User         string `json:"user"     binding:"required"`
+	Password     string `json:"password" binding:"required"`
+	TOTP         string `json:"totp"`
+	RecoveryCode string `json:"recoveryCode"`
+}
+
+type TOTPRequest struct {
+	Code         string `json:"code"`
+	RecoveryCode string `json:"recoveryCode"`
 }
 
 type RegisterAdminRequest struct {
 	Email    string `json:"email"    binding:"required,email"`
 	Password string `json:"password" binding:"required"`
+	Role     string `json:"role"`
+}
+
+type AssignRoleRequest struct {
+	Role string `json:"role" binding:"required"`
+}
+
+type Admin struct {
+	ID    int    `json:"id"`
+	User  string `json:"user"`
+	Email string `json:"email"`
+	Role  string `json:"role"`
 }
 
 type SearchRequest struct {
@@ -34,14 +60,26 @@ type SearchRequest struct {
 }
 
 type Producto struct {
-	ID          int     `json:"id"`
-	Nombre      string  `json:"nombre"      binding:"required"`
-	Marca       string  `json:"marca"       binding:"required"`
-	Descripcion string  `json:"descripcion" binding:"required"`
-	Descuento   float32 `json:"descuento"   binding:"required"`
-	Stock       int     `json:"stock"       binding:"required"`
-	Imagen      string  `json:"imagen"      binding:"required"`
-	IsFavorite  bool    `json:"isfavorite"`
+	ID          int        `json:"id"`
+	Nombre      string     `json:"nombre"      binding:"required"`
+	Marca       string     `json:"marca"       binding:"required"`
+	Descripcion string     `json:"descripcion" binding:"required"`
+	Precio      int        `json:"precio"      binding:"required,gt=0"`
+	Descuento   float32    `json:"descuento"   binding:"gte=0,lte=1"`
+	Stock       int        `json:"stock"       binding:"gte=0"`
+	Imagen      string     `json:"imagen"      binding:"required"`
+	IsFavorite  bool       `json:"isfavorite"`
+	Eliminado   *time.Time `json:"eliminado,omitempty"`
+}
+
+type UpdateProductRequest struct {
+	Nombre      *string  `json:"nombre"`
+	Marca       *string  `json:"marca"`
+	Descripcion *string  `json:"descripcion"`
+	Precio      *int     `json:"precio"`
+	Descuento   *float32 `json:"descuento"`
+	Stock       *int     `json:"stock"`
+	Imagen      *string  `json:"imagen"`
 }
 
 type DescProducto struct {
@@ -61,3 +99,30 @@ type Favorito struct {
 	IDUsuario  int `json:"idUsuario"`
 	IDProducto int `json:"idProducto" binding:"required"`
 }
+
+type ChangeEmailRequest struct {
+	Email string `json:"email" binding:"required,email"`
+}
+
+type LocaleRequest struct {
+	Idioma string `json:"idioma" binding:"required"`
+}
+
+type Usuario struct {
+	ID         int    `json:"id"`
+	Nombre     string `json:"nombre"`
+	Apellido   string `json:"apellido"`
+	Email      string `json:"email"`
+	User       string `json:"user"`
+	Puntos     int    `json:"puntos"`
+	Direccion  string `json:"direccion"`
+	Telefono   string `json:"telefono"`
+	Nacimiento string `json:"nacimiento"`
+	Idioma     string `json:"idioma"`
+}
+
+type CreateTokenRequest struct {
+	Name          string   `json:"name"          binding:"required"`
+	Scopes        []string `json:"scopes"        binding:"required"`
+	ExpiresInDays int      `json:"expiresInDays"`
+}
@@ -0,0 +1,142 @@
+package password
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/dvher/nibbin.cl_back/pkg/argon2"
+	"golang.org/x/crypto/bcrypt"
+	"golang.org/x/crypto/scrypt"
+)
+
+const (
+	// MaxBcryptCost bounds the work an imported bcrypt hash can ask for.
+	MaxBcryptCost = 14
+
+	maxScryptLogN = 20
+	maxScryptR    = 32
+	maxScryptP    = 16
+
+	// minScryptLen is the shortest salt and hash accepted. An empty hash
+	// would otherwise match any password.
+	minScryptLen = 16
+)
+
+var ErrInvalidHash = errors.New("invalid password hash")
+
+// Bcrypt verifies imported bcrypt hashes, made without a pepper.
+type Bcrypt struct {
+	Pool *argon2.Pool
+}
+
+func (b Bcrypt) Verify(ctx context.Context, password, encoded string) (match, upgrade bool, err error) {
+	cost, err := bcrypt.Cost([]byte(encoded))
+
+	if err != nil {
+		return false, false, fmt.Errorf("%w: %v", ErrInvalidHash, err)
+	}
+
+	if cost > MaxBcryptCost {
+		return false, false, &argon2.ParamError{Param: "bcrypt cost", Value: uint32(cost), Min: uint32(bcrypt.MinCost), Max: MaxBcryptCost}
+	}
+
+	var cmpErr error
+
+	err = b.Pool.Run(ctx, func() {
+		cmpErr = bcrypt.CompareHashAndPassword([]byte(encoded), []byte(password))
+	})
+
+	if err != nil {
+		return false, false, err
+	}
+
+	if errors.Is(cmpErr, bcrypt.ErrMismatchedHashAndPassword) {
+		return false, false, nil
+	}
+
+	if cmpErr != nil {
+		return false, false, cmpErr
+	}
+
+	return true, true, nil
+}
+
+// Scrypt verifies imported scrypt hashes in the passlib format,
+// $scrypt$ln=16,r=8,p=1$salt$hash, made without a pepper.
+type Scrypt struct {
+	Pool *argon2.Pool
+}
+
+func (s Scrypt) Verify(ctx context.Context, password, encoded string) (match, upgrade bool, err error) {
+	vals := strings.Split(encoded, "$")
+
+	if len(vals) != 5 || vals[1] != "scrypt" {
+		return false, false, ErrInvalidHash
+	}
+
+	var logN, r, p uint32
+
+	if _, err := fmt.Sscanf(vals[2], "ln=%d,r=%d,p=%d", &logN, &r, &p); err != nil {
+		return false, false, fmt.Errorf("%w: %v", ErrInvalidHash, err)
+	}
+
+	salt, err := base64.RawStdEncoding.DecodeString(vals[3])
+
+	if err != nil {
+		return false, false, fmt.Errorf("%w: %v", ErrInvalidHash, err)
+	}
+
+	hash, err := base64.RawStdEncoding.DecodeString(vals[4])
+
+	if err != nil {
+		return false, false, fmt.Errorf("%w: %v", ErrInvalidHash, err)
+	}
+
+	if err := s.check(logN, r, p); err != nil {
+		return false, false, err
+	}
+
+	if len(salt) < minScryptLen || len(hash) < minScryptLen {
+		return false, false, fmt.Errorf("%w: salt and hash must be at least %d bytes", ErrInvalidHash, minScryptLen)
+	}
+
+	var key []byte
+	var keyErr error
+
+	err = s.Pool.Run(ctx, func() {
+		key, keyErr = scrypt.Key([]byte(password), salt, 1<<logN, int(r), int(p), len(hash))
+	})
+
+	if err != nil {
+		return false, false, err
+	}
+
+	if keyErr != nil {
+		return false, false, keyErr
+	}
+
+	return subtle.ConstantTimeCompare(key, hash) == 1, true, nil
+}
+
+// check applies the same memory bound as the argon2 policy: scrypt needs
+// 128 * r * N bytes.
+func (s Scrypt) check(logN, r, p uint32) error {
+	switch {
+	case logN < 1 || logN > maxScryptLogN:
+		return &argon2.ParamError{Param: "ln", Value: logN, Min: 1, Max: maxScryptLogN}
+	case r < 1 || r > maxScryptR:
+		return &argon2.ParamError{Param: "r", Value: r, Min: 1, Max: maxScryptR}
+	case p < 1 || p > maxScryptP:
+		return &argon2.ParamError{Param: "p", Value: p, Min: 1, Max: maxScryptP}
+	}
+
+	if kib := uint64(128) * uint64(r) << logN >> 10; kib > uint64(s.Pool.Policy().MaxMemory) {
+		return &argon2.ParamError{Param: "scrypt memory", Value: uint32(kib), Min: 0, Max: s.Pool.Policy().MaxMemory}
+	}
+
+	return nil
+}
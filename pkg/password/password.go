@@ -0,0 +1,93 @@
+// Package password verifies password hashes of any supported format and
+// tells when one should be replaced by a current argon2id hash.
+package password
+
+import (
+	"context"
+	"errors"
+	"strings"
+
+	"github.com/dvher/nibbin.cl_back/pkg/argon2"
+)
+
+var ErrUnknownFormat = errors.New("unknown password hash format")
+
+// Hasher verifies the hashes of one format. upgrade reports that the hash
+// should be replaced once the password is known to match.
+type Hasher interface {
+	Verify(ctx context.Context, password, encoded string) (match, upgrade bool, err error)
+}
+
+// Registry dispatches hashes to a Hasher by their prefix.
+type Registry struct {
+	hashers map[string]Hasher
+}
+
+func NewRegistry() *Registry {
+	return &Registry{hashers: make(map[string]Hasher)}
+}
+
+// NewDefaultRegistry understands the argon2 hashes this service makes,
+// checked against target, plus bcrypt and scrypt hashes of imported
+// accounts, which are always flagged for upgrade. All of them share the
+// concurrency limit of pool.
+func NewDefaultRegistry(pool *argon2.Pool, target *argon2.Config) *Registry {
+	r := NewRegistry()
+
+	a := Argon2{Pool: pool, Target: target}
+
+	// The format without the leading $ is what pkg/argon2 used to write.
+	for _, prefix := range []string{"$argon2id$", "$argon2i$", "argon2id$", "argon2i$"} {
+		r.Register(prefix, a)
+	}
+
+	b := Bcrypt{Pool: pool}
+
+	for _, prefix := range []string{"$2a$", "$2b$", "$2y$"} {
+		r.Register(prefix, b)
+	}
+
+	r.Register("$scrypt$", Scrypt{Pool: pool})
+
+	return r
+}
+
+func (r *Registry) Register(prefix string, h Hasher) {
+	r.hashers[prefix] = h
+}
+
+// Verify checks password with the Hasher of the longest matching prefix.
+func (r *Registry) Verify(ctx context.Context, password, encoded string) (match, upgrade bool, err error) {
+	var hasher Hasher
+	longest := -1
+
+	for prefix, h := range r.hashers {
+		if strings.HasPrefix(encoded, prefix) && len(prefix) > longest {
+			hasher = h
+			longest = len(prefix)
+		}
+	}
+
+	if hasher == nil {
+		return false, false, ErrUnknownFormat
+	}
+
+	return hasher.Verify(ctx, password, encoded)
+}
+
+// Argon2 verifies pkg/argon2 hashes, which need an upgrade when made with
+// other parameters than Target or a retired pepper.
+type Argon2 struct {
+	Pool   *argon2.Pool
+	Target *argon2.Config
+}
+
+func (a Argon2) Verify(ctx context.Context, password, encoded string) (match, upgrade bool, err error) {
+	match, retired, err := a.Pool.ComparePasswordHash(ctx, password, encoded)
+
+	if err != nil || !match {
+		return false, false, err
+	}
+
+	return true, retired || a.Pool.NeedsRehash(encoded, a.Target), nil
+}
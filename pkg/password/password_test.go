@@ -0,0 +1,106 @@
+package password
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/dvher/nibbin.cl_back/pkg/argon2"
+	"golang.org/x/crypto/bcrypt"
+	"golang.org/x/crypto/scrypt"
+)
+
+func scryptHash(t *testing.T, password string, logN, r, p int) string {
+	salt := make([]byte, 16)
+
+	if _, err := rand.Read(salt); err != nil {
+		t.Fatal(err)
+	}
+
+	key, err := scrypt.Key([]byte(password), salt, 1<<logN, r, p, 32)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return fmt.Sprintf(
+		"$scrypt$ln=%d,r=%d,p=%d$%s$%s",
+		logN, r, p, base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(key),
+	)
+}
+
+func TestRegistry(t *testing.T) {
+	ctx := context.Background()
+	pool := argon2.NewPool(nil, argon2.DefaultPolicy(), 2)
+	registry := NewDefaultRegistry(pool, argon2.DefaultConfig())
+
+	current, err := pool.Hash(ctx, []byte("password"), argon2.DefaultConfig())
+
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	bcryptHash, err := bcrypt.GenerateFromPassword([]byte("password"), bcrypt.MinCost)
+
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	tests := []struct {
+		name    string
+		hash    string
+		upgrade bool
+	}{
+		{"argon2id", current.String(), false},
+		{"bcrypt", string(bcryptHash), true},
+		{"scrypt", scryptHash(t, "password", 10, 8, 1), true},
+	}
+
+	for _, tt := range tests {
+		match, upgrade, err := registry.Verify(ctx, "password", tt.hash)
+
+		if err != nil {
+			t.Errorf("%s: %v\n", tt.name, err)
+			continue
+		}
+
+		if !match || upgrade != tt.upgrade {
+			t.Errorf("%s: got match %v, upgrade %v when should be true, %v\n", tt.name, match, upgrade, tt.upgrade)
+		}
+
+		match, _, err = registry.Verify(ctx, "wrong", tt.hash)
+
+		if err != nil || match {
+			t.Errorf("%s: wrong password tested equal\n", tt.name)
+		}
+	}
+
+	if _, _, err := registry.Verify(ctx, "password", "$1$md5crypt$hash"); !errors.Is(err, ErrUnknownFormat) {
+		t.Errorf("Got error %v when should be %v\n", err, ErrUnknownFormat)
+	}
+
+	valid := scryptHash(t, "password", 10, 8, 1)
+	truncated := []string{
+		valid[:strings.LastIndex(valid, "$")+1],
+		valid[:strings.LastIndex(valid, "$")+9],
+		"$scrypt$ln=10,r=8,p=1$$" + valid[strings.LastIndex(valid, "$")+1:],
+	}
+
+	for _, hash := range truncated {
+		if match, _, err := registry.Verify(ctx, "wrong", hash); match || !errors.Is(err, ErrInvalidHash) {
+			t.Errorf("Got match %v, error %v for %q when should be %v\n", match, err, hash, ErrInvalidHash)
+		}
+	}
+
+	var paramErr *argon2.ParamError
+
+	if _, _, err := registry.Verify(ctx, "password", "$scrypt$ln=25,r=8,p=1$c2FsdA$aGFzaA"); !errors.As(err, &paramErr) {
+		t.Errorf("Got error %v when should be a *argon2.ParamError\n", err)
+	}
+}
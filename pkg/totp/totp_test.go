@@ -0,0 +1,79 @@
+package totp
+
+import (
+	"encoding/base32"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Test vectors from RFC 6238, appendix B, for SHA1.
+func TestHOTP(t *testing.T) {
+	key := []byte("12345678901234567890")
+
+	tests := map[int64]string{
+		59:          "94287082",
+		1111111109:  "07081804",
+		1111111111:  "14050471",
+		1234567890:  "89005924",
+		2000000000:  "69279037",
+		20000000000: "65353130",
+	}
+
+	for unix, want := range tests {
+		got := hotp(key, uint64(Step(time.Unix(unix, 0))), 8)
+
+		if got != want {
+			t.Errorf("Got code %s at %d when should be %s\n", got, unix, want)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	secret, err := GenerateSecret()
+
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	now := time.Now()
+
+	code, err := Code(secret, now.Add(-Period*time.Second))
+
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	step, ok, err := Validate(secret, code, now)
+
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if !ok || step != Step(now)-1 {
+		t.Errorf("Got %v at step %d when should be valid at step %d\n", ok, step, Step(now)-1)
+	}
+
+	if _, ok, _ := Validate(secret, code, now.Add(2*Period*time.Second)); ok {
+		t.Errorf("Got an old code accepted when should be rejected\n")
+	}
+
+	if _, _, err := Validate("not base32!", code, now); err != ErrInvalidSecret {
+		t.Errorf("Got error %v when should be %v\n", err, ErrInvalidSecret)
+	}
+
+	rfc := base32.StdEncoding.EncodeToString([]byte("12345678901234567890"))
+
+	if code, _ := Code(rfc, time.Unix(59, 0)); code != "287082" {
+		t.Errorf("Got code %s when should be 287082\n", code)
+	}
+
+	uri := URI("Nibbin", "admin@nibbin.cl", secret)
+
+	if !strings.HasPrefix(uri, "otpauth://totp/Nibbin:admin@nibbin.cl?") || !strings.Contains(uri, "secret="+secret) {
+		t.Errorf("Got URI %s when should be an otpauth URI with the secret\n", uri)
+	}
+}
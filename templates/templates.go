@@ -0,0 +1,197 @@
+// Package templates holds the email templates, embedded in the binary.
+// Each locale has its own directory, where every template has an HTML
+// version, name.html, a plain text version, name.txt, and a subject in
+// subjects.json.
+package templates
+
+import (
+	"bytes"
+	"embed"
+	"encoding/json"
+	"fmt"
+	htmltemplate "html/template"
+	"io/fs"
+	"path"
+	"sort"
+	"strings"
+	texttemplate "text/template"
+
+	"golang.org/x/text/language"
+)
+
+// DefaultLocale is used when the user's language isn't supported.
+const DefaultLocale = "es-CL"
+
+//go:embed */*.html */*.txt */subjects.json
+var files embed.FS
+
+// Email is a rendered template.
+type Email struct {
+	Subject string
+	HTML    string
+	Text    string
+}
+
+type locale struct {
+	html     *htmltemplate.Template
+	text     *texttemplate.Template
+	subjects map[string]string
+}
+
+type Set struct {
+	locales map[string]*locale
+	names   []string
+	matcher language.Matcher
+}
+
+// Parse parses every template once and checks that every locale has both
+// versions and a subject for each of the default locale's templates.
+func Parse() (*Set, error) {
+	dirs, err := fs.ReadDir(files, ".")
+
+	if err != nil {
+		return nil, err
+	}
+
+	s := &Set{locales: make(map[string]*locale)}
+
+	for _, dir := range dirs {
+		if !dir.IsDir() {
+			continue
+		}
+
+		if _, err := language.Parse(dir.Name()); err != nil {
+			return nil, fmt.Errorf("invalid locale directory %q: %w", dir.Name(), err)
+		}
+
+		l, err := parseLocale(dir.Name())
+
+		if err != nil {
+			return nil, err
+		}
+
+		s.locales[dir.Name()] = l
+		s.names = append(s.names, dir.Name())
+	}
+
+	if _, ok := s.locales[DefaultLocale]; !ok {
+		return nil, fmt.Errorf("missing templates for the default locale %s", DefaultLocale)
+	}
+
+	names, err := fs.Glob(files, DefaultLocale+"/*.html")
+
+	if err != nil {
+		return nil, err
+	}
+
+	for name, l := range s.locales {
+		for _, file := range names {
+			base := strings.TrimSuffix(path.Base(file), ".html")
+
+			if l.html.Lookup(base+".html") == nil || l.text.Lookup(base+".txt") == nil {
+				return nil, fmt.Errorf("template %s/%s needs both %s.html and %s.txt", name, base, base, base)
+			}
+
+			if l.subjects[base] == "" {
+				return nil, fmt.Errorf("template %s/%s has no subject", name, base)
+			}
+		}
+	}
+
+	// The default locale goes first so the matcher falls back to it.
+	sort.Slice(s.names, func(i, j int) bool {
+		if s.names[i] == DefaultLocale || s.names[j] == DefaultLocale {
+			return s.names[i] == DefaultLocale
+		}
+
+		return s.names[i] < s.names[j]
+	})
+
+	tags := make([]language.Tag, len(s.names))
+
+	for i, name := range s.names {
+		tags[i] = language.MustParse(name)
+	}
+
+	s.matcher = language.NewMatcher(tags)
+
+	return s, nil
+}
+
+func parseLocale(dir string) (*locale, error) {
+	html, err := htmltemplate.ParseFS(files, dir+"/*.html")
+
+	if err != nil {
+		return nil, err
+	}
+
+	text, err := texttemplate.ParseFS(files, dir+"/*.txt")
+
+	if err != nil {
+		return nil, err
+	}
+
+	raw, err := files.ReadFile(dir + "/subjects.json")
+
+	if err != nil {
+		return nil, err
+	}
+
+	l := &locale{html: html, text: text}
+
+	if err := json.Unmarshal(raw, &l.subjects); err != nil {
+		return nil, fmt.Errorf("parsing %s/subjects.json: %w", dir, err)
+	}
+
+	return l, nil
+}
+
+// Supported reports whether there are templates for locale.
+func (s *Set) Supported(locale string) bool {
+	_, ok := s.locales[locale]
+
+	return ok
+}
+
+// Match picks the best supported locale for an Accept-Language header.
+func (s *Set) Match(acceptLanguage string) string {
+	tags, _, err := language.ParseAcceptLanguage(acceptLanguage)
+
+	if err != nil || len(tags) == 0 {
+		return DefaultLocale
+	}
+
+	_, i, confidence := s.matcher.Match(tags...)
+
+	if confidence == language.No {
+		return DefaultLocale
+	}
+
+	return s.names[i]
+}
+
+// Render executes the template name, given without extension, in locale,
+// falling back to DefaultLocale.
+func (s *Set) Render(locale, name string, data any) (Email, error) {
+	l, ok := s.locales[locale]
+
+	if !ok {
+		l = s.locales[DefaultLocale]
+	}
+
+	var h, t bytes.Buffer
+
+	if err := l.html.ExecuteTemplate(&h, name+".html", data); err != nil {
+		return Email{}, err
+	}
+
+	if err := l.text.ExecuteTemplate(&t, name+".txt", data); err != nil {
+		return Email{}, err
+	}
+
+	return Email{
+		Subject: l.subjects[name],
+		HTML:    h.String(),
+		Text:    t.String(),
+	}, nil
+}
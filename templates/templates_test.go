@@ -0,0 +1,79 @@
+package templates
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestRender(t *testing.T) {
+	s, err := Parse()
+
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	email, err := s.Render(DefaultLocale, "register", struct {
+		Code  string
+		Email string
+	}{
+		Code:  "123456",
+		Email: "<b>user@nibbin.cl</b>",
+	})
+
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if email.Subject != "Registrar administrador" {
+		t.Errorf("Got subject %s when should be Registrar administrador\n", email.Subject)
+	}
+
+	if !strings.Contains(email.HTML, "&lt;b&gt;user@nibbin.cl&lt;/b&gt;") {
+		t.Errorf("Got html %s when should have the email escaped\n", email.HTML)
+	}
+
+	if !strings.Contains(email.Text, "123456") || strings.Contains(email.Text, "<p>") {
+		t.Errorf("Got text %s when should be plain text with the code\n", email.Text)
+	}
+
+	email, err = s.Render("en", "verification", struct{ Code string }{Code: "123456"})
+
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if email.Subject != "Verification code" {
+		t.Errorf("Got subject %s when should be Verification code\n", email.Subject)
+	}
+
+	if _, err := s.Render(DefaultLocale, "missing", nil); err == nil {
+		t.Errorf("Got no error when rendering a missing template\n")
+	}
+}
+
+func TestMatch(t *testing.T) {
+	s, err := Parse()
+
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	tests := map[string]string{
+		"":                        DefaultLocale,
+		"en-US,en;q=0.9":          "en",
+		"es-ES,es;q=0.9":          DefaultLocale,
+		"fr-FR,en;q=0.5":          "en",
+		"de-DE":                   DefaultLocale,
+		"not a language header;;": DefaultLocale,
+	}
+
+	for header, want := range tests {
+		if got := s.Match(header); got != want {
+			t.Errorf("Got locale %s for %q when should be %s\n", got, header, want)
+		}
+	}
+}